@@ -0,0 +1,93 @@
+package mqtt
+
+import (
+	"sync"
+	"time"
+)
+
+// Bridge subscribes to one or more source brokers and republishes the messages received using a
+// sink client (typically connected to a different broker).
+//
+// Each message is acknowledged to its source once the sink's publish has completed, so a message
+// received at QoS 1 or 2 is not lost if the bridge fails before republishing it. A slow or
+// disconnected sink therefore holds up the sources. If the sink's publish fails the error is
+// logged and the message is dropped (it is still acknowledged to the source).
+type Bridge struct {
+	mu    sync.RWMutex
+	sink  Client
+	topic func(msg Message) string
+	dedup *dedupWindow // nil unless SetDeduplication has been called
+}
+
+// NewBridge creates a Bridge. Call SetSink and then AddSource for each source.
+func NewBridge() *Bridge {
+	return &Bridge{}
+}
+
+// SetDeduplication enables the detection of duplicate messages, for example where the same message
+// is received from more than one source. A message that matches one of the last size messages
+// received within window is not republished.
+//
+// MQTT 3.1.1 messages carry no correlation data so two messages are treated as the same if they
+// have the same topic and payload. This means that legitimate repeats (e.g. a sensor reporting the
+// same reading twice) within the window are also dropped.
+func (b *Bridge) SetDeduplication(size int, window time.Duration) {
+	b.mu.Lock()
+	b.dedup = newDedupWindow(size, window)
+	b.mu.Unlock()
+}
+
+// SetSink sets the client that messages are republished with. topic returns the topic to publish
+// each message to; if it is nil the message is republished to the topic it was received on.
+// Messages received before a sink is set are dropped.
+func (b *Bridge) SetSink(client Client, topic func(msg Message) string) {
+	b.mu.Lock()
+	b.sink, b.topic = client, topic
+	b.mu.Unlock()
+}
+
+// AddSource subscribes (at QoS 1) to filters using client, which must be connected, and bridges
+// the messages received. The returned token completes when the broker acknowledges the
+// subscriptions. To keep bridging following a reconnection the client should be configured to
+// restore its subscriptions (e.g. with SetAutoResubscribe).
+func (b *Bridge) AddSource(client Client, filters []string) Token {
+	subs := make(map[string]byte, len(filters))
+	for _, f := range filters {
+		subs[f] = 1
+	}
+	return client.SubscribeMultiple(subs, b.forward)
+}
+
+// Duplicates returns the number of messages that were not republished because they were duplicates
+func (b *Bridge) Duplicates() uint64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.dedup == nil {
+		return 0
+	}
+	return b.dedup.duplicatesDropped()
+}
+
+// forward is the MessageHandler for all sources
+func (b *Bridge) forward(_ Client, msg Message) {
+	b.mu.RLock()
+	sink, topicFn, dedup := b.sink, b.topic, b.dedup
+	b.mu.RUnlock()
+	if sink == nil {
+		DEBUG.Println(CLI, "bridge has no sink, dropping message on", msg.Topic())
+		return
+	}
+	if dedup != nil && dedup.isDuplicate(msg.Topic(), msg.Payload()) {
+		DEBUG.Println(CLI, "bridge dropping duplicate message on", msg.Topic())
+		return
+	}
+	topic := msg.Topic()
+	if topicFn != nil {
+		topic = topicFn(msg)
+	}
+	// Returning acknowledges the message to the source so wait for the sink to accept it
+	t := sink.Publish(topic, msg.Qos(), msg.Retained(), msg.Payload())
+	if t.Wait() && t.Error() != nil {
+		ERROR.Println(CLI, "bridge failed to republish message on", topic, ":", t.Error())
+	}
+}
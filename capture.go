@@ -0,0 +1,74 @@
+package mqtt
+
+import (
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// PacketDirection indicates whether a captured packet was sent or received by the client
+type PacketDirection int
+
+const (
+	// PacketSent is a packet sent by the client to the broker
+	PacketSent PacketDirection = iota
+	// PacketReceived is a packet received by the client from the broker
+	PacketReceived
+)
+
+func (d PacketDirection) String() string {
+	if d == PacketSent {
+		return "sent"
+	}
+	return "received"
+}
+
+// CapturedPacket is a packet recorded by a PacketCapture
+type CapturedPacket struct {
+	Direction PacketDirection
+	Timestamp time.Time
+	Packet    packets.ControlPacket
+}
+
+// PacketCapture records the packets sent and received by a client (see Diagnostics.StartPacketCapture)
+type PacketCapture struct {
+	mu          sync.Mutex
+	packets     []CapturedPacket
+	unsubscribe func()
+}
+
+// record is the event handler that adds packets to the capture
+func (p *PacketCapture) record(e Event) {
+	var cp CapturedPacket
+	switch ev := e.(type) {
+	case PacketSentEvent:
+		cp = CapturedPacket{Direction: PacketSent, Packet: ev.Packet}
+	case PacketReceivedEvent:
+		cp = CapturedPacket{Direction: PacketReceived, Packet: ev.Packet}
+	default:
+		return
+	}
+	cp.Timestamp = time.Now()
+	p.mu.Lock()
+	p.packets = append(p.packets, cp)
+	p.mu.Unlock()
+}
+
+// Stop ends the capture and returns the packets captured, in the order they were sent/received.
+// Calling Stop more than once returns the same packets.
+func (p *PacketCapture) Stop() []CapturedPacket {
+	p.unsubscribe()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.packets
+}
+
+// StartPacketCapture starts recording all packets sent and received by the client
+func (c *client) StartPacketCapture() *PacketCapture {
+	p := &PacketCapture{}
+	var once sync.Once
+	unsubscribe := c.events.subscribe(p.record)
+	p.unsubscribe = func() { once.Do(unsubscribe) }
+	return p
+}
@@ -19,6 +19,7 @@ package mqtt
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -75,7 +76,10 @@ type Client interface {
 	// Returns a token to track delivery of the message to the broker
 	Publish(topic string, qos byte, retained bool, payload interface{}) Token
 	// Subscribe starts a new subscription. Provide a MessageHandler to be executed when
-	// a message is published on the topic provided, or nil for the default handler
+	// a message is published on the topic provided, or nil for the default handler.
+	// Note that the QoS is a maximum; messages are delivered at the lower of the QoS they
+	// were published with and the QoS granted so, for example, a QoS 2 subscription will
+	// receive (and acknowledge) QoS 1 messages as QoS 1.
 	Subscribe(topic string, qos byte, callback MessageHandler) Token
 	// SubscribeMultiple starts a new subscription for multiple topics. Provide a MessageHandler to
 	// be executed when a message is published on one of the topics provided, or nil for the
@@ -92,6 +96,77 @@ type Client interface {
 	// OptionsReader returns a ClientOptionsReader which is a copy of the clientoptions
 	// in use by the client.
 	OptionsReader() ClientOptionsReader
+	// Healthcheck sends a PINGREQ and waits (until the context is done) for the broker to respond.
+	// Unlike IsConnected this confirms that the broker is actually processing packets.
+	Healthcheck(ctx context.Context) error
+}
+
+// The interfaces below extend Client with features that are not part of the Client interface
+// itself (so that other implementations of Client, such as mocks, need not provide them). Clients
+// created by NewClient implement all of them; use a type assertion to access them, e.g.
+//
+//	if d, ok := c.(mqtt.Drainer); ok {
+//		err = d.Drain(time.Second)
+//	}
+
+// Drainer is implemented by clients that can complete outstanding publishes before disconnecting
+type Drainer interface {
+	// Drain stops new publishes (Publish fails with ErrDraining) and waits, for up to timeout,
+	// for publishes already made to complete. Messages continue to be received; call Disconnect
+	// once the drain is complete.
+	Drain(timeout time.Duration) error
+}
+
+// ExtendedPublisher is implemented by clients that provide variants of Publish
+type ExtendedPublisher interface {
+	// Fire publishes a non-retained QoS 0 message without returning a token. It returns once the
+	// message has been queued for sending; only errors detected before then are reported.
+	Fire(topic string, payload interface{}) error
+	// FanoutPublish publishes the same message to each of topics, returning a token per topic
+	FanoutPublish(topics []string, qos byte, retained bool, payload interface{}) []Token
+	// PublishWithDeadline publishes as Publish but fails the token (with ErrPublishDeadlineExceeded,
+	// or the context error) if the publish has not completed by deadline or ctx is done first
+	PublishWithDeadline(ctx context.Context, deadline time.Time, topic string, qos byte, retained bool, payload interface{}) Token
+	// PriorityPublish publishes as Publish but, when publishes are waiting to be sent, those made
+	// with a higher priority are sent before those with a lower priority
+	PriorityPublish(priority int, topic string, qos byte, retained bool, payload interface{}) Token
+	// PublishTemplate publishes as Publish to the topic rendered from tmpl with data
+	PublishTemplate(tmpl *TopicTemplate, data interface{}, qos byte, retained bool, payload interface{}) Token
+}
+
+// ExtendedSubscriber is implemented by clients that provide variants of Subscribe and AddRoute
+type ExtendedSubscriber interface {
+	// SubscribeFilters starts a new subscription for each of the filters provided, each of which
+	// may have its own MessageHandler (nil for the default handler)
+	SubscribeFilters(filters []SubscriptionFilter) Token
+	// SubscribeAsync subscribes in the background, retrying until the subscription is acknowledged
+	// (or the client stops); it may be called before Connect
+	SubscribeAsync(filter string, qos byte, handler MessageHandler)
+	// AddRouteWithContext is the same as AddRoute but the handler is passed a context that is
+	// cancelled when the connection the message arrived on is closed
+	AddRouteWithContext(topic string, callback MessageHandlerWithContext)
+}
+
+// Diagnostics is implemented by clients that provide information for monitoring and debugging
+type Diagnostics interface {
+	// RecentConnectionEvents returns the connection event log (see SetConnectionEventLog), oldest first
+	RecentConnectionEvents() []ConnectionEvent
+	// PacketMetrics returns the number of packets of each type (e.g. "PUBLISH") sent and received
+	PacketMetrics() map[string]PacketTypeMetric
+	// ResetPacketMetrics sets the counts returned by PacketMetrics to zero
+	ResetPacketMetrics()
+	// StartPacketCapture starts recording all packets sent and received by the client (until
+	// Stop is called on the returned PacketCapture). Intended for debugging and testing.
+	StartPacketCapture() *PacketCapture
+	// ReconnectAttempts returns the number of failed connection attempts made since the connection
+	// was lost (0 when connected or if the client has not needed to reconnect)
+	ReconnectAttempts() int
+	// DuplicatesDropped returns the number of QoS 0 messages that have been discarded as
+	// duplicates (always 0 unless ClientOptions.SetDeduplicationWindow has been called)
+	DuplicatesDropped() uint64
+	// SubscriptionTree returns the subscriptions acknowledged by the broker rendered as an ASCII
+	// tree, with a branch for each topic level (intended for diagnostics)
+	SubscriptionTree() string
 }
 
 // client implements the Client interface
@@ -100,14 +175,33 @@ type client struct {
 	lastReceived    atomic.Value // time.Time - the last time a packet was successfully received from network
 	pingOutstanding int32        // set to 1 if a ping has been sent but response not ret received
 
+	pingWaiters   []pingWaiter // Healthcheck calls waiting for a ping response
+	pingsSent     uint64       // PINGREQs written (protected by pingWaitersMu)
+	pingResps     uint64       // PINGRESPs received (protected by pingWaitersMu)
+	pingWaitersMu sync.Mutex
+
+	reconnectAttempts int32  // number of failed attempts made by the current reconnect (accessed atomically)
+	draining          uint32 // set to 1 by Drain to reject new publishes (accessed atomically)
+	queueAlarmRaised  uint32 // set to 1 while the publish queue is above the high water mark (accessed atomically)
+
 	status       uint32 // see consts at top of file for possible values
 	sync.RWMutex        // Protects the above two variables (note: atomic writes are also used somewhat inconsistently)
 
-	messageIds // effectively a map from message id to token completor
+	messageIds          // effectively a map from message id to token completor
+	events     eventBus // distributes internal events (connection state, packets, inflight count)
 
 	obound    chan *PacketAndToken // outgoing publish packet
 	oboundP   chan *PacketAndToken // outgoing 'priotity' packet (anything other than publish)
 	msgRouter *router              // routes topics to handlers
+	subs      subscriptionRegistry // subscriptions acknowledged by the broker (restored if the session is lost)
+	dedup     *dedupWindow         // detects duplicate QoS 0 messages (nil if disabled)
+	eventLog  *connectionEventLog  // recent connection events (nil if disabled)
+
+	packetMetrics *packetMetrics // packets sent and received by type (a pointer so the counters are 64-bit aligned)
+
+	topicQueues topicQueues   // publishes waiting to be sent to each topic (only used with PerTopicOrdering)
+	priorityQ   priorityQueue // publishes made with PriorityPublish waiting to be sent
+
 	persist   Store
 	options   ClientOptions
 	optionsMu sync.Mutex // Protects the options in a few limited cases where needed for testing
@@ -115,11 +209,15 @@ type client struct {
 	conn   net.Conn   // the network connection, must only be set with connMu locked (only used when starting/stopping workers)
 	connMu sync.Mutex // mutex for the connection (again only used in two functions)
 
-	stop         chan struct{}        // Closed to request that workers stop
-	workers      sync.WaitGroup       // used to wait for workers to complete (ping, keepalive, errwatch, resume)
-	commsStopped chan struct{}        // closed when the comms routines have stopped (kept running until after workers have closed to avoid deadlocks)
-	commsobound  chan *PacketAndToken // outgoing publish packets serviced by active comms go routines (maintains compatibility)
-	commsoboundP chan *PacketAndToken // outgoing 'priotity' packet serviced by active comms go routines (maintains compatibility)
+	stop           chan struct{}        // Closed to request that workers stop
+	cancelHandlers context.CancelFunc   // Cancels the context passed to message handlers
+	workers        sync.WaitGroup       // used to wait for workers to complete (ping, keepalive, errwatch, resume)
+	commsStopped   chan struct{}        // closed when the comms routines have stopped (kept running until after workers have closed to avoid deadlocks)
+	commsobound    chan *PacketAndToken // outgoing publish packets serviced by active comms go routines (maintains compatibility)
+	commsoboundP   chan *PacketAndToken // outgoing 'priotity' packet serviced by active comms go routines (maintains compatibility)
+
+	done   chan struct{} // closed (and replaced) when the client stops, see stopped()
+	doneMu sync.Mutex
 }
 
 // NewClient will create an MQTT v3.1.1 client with all of the options specified
@@ -142,13 +240,56 @@ func NewClient(o *ClientOptions) Client {
 		c.options.ProtocolVersion = 4
 		c.options.protocolVersionExplicit = false
 	}
+	if err := c.validateClientID(); err != nil {
+		ERROR.Println(CLI, err)
+	}
+	if c.options.TLSUseSystemCerts || len(c.options.TLSExtraCACerts) > 0 {
+		c.options.TLSConfig = systemCertsTLSConfig(c.options.TLSConfig, c.options.TLSUseSystemCerts, c.options.TLSExtraCACerts)
+	}
+	if len(c.options.CertificatePins) > 0 {
+		c.options.TLSConfig = pinnedTLSConfig(c.options.TLSConfig, c.options.CertificatePins)
+	}
 	c.persist = c.options.Store
 	c.status = disconnected
-	c.messageIds = messageIds{index: make(map[uint16]tokenCompletor)}
+	c.messageIds = messageIds{index: make(map[uint16]tokenCompletor), inflightChanged: func(inflight int) {
+		c.events.publish(InflightChangedEvent{Inflight: inflight})
+	}}
+	c.events.subscribe(c.callbackEvents)
+	if c.options.EventHandler != nil {
+		c.events.subscribe(newAsyncEventHandler(c.options.EventHandler, eventQueueSize).handle)
+	}
+	c.packetMetrics = &packetMetrics{}
+	c.events.subscribe(c.packetMetrics.record)
+	if c.options.ConnectionEventLogSize > 0 {
+		c.eventLog = newConnectionEventLog(c.options.ConnectionEventLogSize)
+		c.events.subscribe(c.eventLog.record)
+	}
+	if c.options.PacketLogLevel > LogNone {
+		c.events.subscribe(c.packetLogEvents)
+	}
+	if c.options.QoSAckCallback != nil {
+		c.events.subscribe(newQoSAckHandler(c.options.QoSAckCallback))
+	}
 	c.msgRouter = newRouter()
-	c.msgRouter.setDefaultHandler(c.options.DefaultPublishHandler)
-	c.obound = make(chan *PacketAndToken)
-	c.oboundP = make(chan *PacketAndToken)
+	if c.options.DefaultPublishHandlerWithContext != nil {
+		c.msgRouter.setDefaultHandlerWithContext(c.options.DefaultPublishHandlerWithContext)
+	} else {
+		c.msgRouter.setDefaultHandler(c.options.DefaultPublishHandler)
+	}
+	if c.options.DeduplicationSize > 0 {
+		c.dedup = newDedupWindow(c.options.DeduplicationSize, c.options.DeduplicationTTL)
+	}
+	if c.options.SubscriptionManager != nil {
+		subs, err := c.options.SubscriptionManager.Load()
+		if err != nil {
+			WARN.Println(CLI, "unable to load persisted subscriptions:", err)
+		}
+		for filter, qos := range subs {
+			c.subs.add(filter, qos)
+		}
+	}
+	c.obound = make(chan *PacketAndToken, c.options.BufferSizes.PublishQueue)
+	c.oboundP = make(chan *PacketAndToken, c.options.BufferSizes.SubscribeQueue)
 	return c
 }
 
@@ -161,6 +302,14 @@ func (c *client) AddRoute(topic string, callback MessageHandler) {
 	}
 }
 
+// AddRouteWithContext is the same as AddRoute but the handler is passed a context that is
+// cancelled when the connection the message arrived on is closed
+func (c *client) AddRouteWithContext(topic string, callback MessageHandlerWithContext) {
+	if callback != nil {
+		c.msgRouter.addRouteWithContext(topic, callback)
+	}
+}
+
 // IsConnected returns a bool signifying whether
 // the client is connected or not.
 // connected means that the connection is up now OR it will
@@ -218,6 +367,7 @@ var ErrNotConnected = errors.New("not Connected")
 func (c *client) Connect() Token {
 	t := newToken(packets.Connect).(*ConnectToken)
 	DEBUG.Println(CLI, "Connect()")
+	atomic.StoreUint32(&c.draining, 0)
 
 	if c.options.ConnectRetry && atomic.LoadUint32(&c.status) != disconnected {
 		// if in any state other than disconnected and ConnectRetry is
@@ -245,9 +395,16 @@ func (c *client) Connect() Token {
 		var conn net.Conn
 		var rc byte
 		var err error
+		if err = c.preConnect(); err != nil {
+			ERROR.Println(CLI, "pre-connect handler failed:", err)
+			c.setConnected(disconnected)
+			c.persist.Close()
+			t.setError(err)
+			return
+		}
 		conn, rc, t.sessionPresent, err = c.attemptConnection()
 		if err != nil {
-			if c.options.ConnectRetry {
+			if c.options.ConnectRetry && !isConfigError(err) {
 				DEBUG.Println(CLI, "Connect failed, sleeping for", int(c.options.ConnectRetryInterval.Seconds()), "seconds and will then retry")
 				time.Sleep(c.options.ConnectRetryInterval)
 
@@ -263,13 +420,17 @@ func (c *client) Connect() Token {
 			return
 		}
 		inboundFromStore := make(chan packets.ControlPacket) // there may be some inbound comms packets in the store that are awaitring processing
-		if c.startCommsWorkers(conn, inboundFromStore) {
+		if c.startCommsWorkers(conn, t.sessionPresent, inboundFromStore) {
 			// Take care of any messages in the store
 			if !c.options.CleanSession {
 				c.resume(c.options.ResumeSubs, inboundFromStore)
 			} else {
 				c.persist.Reset()
 			}
+			// Restore persisted subscriptions if the broker does not hold them
+			if c.options.SubscriptionManager != nil && !t.sessionPresent {
+				c.resubscribe()
+			}
 		} else {
 			WARN.Println(CLI, "Connect() called but connection established in another goroutine")
 		}
@@ -285,19 +446,41 @@ func (c *client) Connect() Token {
 func (c *client) reconnect() {
 	DEBUG.Println(CLI, "enter reconnect")
 	var (
-		sleep = time.Duration(1 * time.Second)
-		conn  net.Conn
+		sleep          = time.Duration(1 * time.Second)
+		conn           net.Conn
+		sessionPresent bool
 	)
 
 	for {
 		if nil != c.options.OnReconnecting {
 			c.options.OnReconnecting(c, &c.options)
 		}
+		if c.options.connectLimiter != nil {
+			if wait := c.options.connectLimiter.reserve(); wait > 0 {
+				DEBUG.Println(CLI, "reconnect rate limited, waiting", wait)
+				time.Sleep(wait)
+			}
+		}
 		var err error
-		conn, _, _, err = c.attemptConnection()
+		if err = c.preConnect(); err != nil {
+			ERROR.Println(CLI, "pre-connect handler failed:", err)
+			c.abandonReconnect(err)
+			return
+		}
+		conn, _, sessionPresent, err = c.attemptConnection()
 		if err == nil {
 			break
 		}
+		if isConfigError(err) { // Retrying cannot succeed
+			c.abandonReconnect(err)
+			return
+		}
+		attempts := int(atomic.AddInt32(&c.reconnectAttempts, 1))
+		if c.options.MaxReconnectAttempts > 0 && attempts >= c.options.MaxReconnectAttempts {
+			ERROR.Println(CLI, "giving up after", attempts, "reconnect attempts:", err)
+			c.abandonReconnect(&MaxReconnectAttemptsError{Attempts: attempts, Err: err})
+			return
+		}
 		DEBUG.Println(CLI, "Reconnect failed, sleeping for", int(sleep.Seconds()), "seconds:", err)
 		time.Sleep(sleep)
 		if sleep < c.options.MaxReconnectInterval {
@@ -320,13 +503,153 @@ func (c *client) reconnect() {
 		return
 	}
 
+	atomic.StoreInt32(&c.reconnectAttempts, 0)
 	inboundFromStore := make(chan packets.ControlPacket) // there may be some inbound comms packets in the store that are awaitring processing
-	if c.startCommsWorkers(conn, inboundFromStore) {
+	if c.startCommsWorkers(conn, sessionPresent, inboundFromStore) {
 		c.resume(c.options.ResumeSubs, inboundFromStore)
+		if (c.options.AutoResubscribe || c.options.SubscriptionManager != nil) &&
+			(!sessionPresent || !c.options.SkipResubscribeIfSessionPresent) {
+			c.resubscribe()
+		}
 	}
 	close(inboundFromStore)
 }
 
+//ErrMaxReconnectAttemptsExceeded is the error passed to the ConnectionLostHandler (wrapped in a
+//MaxReconnectAttemptsError) when the client stops reconnecting after MaxReconnectAttempts failures
+var ErrMaxReconnectAttemptsExceeded = errors.New("maximum reconnect attempts exceeded")
+
+// MaxReconnectAttemptsError is passed to the ConnectionLostHandler when the client gives up
+// reconnecting. errors.Is(err, ErrMaxReconnectAttemptsExceeded) is true and errors.Unwrap returns
+// the error from the last connection attempt.
+type MaxReconnectAttemptsError struct {
+	Attempts int   // number of attempts made
+	Err      error // error from the last attempt
+}
+
+func (e *MaxReconnectAttemptsError) Error() string {
+	return fmt.Sprintf("%s (%d attempts): %v", ErrMaxReconnectAttemptsExceeded, e.Attempts, e.Err)
+}
+
+// Is allows errors.Is to match ErrMaxReconnectAttemptsExceeded
+func (e *MaxReconnectAttemptsError) Is(target error) bool {
+	return target == ErrMaxReconnectAttemptsExceeded
+}
+
+// Unwrap returns the error from the last connection attempt
+func (e *MaxReconnectAttemptsError) Unwrap() error {
+	return e.Err
+}
+
+//ErrInvalidClientID is matched (using errors.Is) by the InvalidClientIDError returned when the
+//ClientIDValidator rejects the client id
+var ErrInvalidClientID = errors.New("invalid client id")
+
+// InvalidClientIDError is returned by Connect (or passed to the ConnectionLostHandler) when the
+// ClientIDValidator rejects the client id. errors.Is(err, ErrInvalidClientID) is true and
+// errors.Unwrap returns the error from the validator.
+type InvalidClientIDError struct {
+	ClientID string
+	Err      error // error returned by the validator
+}
+
+func (e *InvalidClientIDError) Error() string {
+	return fmt.Sprintf("%s %q: %v", ErrInvalidClientID, e.ClientID, e.Err)
+}
+
+// Is allows errors.Is to match ErrInvalidClientID
+func (e *InvalidClientIDError) Is(target error) bool {
+	return target == ErrInvalidClientID
+}
+
+// Unwrap returns the error from the validator
+func (e *InvalidClientIDError) Unwrap() error {
+	return e.Err
+}
+
+// validateClientID checks the client id with the ClientIDValidator (if set)
+func (c *client) validateClientID() error {
+	if c.options.ClientIDValidator == nil {
+		return nil
+	}
+	if err := c.options.ClientIDValidator(c.options.ClientID); err != nil {
+		return &InvalidClientIDError{ClientID: c.options.ClientID, Err: err}
+	}
+	return nil
+}
+
+// preConnect calls the OnPreConnect handler (if set)
+func (c *client) preConnect() error {
+	if c.options.OnPreConnect == nil {
+		return nil
+	}
+	c.optionsMu.Lock()
+	defer c.optionsMu.Unlock()
+	return c.options.OnPreConnect(&c.options)
+}
+
+// abandonReconnect moves the client to the disconnected state after reconnection has failed,
+// reporting err to the ConnectionLostHandler
+func (c *client) abandonReconnect(err error) {
+	c.setConnected(disconnected)
+	c.markStopped()
+	c.topicQueues.stopAll()
+	if c.options.CleanSession {
+		c.messageIds.cleanUp()
+	}
+	c.events.publish(DisconnectedEvent{Err: err})
+}
+
+// topicAllowed returns false if a TopicACL has been set and it rejects the operation on topic
+func (c *client) topicAllowed(topic string, op ACLOperation) bool {
+	return c.options.TopicACL == nil || c.options.TopicACL(topic, op)
+}
+
+// ReconnectAttempts returns the number of failed connection attempts made since the connection was lost
+func (c *client) ReconnectAttempts() int {
+	return int(atomic.LoadInt32(&c.reconnectAttempts))
+}
+
+// resubscribe restores the subscriptions in the registry (used following a reconnection)
+func (c *client) resubscribe() {
+	filters := c.subs.snapshot()
+	if len(filters) == 0 {
+		return
+	}
+	if c.options.ResubscribeHook != nil {
+		for filter, qos := range filters {
+			if newQos := c.options.ResubscribeHook(filter, qos); newQos != qos {
+				DEBUG.Println(CLI, "resubscribe hook changed qos for", filter, "from", qos, "to", newQos)
+				filters[filter] = newQos
+			}
+		}
+	}
+	DEBUG.Println(CLI, "resubscribing to", len(filters), "topics")
+	// Routes are retained over reconnections so no callback is needed
+	t := c.SubscribeMultiple(filters, nil)
+	go func() {
+		if t.Wait() && t.Error() != nil {
+			ERROR.Println(CLI, "failed to resubscribe:", t.Error())
+		}
+	}()
+}
+
+// addSubscription records an acknowledged subscription
+func (c *client) addSubscription(filter string, qos byte) {
+	c.subs.add(filter, qos)
+	c.saveSubscriptions()
+}
+
+// saveSubscriptions passes the current subscriptions to the SubscriptionManager (if any)
+func (c *client) saveSubscriptions() {
+	if c.options.SubscriptionManager == nil {
+		return
+	}
+	if err := c.options.SubscriptionManager.Save(c.subs.snapshot()); err != nil {
+		ERROR.Println(CLI, "unable to persist subscriptions:", err)
+	}
+}
+
 // attemptConnection makes a single attempt to connect to each of the brokers
 // the protocol version to use is passed in (as c.options.ProtocolVersion)
 // Note: Does not set c.conn in order to minimise race conditions
@@ -344,15 +667,28 @@ func (c *client) attemptConnection() (net.Conn, byte, bool, error) {
 		rc             byte
 	)
 
+	if err = c.validateClientID(); err != nil {
+		ERROR.Println(CLI, err)
+		return nil, packets.ErrRefusedIDRejected, false, err
+	}
+
 	c.optionsMu.Lock() // Protect c.options.Servers so that servers can be added in test cases
 	brokers := c.options.Servers
 	c.optionsMu.Unlock()
 	for _, broker := range brokers {
 		cm := newConnectMsgFromOptions(&c.options, broker)
+		if err = checkConnectPacketSize(cm, protocolVersion, c.options.MaxConnectPacketSize); err != nil {
+			ERROR.Println(CLI, "connect packet is", connectPacketSize(cm, protocolVersion), "bytes:", err)
+			return nil, packets.ErrProtocolViolation, false, err
+		}
 		DEBUG.Println(CLI, "about to write new connect msg")
 	CONN:
 		// Start by opening the network connection (tcp, tls, ws) etc
-		conn, err = openConnection(broker, c.options.TLSConfig, c.options.ConnectTimeout, c.options.HTTPHeaders)
+		if c.options.CustomOpenConnectionFn != nil {
+			conn, err = c.options.CustomOpenConnectionFn(broker, c.options)
+		} else {
+			conn, err = openConnection(broker, c.options.TLSConfig, c.options.ConnectTimeout, c.options.HTTPHeaders)
+		}
 		if err != nil {
 			ERROR.Println(CLI, err.Error())
 			WARN.Println(CLI, "failed to connect to broker, trying next")
@@ -363,6 +699,13 @@ func (c *client) attemptConnection() (net.Conn, byte, bool, error) {
 
 		// Now we send the perform the MQTT connection handshake
 		rc, sessionPresent = ConnectMQTT(conn, cm, protocolVersion)
+		c.events.publish(PacketSentEvent{Packet: cm})
+		if rc != packets.ErrNetworkError {
+			ca := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			ca.ReturnCode = rc
+			ca.SessionPresent = sessionPresent
+			c.events.publish(PacketReceivedEvent{Packet: ca})
+		}
 		if rc == packets.Accepted {
 			break // successfully connected
 		}
@@ -371,13 +714,15 @@ func (c *client) attemptConnection() (net.Conn, byte, bool, error) {
 		if conn != nil {
 			conn.Close()
 		}
-		if !c.options.protocolVersionExplicit && protocolVersion == 4 { // try falling back to 3.1?
-			DEBUG.Println(CLI, "Trying reconnect using MQTT 3.1 protocol")
+		fallback := !c.options.protocolVersionExplicit ||
+			(c.options.ProtocolVersionFallback && rc == packets.ErrRefusedBadProtocolVersion)
+		if fallback && protocolVersion == 4 { // try falling back to 3.1?
+			WARN.Println(CLI, "Trying reconnect using MQTT 3.1 protocol")
 			protocolVersion = 3
 			goto CONN
 		}
 		if c.options.protocolVersionExplicit { // to maintain logging from previous version
-			ERROR.Println(CLI, "Connecting to", broker, "CONNACK was not CONN_ACCEPTED, but rather", err.Error())
+			ERROR.Println(CLI, "Connecting to", broker, "CONNACK was not CONN_ACCEPTED, but rather", packets.ConnackReturnCodes[rc])
 		}
 	}
 	// If the connection was successful we set member variable and lock in the protocol version for future connection attempts (and users)
@@ -388,6 +733,9 @@ func (c *client) attemptConnection() (net.Conn, byte, bool, error) {
 		// Maintain same error format as used previously
 		if rc != packets.ErrNetworkError { // mqtt error
 			err = packets.ConnErrors[rc]
+			if c.options.DetailedConnectErrors {
+				err = &ConnectError{ReturnCode: rc, protocolVersion: byte(protocolVersion) &^ 0x80}
+			}
 		} else { // network error (if this occured in ConnectMQTT then err will be nil)
 			err = fmt.Errorf("%s : %s", packets.ConnErrors[rc], err)
 		}
@@ -395,6 +743,59 @@ func (c *client) attemptConnection() (net.Conn, byte, bool, error) {
 	return conn, rc, sessionPresent, err
 }
 
+// ConnectError is returned (via the Connect token) when the broker refuses the connection and
+// SetDetailedConnectErrors is enabled. Its message matches the corresponding error in
+// packets.ConnErrors and errors.Is(err, packets.ConnErrors[code]) is true.
+type ConnectError struct {
+	ReturnCode      byte // return code from the CONNACK
+	protocolVersion byte
+}
+
+// ProtocolVersion returns the protocol level used for the connection attempt (3 for MQTT 3.1,
+// 4 for MQTT 3.1.1) which determines the meaning of the code
+func (e *ConnectError) ProtocolVersion() byte {
+	return e.protocolVersion
+}
+
+func (e *ConnectError) Error() string {
+	if err := packets.ConnErrors[e.ReturnCode]; err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("connection refused, return code %d", e.ReturnCode)
+}
+
+// Unwrap returns the matching error from packets.ConnErrors
+func (e *ConnectError) Unwrap() error {
+	return packets.ConnErrors[e.ReturnCode]
+}
+
+// ShouldRetry returns true if err (as returned by Connect) may be transient, so a later connection
+// attempt could succeed. A refusal because the server is unavailable is transient whereas a bad
+// protocol version, rejected client identifier, bad credentials or lack of authorisation are
+// permanent, as are a client id rejected by the ClientIDValidator and ErrConnectPacketTooLarge.
+// Other errors (e.g. network failures) are treated as transient.
+func ShouldRetry(err error) bool {
+	if err == nil {
+		return false
+	}
+	if isConfigError(err) {
+		return false
+	}
+	for _, rc := range []byte{packets.ErrRefusedBadProtocolVersion, packets.ErrRefusedIDRejected,
+		packets.ErrRefusedBadUsernameOrPassword, packets.ErrRefusedNotAuthorised} {
+		if errors.Is(err, packets.ConnErrors[rc]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isConfigError returns true if err is caused by the client's options (so the connection attempt
+// will fail however often it is retried)
+func isConfigError(err error) bool {
+	return errors.Is(err, ErrInvalidClientID) || errors.Is(err, ErrConnectPacketTooLarge)
+}
+
 // Disconnect will end the connection with the server, but not before waiting
 // the specified number of milliseconds to wait for existing work to be
 // completed.
@@ -418,6 +819,109 @@ func (c *client) Disconnect(quiesce uint) {
 	}
 
 	c.disconnect()
+	if status == connected {
+		c.events.publish(DisconnectedEvent{})
+	}
+}
+
+//ErrDraining is the error set on the Publish token when Drain has been called
+var ErrDraining = errors.New("client is draining")
+
+//ErrDrainTimeout is returned by Drain if publishes are still outstanding when the timeout expires
+var ErrDrainTimeout = errors.New("publishes still outstanding at end of drain")
+
+// Drain stops new publishes and waits for those already made to complete (be acknowledged, or
+// for QoS 0 written). Incoming messages continue to be delivered. A timeout of 0 waits
+// indefinitely. If the connection is lost with publishes still outstanding ErrNotConnected is
+// returned (they cannot complete until the client reconnects). Connect clears the draining state.
+func (c *client) Drain(timeout time.Duration) error {
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+	atomic.StoreUint32(&c.draining, 1)
+	DEBUG.Println(CLI, "draining")
+
+	// The number pending can only fall when a publish is written, acknowledged or abandoned
+	changed := make(chan struct{}, 1)
+	lost := make(chan struct{}, 1)
+	unsubscribe := c.events.subscribe(func(e Event) {
+		notify := changed
+		switch ev := e.(type) {
+		case PacketSentEvent:
+			if _, ok := ev.Packet.(*packets.PublishPacket); !ok {
+				return
+			}
+		case InflightChangedEvent:
+		case DisconnectedEvent:
+			notify = lost
+		default:
+			return
+		}
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	})
+	defer unsubscribe()
+	if !c.IsConnectionOpen() { // lost before the subscription was made
+		select {
+		case lost <- struct{}{}:
+		default:
+		}
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for c.pendingPublishes() > 0 {
+		select {
+		case <-changed:
+		case <-lost:
+			if c.pendingPublishes() > 0 {
+				DEBUG.Println(CLI, "connection lost while draining")
+				return ErrNotConnected
+			}
+		case <-expired:
+			return ErrDrainTimeout
+		}
+	}
+	DEBUG.Println(CLI, "drain complete")
+	return nil
+}
+
+// checkPublishQueueAlarm calls the PublishQueueAlarm if the length of the publish queue has
+// crossed a water mark
+func (c *client) checkPublishQueueAlarm() {
+	if c.options.PublishQueueAlarm == nil {
+		return
+	}
+	n := len(c.obound)
+	if n > c.options.PublishQueueHighWaterMark && atomic.CompareAndSwapUint32(&c.queueAlarmRaised, 0, 1) {
+		WARN.Println(CLI, "publish queue above high water mark:", n)
+		c.options.PublishQueueAlarm(true)
+	} else if n < c.options.PublishQueueLowWaterMark && atomic.CompareAndSwapUint32(&c.queueAlarmRaised, 1, 0) {
+		DEBUG.Println(CLI, "publish queue below low water mark:", n)
+		c.options.PublishQueueAlarm(false)
+	}
+}
+
+// pendingPublishes returns the number of publishes that are queued or awaiting acknowledgement
+func (c *client) pendingPublishes() int {
+	n := len(c.obound) + c.topicQueues.pendingQoS0()
+	c.priorityQ.mu.Lock()
+	n += len(c.priorityQ.items)
+	c.priorityQ.mu.Unlock()
+	c.messageIds.RLock()
+	for _, t := range c.messageIds.index {
+		if _, ok := t.(*PublishToken); ok {
+			n++
+		}
+	}
+	c.messageIds.RUnlock()
+	return n
 }
 
 // forceDisconnect will end the connection with the mqtt broker immediately (used for tests only)
@@ -433,12 +937,35 @@ func (c *client) forceDisconnect() {
 
 // disconnect cleans up after a final disconnection (user requested so no auto reconnection)
 func (c *client) disconnect() {
+	c.markStopped()
+	c.topicQueues.stopAll()
 	c.stopCommsWorkers()
 	c.messageIds.cleanUp()
 	DEBUG.Println(CLI, "disconnected")
 	c.persist.Close()
 }
 
+// stopped returns a channel that is closed when the client next stops; that is, Disconnect is
+// called or the connection is lost and will not be re-established
+func (c *client) stopped() <-chan struct{} {
+	c.doneMu.Lock()
+	defer c.doneMu.Unlock()
+	if c.done == nil {
+		c.done = make(chan struct{})
+	}
+	return c.done
+}
+
+// markStopped closes the channel returned by stopped (a new channel is returned by later calls)
+func (c *client) markStopped() {
+	c.doneMu.Lock()
+	defer c.doneMu.Unlock()
+	if c.done != nil {
+		close(c.done)
+		c.done = nil
+	}
+}
+
 // internalConnLost cleanup when connection is lost or an error occurs
 func (c *client) internalConnLost(err error) {
 	// It is possible that internalConnLost will be called multiple times simultaneously
@@ -456,18 +983,18 @@ func (c *client) internalConnLost(err error) {
 			go c.reconnect()
 		} else {
 			c.setConnected(disconnected)
+			c.markStopped()
 		}
-		if c.options.OnConnectionLost != nil {
-			go c.options.OnConnectionLost(c, err)
-		}
+		c.events.publish(DisconnectedEvent{Err: err})
 	}
 	DEBUG.Println(CLI, "internalConnLost exiting")
 }
 
 // startCommsWorkers is called when the connection is up. It starts off all of the routines needed to process incomming and
 // outdoing messages.
+// sessionPresent is the flag from the CONNACK (passed on in the ConnectedEvent)
 // Returns true if the comms workers were started (i.e. they were not already running)
-func (c *client) startCommsWorkers(conn net.Conn, inboundFromStore <-chan packets.ControlPacket) bool {
+func (c *client) startCommsWorkers(conn net.Conn, sessionPresent bool, inboundFromStore <-chan packets.ControlPacket) bool {
 	DEBUG.Println(CLI, "startCommsWorkers called")
 	c.connMu.Lock()
 	defer c.connMu.Unlock()
@@ -487,18 +1014,24 @@ func (c *client) startCommsWorkers(conn net.Conn, inboundFromStore <-chan packet
 		go keepalive(c, conn)
 	}
 
-	incomingPubChan := make(chan *packets.PublishPacket)
+	// The context passed to handlers is cancelled when the connection is closed
+	var handlerCtx context.Context
+	handlerCtx, c.cancelHandlers = context.WithCancel(context.Background())
+	receiveQueue := int(c.options.BufferSizes.MsgReceiveQueue)
+	incomingPubChan := make(chan *packets.PublishPacket, receiveQueue)
+	concurrency := c.options.handlerConcurrency()
 	c.workers.Add(1)
 	go func() {
-		c.msgRouter.matchAndDispatch(incomingPubChan, c.options.Order, c)
+		c.msgRouter.matchAndDispatch(handlerCtx, incomingPubChan, concurrency, c)
 		c.workers.Done()
 	}()
 
+	// Anything left queued while the connection was down must be discarded before the status
+	// changes (after which OnConnect handlers may queue packets that need to be sent)
+	c.discardQueued()
 	c.setConnected(connected)
 	DEBUG.Println(CLI, "client is connected/reconnected")
-	if c.options.OnConnect != nil {
-		go c.options.OnConnect(c)
-	}
+	c.events.publish(ConnectedEvent{SessionPresent: sessionPresent})
 
 	// c.oboundP and c.obound need to stay active for the life of the client because, depending upon the options,
 	// messages may be published while the client is disconnected (they will block unless in a goroutine). However
@@ -513,6 +1046,7 @@ func (c *client) startCommsWorkers(conn net.Conn, inboundFromStore <-chan packet
 			case msg := <-c.oboundP:
 				c.commsoboundP <- msg
 			case msg := <-c.obound:
+				c.checkPublishQueueAlarm()
 				c.commsobound <- msg
 			case <-c.stop:
 				DEBUG.Println(CLI, "startCommsWorkers output redirector finnished")
@@ -536,6 +1070,10 @@ func (c *client) startCommsWorkers(conn net.Conn, inboundFromStore <-chan packet
 					commsIncommingPub = nil
 					continue
 				}
+				if pub.Qos == 0 && c.dedup != nil && c.dedup.isDuplicate(pub.TopicName, pub.Payload) {
+					DEBUG.Println(CLI, "dropping duplicate message, topic:", pub.TopicName)
+					continue
+				}
 				incomingPubChan <- pub
 			case err, ok := <-commsErrors:
 				if !ok {
@@ -577,6 +1115,7 @@ func (c *client) stopCommsWorkers() bool {
 	close(c.stop)  // Signal for workers to stop
 	c.conn.Close() // Possible that this is already closed but no harm in closing again
 	c.conn = nil
+	c.cancelHandlers() // Let any long running message handlers know that the connection has gone
 
 	DEBUG.Println(CLI, "stopCommsWorkers waiting for workers")
 	c.workers.Wait()
@@ -586,21 +1125,80 @@ func (c *client) stopCommsWorkers() bool {
 	close(c.commsoboundP)
 	DEBUG.Println(CLI, "stopCommsWorkers waiting for comms")
 	<-c.commsStopped // wait for comms routine to stop
+	c.discardQueued()
 
 	DEBUG.Println(CLI, "stopCommsWorkers done")
 	return true
 }
 
+// discardQueued empties obound and oboundP, which may hold packets if they are buffered (see
+// SetInternalBufferSize), when there is no connection. Packets held in the store are left to
+// resume() (as are packets that were sent but not acknowledged) so they are not sent twice; the
+// tokens of other packets are failed with ErrNotConnected.
+func (c *client) discardQueued() {
+	stored := make(map[string]struct{})
+	for _, key := range c.persist.All() {
+		stored[key] = struct{}{}
+	}
+	discard := func(pt *PacketAndToken) {
+		if _, ok := stored[outboundKeyFromMID(pt.p.Details().MessageID)]; ok {
+			switch pt.p.(type) {
+			case *packets.PublishPacket, *packets.PubrelPacket, *packets.SubscribePacket, *packets.UnsubscribePacket:
+				DEBUG.Println(CLI, "discarding queued packet (will be resumed from the store):", pt.p.Details().MessageID)
+				return
+			}
+		}
+		DEBUG.Println(CLI, "discarding queued packet:", pt.p.String())
+		if pt.t != nil {
+			pt.t.setError(ErrNotConnected)
+		}
+	}
+	for {
+		select {
+		case pt := <-c.obound:
+			discard(pt)
+		case pt := <-c.oboundP:
+			discard(pt)
+		default:
+			c.checkPublishQueueAlarm()
+			return
+		}
+	}
+}
+
 // Publish will publish a message with the specified QoS and content
 // to the specified topic.
 // Returns a token to track delivery of the message to the broker
 func (c *client) Publish(topic string, qos byte, retained bool, payload interface{}) Token {
+	if c.options.PublishRetryPolicy != nil {
+		return c.publishWithRetry(c.options.PublishRetryPolicy, topic, qos, retained, payload)
+	}
+	return c.publishOnce(topic, qos, retained, payload)
+}
+
+// publishOnce makes a single attempt at a Publish
+func (c *client) publishOnce(topic string, qos byte, retained bool, payload interface{}) Token {
+	if c.options.PublishInterceptor != nil {
+		return c.interceptPublish(c.queueOrSendPublish, topic, qos, retained, payload)
+	}
+	return c.publish(c.queueOrSendPublish, topic, qos, retained, payload)
+}
+
+// publish performs the Publish (after any PublishInterceptor has been called), passing the packet
+// to send once it has been validated and allocated an id
+func (c *client) publish(send func(*packets.PublishPacket, *PublishToken), topic string, qos byte, retained bool, payload interface{}) Token {
 	token := newToken(packets.Publish).(*PublishToken)
 	DEBUG.Println(CLI, "enter Publish")
 	switch {
 	case !c.IsConnected():
 		token.setError(ErrNotConnected)
 		return token
+	case atomic.LoadUint32(&c.draining) == 1:
+		token.setError(ErrDraining)
+		return token
+	case !c.topicAllowed(topic, ACLPublish):
+		token.setError(ErrTopicForbidden)
+		return token
 	case c.connectionStatus() == reconnecting && qos == 0:
 		token.flowComplete()
 		return token
@@ -624,12 +1222,30 @@ func (c *client) Publish(topic string, qos byte, retained bool, payload interfac
 	if pub.Qos != 0 && pub.MessageID == 0 {
 		mID := c.getID(token)
 		if mID == 0 {
-			token.setError(fmt.Errorf("no message IDs available"))
+			token.setError(ErrNoMessageIDsAvailable)
 			return token
 		}
 		pub.MessageID = mID
 		token.messageID = mID
 	}
+	send(pub, token)
+	return token
+}
+
+// queueOrSendPublish adds the publish to its topic queue if PerTopicOrdering is set, otherwise
+// sends it immediately
+func (c *client) queueOrSendPublish(pub *packets.PublishPacket, token *PublishToken) {
+	if c.options.PerTopicOrdering {
+		c.queuePublish(pub, token)
+	} else {
+		c.sendPublish(pub, token)
+	}
+}
+
+// sendPublish persists the publish and passes it to the comms goroutines (or leaves it in the
+// store to be sent when the connection is up)
+func (c *client) sendPublish(pub *packets.PublishPacket, token *PublishToken) {
+	topic := pub.TopicName
 	persistOutbound(c.persist, pub)
 	switch c.connectionStatus() {
 	case connecting:
@@ -644,10 +1260,69 @@ func (c *client) Publish(topic string, qos byte, retained bool, payload interfac
 		}
 		select {
 		case c.obound <- &PacketAndToken{p: pub, t: token}:
+			c.checkPublishQueueAlarm()
 		case <-time.After(publishWaitTimeout):
 			token.setError(errors.New("publish was broken by timeout"))
 		}
 	}
+}
+
+// Fire publishes a non-retained QoS 0 message, returning once it has been queued for sending.
+// It is intended for telemetry where the QoS should never be anything other than 0.
+func (c *client) Fire(topic string, payload interface{}) error {
+	return c.Publish(topic, 0, false, payload).Error()
+}
+
+// FanoutPublish publishes the same message to each of topics, returning a token per topic (in the
+// same order). The payload is converted to a []byte once and shared by all of the publishes, which
+// proceed independently; a failure on one topic does not affect the others.
+func (c *client) FanoutPublish(topics []string, qos byte, retained bool, payload interface{}) []Token {
+	switch p := payload.(type) {
+	case string:
+		payload = []byte(p)
+	case bytes.Buffer:
+		payload = p.Bytes()
+	}
+	tokens := make([]Token, len(topics))
+	for i, topic := range topics {
+		tokens[i] = c.Publish(topic, qos, retained, payload)
+	}
+	return tokens
+}
+
+//ErrPublishDeadlineExceeded is the error set on the token by PublishWithDeadline when the
+//publish did not complete before the deadline
+var ErrPublishDeadlineExceeded = errors.New("publish not acknowledged before deadline")
+
+// PublishWithDeadline publishes as Publish but fails the token if the publish has not completed
+// (been acknowledged, or written for QoS 0) by deadline, or ctx is done before then. The client
+// remains connected and the message is removed from the store so it will not be resent; an
+// acknowledgement arriving later is discarded.
+func (c *client) PublishWithDeadline(ctx context.Context, deadline time.Time, topic string, qos byte, retained bool, payload interface{}) Token {
+	token := c.Publish(topic, qos, retained, payload)
+	pt, ok := token.(*PublishToken)
+	if !ok {
+		return token
+	}
+	go func() {
+		dctx, cancel := context.WithDeadline(ctx, deadline)
+		defer cancel()
+		if waitTokenContext(dctx, pt) {
+			return
+		}
+		err := ctx.Err()
+		if err == nil {
+			err = ErrPublishDeadlineExceeded
+		}
+		if id := pt.messageID; id != 0 {
+			if !c.messageIds.release(id, pt) {
+				return // already acknowledged
+			}
+			c.persist.Del(outboundKeyFromMID(id))
+		}
+		DEBUG.Println(CLI, "publish to", topic, "failed:", err)
+		pt.setError(err)
+	}()
 	return token
 }
 
@@ -677,9 +1352,19 @@ func (c *client) Subscribe(topic string, qos byte, callback MessageHandler) Toke
 		token.setError(err)
 		return token
 	}
+	if !c.topicAllowed(topic, ACLSubscribe) {
+		token.setError(ErrTopicForbidden)
+		return token
+	}
 	sub.Topics = append(sub.Topics, topic)
 	sub.Qoss = append(sub.Qoss, qos)
 
+	// The router matches $share/{group}/ routes against the underlying filter so the full string is
+	// used as the route key (allowing Unsubscribe to remove it); $queue/ must be removed
+	if callback != nil {
+		c.msgRouter.addRoute(routeKey(topic), callback)
+	}
+
 	if strings.HasPrefix(topic, "$share/") {
 		topic = strings.Join(strings.Split(topic, "/")[2:], "/")
 	}
@@ -688,16 +1373,13 @@ func (c *client) Subscribe(topic string, qos byte, callback MessageHandler) Toke
 		topic = strings.TrimPrefix(topic, "$queue/")
 	}
 
-	if callback != nil {
-		c.msgRouter.addRoute(topic, callback)
-	}
-
 	token.subs = append(token.subs, topic)
+	token.filters = sub.Topics
 
 	if sub.MessageID == 0 {
 		mID := c.getID(token)
 		if mID == 0 {
-			token.setError(fmt.Errorf("no message IDs available"))
+			token.setError(ErrNoMessageIDsAvailable)
 			return token
 		}
 		sub.MessageID = mID
@@ -719,6 +1401,9 @@ func (c *client) Subscribe(topic string, qos byte, callback MessageHandler) Toke
 		}
 		select {
 		case c.oboundP <- &PacketAndToken{p: sub, t: token}:
+			if c.options.SubscribeTimeout > 0 {
+				go c.awaitSuback(sub, token)
+			}
 		case <-time.After(subscribeWaitTimeout):
 			token.setError(errors.New("subscribe was broken by timeout"))
 		}
@@ -730,9 +1415,29 @@ func (c *client) Subscribe(topic string, qos byte, callback MessageHandler) Toke
 // SubscribeMultiple starts a new subscription for multiple topics. Provide a MessageHandler to
 // be executed when a message is published on one of the topics provided.
 func (c *client) SubscribeMultiple(filters map[string]byte, callback MessageHandler) Token {
+	DEBUG.Println(CLI, "enter SubscribeMultiple")
+	subs := make([]SubscriptionFilter, 0, len(filters))
+	for topic, qos := range filters {
+		subs = append(subs, SubscriptionFilter{Filter: topic, QoS: qos, Handler: callback})
+	}
+	t := c.subscribeFilters(subs)
+	DEBUG.Println(CLI, "exit SubscribeMultiple")
+	return t
+}
+
+// SubscribeFilters starts a new subscription for each of the filters provided (in a single
+// SUBSCRIBE packet). Each filter may have its own MessageHandler; nil means the default handler.
+func (c *client) SubscribeFilters(filters []SubscriptionFilter) Token {
+	DEBUG.Println(CLI, "enter SubscribeFilters")
+	t := c.subscribeFilters(filters)
+	DEBUG.Println(CLI, "exit SubscribeFilters")
+	return t
+}
+
+// subscribeFilters implements SubscribeMultiple and SubscribeFilters
+func (c *client) subscribeFilters(filters []SubscriptionFilter) Token {
 	var err error
 	token := newToken(packets.Subscribe).(*SubscribeToken)
-	DEBUG.Println(CLI, "enter SubscribeMultiple")
 	if !c.IsConnected() {
 		token.setError(ErrNotConnected)
 		return token
@@ -750,23 +1455,30 @@ func (c *client) SubscribeMultiple(filters map[string]byte, callback MessageHand
 		}
 	}
 	sub := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
-	if sub.Topics, sub.Qoss, err = validateSubscribeMap(filters); err != nil {
+	if sub.Topics, sub.Qoss, err = validateSubscriptionFilters(filters); err != nil {
 		token.setError(err)
 		return token
 	}
+	for _, topic := range sub.Topics {
+		if !c.topicAllowed(topic, ACLSubscribe) {
+			token.setError(ErrTopicForbidden)
+			return token
+		}
+	}
 
-	if callback != nil {
-		for topic := range filters {
-			c.msgRouter.addRoute(topic, callback)
+	for _, f := range filters {
+		if f.Handler != nil {
+			c.msgRouter.addRoute(routeKey(f.Filter), f.Handler)
 		}
 	}
 	token.subs = make([]string, len(sub.Topics))
 	copy(token.subs, sub.Topics)
+	token.filters = sub.Topics
 
 	if sub.MessageID == 0 {
 		mID := c.getID(token)
 		if mID == 0 {
-			token.setError(fmt.Errorf("no message IDs available"))
+			token.setError(ErrNoMessageIDsAvailable)
 			return token
 		}
 		sub.MessageID = mID
@@ -786,14 +1498,53 @@ func (c *client) SubscribeMultiple(filters map[string]byte, callback MessageHand
 		}
 		select {
 		case c.oboundP <- &PacketAndToken{p: sub, t: token}:
+			if c.options.SubscribeTimeout > 0 {
+				go c.awaitSuback(sub, token)
+			}
 		case <-time.After(subscribeWaitTimeout):
 			token.setError(errors.New("subscribe was broken by timeout"))
 		}
 	}
-	DEBUG.Println(CLI, "exit SubscribeMultiple")
 	return token
 }
 
+//ErrSubscribeTimeout is the error set on a subscribe token when no SUBACK was received
+//within SubscribeTimeout (after any retries)
+var ErrSubscribeTimeout = errors.New("suback not received")
+
+// awaitSuback waits for the SUBACK for sub, resending the packet (with the same message id, as
+// the broker may have received the original) up to SubscribeRetryCount times. The wait doubles
+// after each attempt. If no SUBACK arrives the token is completed with ErrSubscribeTimeout.
+func (c *client) awaitSuback(sub *packets.SubscribePacket, token *SubscribeToken) {
+	wait := c.options.SubscribeTimeout
+	for attempt := 0; ; attempt++ {
+		if token.WaitTimeout(wait) {
+			return
+		}
+		if attempt >= c.options.SubscribeRetryCount {
+			break
+		}
+		DEBUG.Println(CLI, "suback not received, resending subscribe, id:", sub.MessageID)
+		select {
+		case c.oboundP <- &PacketAndToken{p: sub, t: token}:
+		case <-token.complete:
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+
+	select {
+	case <-token.complete: // SUBACK may have arrived while we were deciding to give up
+		return
+	default:
+	}
+	WARN.Println(CLI, "suback not received, giving up on subscribe, id:", sub.MessageID)
+	c.persist.Del(outboundKeyFromMID(sub.MessageID))
+	c.freeID(sub.MessageID)
+	token.setError(ErrSubscribeTimeout)
+}
+
 // reserveStoredPublishIDs reserves the ids for publish packets in the persistent store to ensure these are not duplicated
 func (c *client) reserveStoredPublishIDs() {
 	// The resume function sets the stored id for publish packets only (some other packets
@@ -836,6 +1587,7 @@ func (c *client) resume(subscription bool, ibound chan packets.ControlPacket) {
 					token := newToken(packets.Subscribe).(*SubscribeToken)
 					token.messageID = details.MessageID
 					token.subs = append(token.subs, subPacket.Topics...)
+					token.filters = subPacket.Topics
 					c.claimID(token, details.MessageID)
 					c.oboundP <- &PacketAndToken{p: packet, t: token}
 				}
@@ -849,6 +1601,7 @@ func (c *client) resume(subscription bool, ibound chan packets.ControlPacket) {
 				DEBUG.Println(STR, fmt.Sprintf("loaded pending pubrel (%d)", details.MessageID))
 				c.oboundP <- &PacketAndToken{p: packet, t: nil}
 			case *packets.PublishPacket:
+				// DUP is set in the store once the publish has been sent (see startOutgoingComms)
 				token := newToken(packets.Publish).(*PublishToken)
 				token.messageID = details.MessageID
 				c.claimID(token, details.MessageID)
@@ -901,7 +1654,7 @@ func (c *client) Unsubscribe(topics ...string) Token {
 	if unsub.MessageID == 0 {
 		mID := c.getID(token)
 		if mID == 0 {
-			token.setError(fmt.Errorf("no message IDs available"))
+			token.setError(ErrNoMessageIDsAvailable)
 			return token
 		}
 		unsub.MessageID = mID
@@ -924,8 +1677,10 @@ func (c *client) Unsubscribe(topics ...string) Token {
 		select {
 		case c.oboundP <- &PacketAndToken{p: unsub, t: token}:
 			for _, topic := range topics {
-				c.msgRouter.deleteRoute(topic)
+				c.msgRouter.deleteRoute(routeKey(topic))
 			}
+			c.subs.remove(topics...)
+			c.saveSubscriptions()
 		case <-time.After(subscribeWaitTimeout):
 			token.setError(errors.New("unsubscribe was broken by timeout"))
 		}
@@ -935,6 +1690,14 @@ func (c *client) Unsubscribe(topics ...string) Token {
 	return token
 }
 
+// DuplicatesDropped returns the number of QoS 0 messages that have been discarded as duplicates
+func (c *client) DuplicatesDropped() uint64 {
+	if c.dedup == nil {
+		return 0
+	}
+	return c.dedup.duplicatesDropped()
+}
+
 // OptionsReader returns a ClientOptionsReader which is a copy of the clientoptions
 // in use by the client.
 func (c *client) OptionsReader() ClientOptionsReader {
@@ -968,11 +1731,54 @@ func (c *client) getWriteTimeOut() time.Duration {
 	return c.options.WriteTimeout
 }
 
+// notifyPacketSent will be called by the network routines whenever a packet is written to the network
+func (c *client) notifyPacketSent(cp packets.ControlPacket) {
+	c.events.publish(PacketSentEvent{Packet: cp})
+}
+
+// notifyPacketReceived will be called by the network routines whenever a packet is received off the network
+func (c *client) notifyPacketReceived(cp packets.ControlPacket) {
+	c.events.publish(PacketReceivedEvent{Packet: cp})
+}
+
+// callbackEvents calls the user supplied OnConnect and OnConnectionLost handlers in response to events
+// published on the event bus
+func (c *client) callbackEvents(e Event) {
+	switch ev := e.(type) {
+	case ConnectedEvent:
+		if c.options.OnConnect != nil {
+			go c.options.OnConnect(c)
+		}
+	case DisconnectedEvent:
+		if ev.Err != nil && c.options.OnConnectionLost != nil {
+			go c.options.OnConnectionLost(c, ev.Err)
+		}
+	}
+}
+
+// getReadStallTimeout returns the maximum time a partially received packet may stall for or 0 if none
+func (c *client) getReadStallTimeout() time.Duration {
+	return c.options.ReadStallTimeout
+}
+
+// getPacketReadTimeout returns the maximum time allowed to receive a packet once it has started or 0 if none
+func (c *client) getPacketReadTimeout() time.Duration {
+	return c.options.PacketReadTimeout
+}
+
 // persistOutbound adds the packet to the outbound store
 func (c *client) persistOutbound(m packets.ControlPacket) {
 	persistOutbound(c.persist, m)
 }
 
+// persistInFlight adds the packet to the outbound store if its message id is still held by t; the
+// id cannot be released while this is done
+func (c *client) persistInFlight(m packets.ControlPacket, t tokenCompletor) {
+	c.messageIds.ifHeld(m.Details().MessageID, t, func() {
+		persistOutbound(c.persist, m)
+	})
+}
+
 // persistInbound adds the packet to the inbound store
 func (c *client) persistInbound(m packets.ControlPacket) {
 	persistInbound(c.persist, m)
@@ -981,4 +1787,10 @@ func (c *client) persistInbound(m packets.ControlPacket) {
 // pingRespReceived will be called by the network routines when a ping response is received
 func (c *client) pingRespReceived() {
 	atomic.StoreInt32(&c.pingOutstanding, 0)
+	c.notifyPingWaiters()
+}
+
+// SubscriptionTree returns the subscriptions acknowledged by the broker rendered as an ASCII tree
+func (c *client) SubscriptionTree() string {
+	return c.subs.tree()
 }
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+/*
+mqttbench runs a number of publishers and subscribers against a broker for a fixed duration
+and reports throughput and end to end latency.
+
+Options:
+ [-brokers <uri,uri>]   Comma separated broker URIs
+ [-publishers <n>]      Number of publishing clients
+ [-subscribers <n>]     Number of subscribing clients (each subscribes to all topics)
+ [-qos 0|1|2]           Quality of Service used to publish and subscribe
+ [-payload-size <n>]    Payload size in bytes (minimum 8, the send timestamp is in the payload)
+ [-duration <d>]        How long to publish for
+ [-topic-count <n>]     Number of topics the publishers spread messages over
+
+Latency is measured from just before Publish is called to the message arriving at the subscriber's
+handler, so when the publishers and subscribers run on the same host no clock synchronisation is
+needed. Percentiles are calculated from every sample received.
+*/
+
+const timestampSize = 8
+
+func main() {
+	brokers := flag.String("brokers", "tcp://127.0.0.1:1883", "Comma separated list of broker URIs")
+	publishers := flag.Int("publishers", 1, "Number of publishing clients")
+	subscribers := flag.Int("subscribers", 1, "Number of subscribing clients")
+	qos := flag.Int("qos", 0, "The QoS to publish and subscribe at")
+	payloadSize := flag.Int("payload-size", 64, "Size of each message payload in bytes")
+	duration := flag.Duration("duration", 10*time.Second, "How long to publish for")
+	topicCount := flag.Int("topic-count", 1, "Number of topics to publish to")
+	flag.Parse()
+
+	if *qos < 0 || *qos > 2 {
+		fmt.Println("qos must be 0, 1 or 2")
+		os.Exit(1)
+	}
+	if *payloadSize < timestampSize {
+		*payloadSize = timestampSize
+	}
+	if *topicCount < 1 {
+		*topicCount = 1
+	}
+	servers := strings.Split(*brokers, ",")
+	runID := time.Now().UnixNano()
+	topics := make([]string, *topicCount)
+	for i := range topics {
+		topics[i] = fmt.Sprintf("mqttbench/%d/%d", runID, i)
+	}
+
+	r := &results{}
+	var subs []MQTT.Client
+	for i := 0; i < *subscribers; i++ {
+		c, err := connect(servers, fmt.Sprintf("mqttbench-sub-%d-%d", runID, i))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		filters := make(map[string]byte, len(topics))
+		for _, t := range topics {
+			filters[t] = byte(*qos)
+		}
+		if t := c.SubscribeMultiple(filters, r.received); t.Wait() && t.Error() != nil {
+			fmt.Println("subscribe failed:", t.Error())
+			os.Exit(1)
+		}
+		subs = append(subs, c)
+	}
+
+	var pubs []MQTT.Client
+	for i := 0; i < *publishers; i++ {
+		c, err := connect(servers, fmt.Sprintf("mqttbench-pub-%d-%d", runID, i))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		pubs = append(pubs, c)
+	}
+
+	fmt.Printf("Publishing with %d publishers and %d subscribers for %s\n", len(pubs), len(subs), *duration)
+	start := time.Now()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, c := range pubs {
+		wg.Add(1)
+		go func(i int, c MQTT.Client) {
+			defer wg.Done()
+			for n := i; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// Publish does not copy the payload so a new slice is needed for each message
+				payload := make([]byte, *payloadSize)
+				binary.BigEndian.PutUint64(payload, uint64(time.Now().UnixNano()))
+				t := c.Publish(topics[n%len(topics)], byte(*qos), false, payload)
+				if *qos > 0 {
+					t.Wait()
+				}
+				if t.Error() != nil {
+					atomic.AddUint64(&r.pubErrors, 1)
+					continue
+				}
+				atomic.AddUint64(&r.published, 1)
+			}
+		}(i, c)
+	}
+	time.Sleep(*duration)
+	close(stop)
+	wg.Wait()
+	pubElapsed := time.Since(start)
+
+	// Allow time for messages still in flight to arrive
+	expected := atomic.LoadUint64(&r.published) * uint64(len(subs))
+	for wait := time.Now(); time.Since(wait) < 5*time.Second && r.count() < expected; {
+		time.Sleep(100 * time.Millisecond)
+	}
+	elapsed := time.Since(start)
+
+	for _, c := range pubs {
+		c.Disconnect(250)
+	}
+	for _, c := range subs {
+		c.Disconnect(250)
+	}
+
+	r.report(pubElapsed, elapsed, *payloadSize, expected)
+}
+
+// connect returns a connected client
+func connect(servers []string, clientID string) (MQTT.Client, error) {
+	opts := MQTT.NewClientOptions().SetClientID(clientID).SetCleanSession(true).SetAutoReconnect(false)
+	for _, s := range servers {
+		opts.AddBroker(strings.TrimSpace(s))
+	}
+	c := MQTT.NewClient(opts)
+	if t := c.Connect(); t.Wait() && t.Error() != nil {
+		return nil, fmt.Errorf("%s failed to connect: %v", clientID, t.Error())
+	}
+	return c, nil
+}
+
+// results collects the statistics from all clients
+type results struct {
+	published uint64
+	pubErrors uint64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+}
+
+// received is the message handler for all subscribers
+func (r *results) received(c MQTT.Client, m MQTT.Message) {
+	p := m.Payload()
+	if len(p) < timestampSize {
+		return
+	}
+	latency := time.Duration(time.Now().UnixNano() - int64(binary.BigEndian.Uint64(p)))
+	r.mu.Lock()
+	r.latencies = append(r.latencies, latency)
+	r.mu.Unlock()
+}
+
+func (r *results) count() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return uint64(len(r.latencies))
+}
+
+func (r *results) report(pubElapsed, elapsed time.Duration, payloadSize int, expected uint64) {
+	r.mu.Lock()
+	lat := r.latencies
+	r.mu.Unlock()
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+
+	published := atomic.LoadUint64(&r.published)
+	received := uint64(len(lat))
+	fmt.Printf("Published:   %d messages (%d errors), %.0f msg/s, %.2f MB/s\n", published,
+		atomic.LoadUint64(&r.pubErrors), float64(published)/pubElapsed.Seconds(),
+		float64(published)*float64(payloadSize)/pubElapsed.Seconds()/1e6)
+	fmt.Printf("Received:    %d of %d expected messages, %.0f msg/s, %.2f MB/s\n", received, expected,
+		float64(received)/elapsed.Seconds(), float64(received)*float64(payloadSize)/elapsed.Seconds()/1e6)
+	if received == 0 {
+		return
+	}
+	fmt.Printf("Latency:     p50 %s, p95 %s, p99 %s, max %s\n", percentile(lat, 50), percentile(lat, 95),
+		percentile(lat, 99), lat[len(lat)-1])
+}
+
+// percentile returns the pth percentile of the sorted samples
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
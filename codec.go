@@ -0,0 +1,113 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// Codec converts between values and message payloads
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+//ErrUnknownCodec is the error returned when a codec name has not been registered with RegisterCodec
+var ErrUnknownCodec = errors.New("unknown codec")
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		"json": JSONCodec{},
+		"gob":  GobCodec{},
+	}
+)
+
+// RegisterCodec makes a codec available, under name, to PublishEncoded and SubscribeDecoded.
+// "json" and "gob" are registered by default; registering an existing name replaces the codec.
+func RegisterCodec(name string, c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[name] = c
+}
+
+// getCodec returns the codec registered under name
+func getCodec(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+	return c, nil
+}
+
+// JSONCodec encodes values using encoding/json
+type JSONCodec struct{}
+
+// Marshal implements Codec
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal implements Codec
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// GobCodec encodes values using encoding/gob (each payload is self contained so includes the
+// type information)
+type GobCodec struct{}
+
+// Marshal implements Codec
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Unmarshal implements Codec
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// PublishEncoded encodes v with the named codec and publishes the result. If encoding fails the
+// returned token holds the error.
+func PublishEncoded(c Client, topic string, qos byte, retained bool, v interface{}, codec string) Token {
+	cd, err := getCodec(codec)
+	if err == nil {
+		var payload []byte
+		if payload, err = cd.Marshal(v); err == nil {
+			return c.Publish(topic, qos, retained, payload)
+		}
+	}
+	t := newToken(packets.Publish).(*PublishToken)
+	t.setError(err)
+	return t
+}
+
+// DecodedMessageHandler is called by SubscribeDecoded with each message received and the value
+// decoded from its payload (or the error if decoding failed)
+type DecodedMessageHandler func(c Client, m Message, v interface{}, err error)
+
+// SubscribeDecoded subscribes to topic and decodes the payload of each message received with the
+// named codec. prototype determines the type decoded into; each message is decoded into a new
+// value of that type and handler receives a pointer to it (so for a prototype of Reading{} the
+// handler is passed a *Reading).
+func SubscribeDecoded(c Client, topic string, qos byte, codec string, prototype interface{}, handler DecodedMessageHandler) Token {
+	cd, err := getCodec(codec)
+	if err != nil {
+		t := newToken(packets.Subscribe).(*SubscribeToken)
+		t.setError(err)
+		return t
+	}
+	typ := reflect.TypeOf(prototype)
+	return c.Subscribe(topic, qos, func(c Client, m Message) {
+		v := reflect.New(typ).Interface()
+		err := cd.Unmarshal(m.Payload(), v)
+		handler(c, m, v, err)
+	})
+}
@@ -29,4 +29,5 @@ const (
 	STA component = "[state]   "
 	ERR component = "[error]   "
 	ROU component = "[router]  "
+	PKT component = "[packet]  "
 )
@@ -0,0 +1,188 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// ClientConfig holds client settings in a form that can be read from a JSON file (see
+// NewClientFromConfig). Fields that are omitted keep the defaults from NewClientOptions.
+type ClientConfig struct {
+	Brokers         []string `json:"brokers"`
+	ClientID        string   `json:"clientId"`
+	Username        string   `json:"username"`
+	Password        string   `json:"password"`
+	CleanSession    *bool    `json:"cleanSession"`
+	Order           *bool    `json:"order"`
+	ProtocolVersion uint     `json:"protocolVersion"`
+	ResumeSubs      bool     `json:"resumeSubs"`
+
+	KeepAlive            Duration `json:"keepAlive"`
+	PingTimeout          Duration `json:"pingTimeout"`
+	ConnectTimeout       Duration `json:"connectTimeout"`
+	WriteTimeout         Duration `json:"writeTimeout"`
+	AutoReconnect        *bool    `json:"autoReconnect"`
+	MaxReconnectInterval Duration `json:"maxReconnectInterval"`
+	ConnectRetry         bool     `json:"connectRetry"`
+	ConnectRetryInterval Duration `json:"connectRetryInterval"`
+
+	Will *WillConfig `json:"will"`
+	TLS  *TLSConfig  `json:"tls"`
+}
+
+// WillConfig holds the will message settings within a ClientConfig
+type WillConfig struct {
+	Topic    string `json:"topic"`
+	Payload  string `json:"payload"`
+	Qos      byte   `json:"qos"`
+	Retained bool   `json:"retained"`
+}
+
+// TLSConfig holds the TLS settings within a ClientConfig. Paths are to PEM encoded files.
+type TLSConfig struct {
+	CAFile             string `json:"caFile"`   // CA certificates used to verify the broker (system pool if empty)
+	CertFile           string `json:"certFile"` // client certificate (requires KeyFile)
+	KeyFile            string `json:"keyFile"`
+	ServerName         string `json:"serverName"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+}
+
+// Duration is a time.Duration that is represented in JSON as a string such as "30s" or "1m30s"
+// (a number is taken as a count of seconds)
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case float64:
+		*d = Duration(val * float64(time.Second))
+	case string:
+		pd, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		*d = Duration(pd)
+	default:
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadClientConfig reads a ClientConfig from a JSON file
+func LoadClientConfig(filename string) (*ClientConfig, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &ClientConfig{}
+	if err := json.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+// ClientOptions returns ClientOptions (based on NewClientOptions) with the settings from the config
+func (cfg *ClientConfig) ClientOptions() (*ClientOptions, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("no brokers configured")
+	}
+	o := NewClientOptions()
+	for _, b := range cfg.Brokers {
+		o.AddBroker(b)
+	}
+	o.SetClientID(cfg.ClientID)
+	o.SetUsername(cfg.Username)
+	o.SetPassword(cfg.Password)
+	if cfg.CleanSession != nil {
+		o.SetCleanSession(*cfg.CleanSession)
+	}
+	if cfg.Order != nil {
+		o.SetOrderMatters(*cfg.Order)
+	}
+	if cfg.ProtocolVersion != 0 {
+		o.SetProtocolVersion(cfg.ProtocolVersion)
+	}
+	o.SetResumeSubs(cfg.ResumeSubs)
+	if cfg.KeepAlive != 0 {
+		o.SetKeepAlive(time.Duration(cfg.KeepAlive))
+	}
+	if cfg.PingTimeout != 0 {
+		o.SetPingTimeout(time.Duration(cfg.PingTimeout))
+	}
+	if cfg.ConnectTimeout != 0 {
+		o.SetConnectTimeout(time.Duration(cfg.ConnectTimeout))
+	}
+	o.SetWriteTimeout(time.Duration(cfg.WriteTimeout))
+	if cfg.AutoReconnect != nil {
+		o.SetAutoReconnect(*cfg.AutoReconnect)
+	}
+	if cfg.MaxReconnectInterval != 0 {
+		o.SetMaxReconnectInterval(time.Duration(cfg.MaxReconnectInterval))
+	}
+	o.SetConnectRetry(cfg.ConnectRetry)
+	if cfg.ConnectRetryInterval != 0 {
+		o.SetConnectRetryInterval(time.Duration(cfg.ConnectRetryInterval))
+	}
+	if w := cfg.Will; w != nil {
+		o.SetWill(w.Topic, w.Payload, w.Qos, w.Retained)
+	}
+	if cfg.TLS != nil {
+		tc, err := cfg.TLS.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		o.SetTLSConfig(tc)
+	}
+	return o, nil
+}
+
+// tlsConfig loads the certificates and returns the tls.Config
+func (t *TLSConfig) tlsConfig() (*tls.Config, error) {
+	tc := &tls.Config{ServerName: t.ServerName, InsecureSkipVerify: t.InsecureSkipVerify}
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CAFile)
+		}
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// NewClientFromConfig reads a ClientConfig from the JSON file and returns a Client created with
+// the resulting options. Connect must be called before the client is used.
+func NewClientFromConfig(filename string) (Client, error) {
+	cfg, err := LoadClientConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+	o, err := cfg.ClientOptions()
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(o), nil
+}
@@ -0,0 +1,55 @@
+package mqtt
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// maxPacketSize is the largest packet MQTT can encode (a 4 byte remaining length holding at most
+// 268,435,455 following a 1 byte fixed header)
+const maxPacketSize = 1 + 4 + 268435455
+
+//ErrConnectPacketTooLarge is the error returned by Connect, without the broker being contacted,
+//when the CONNECT packet would exceed the MaxConnectPacketSize (or the protocol limit)
+var ErrConnectPacketTooLarge = errors.New("connect packet too large")
+
+// EstimateConnectPacketSize returns the size, in bytes, of the CONNECT packet the client would send
+// with opts when connecting to the first server (or when no server is set). The size includes the
+// client id, will, username and password. Where a CredentialsProvider is set it is called.
+func EstimateConnectPacketSize(opts *ClientOptions) int {
+	broker := &url.URL{}
+	if len(opts.Servers) > 0 {
+		broker = opts.Servers[0]
+	}
+	return connectPacketSize(newConnectMsgFromOptions(opts, broker), opts.ProtocolVersion)
+}
+
+// connectPacketSize returns the encoded size of cm when sent using protocolVersion (the protocol
+// name is not set until the packet is sent)
+func connectPacketSize(cm *packets.ConnectPacket, protocolVersion uint) int {
+	p := *cm
+	p.ProtocolName = "MQTT"
+	if protocolVersion == 3 || protocolVersion == 0x83 {
+		p.ProtocolName = "MQIsdp"
+	}
+	var b bytes.Buffer
+	if err := p.Write(&b); err != nil {
+		return maxPacketSize + 1 // Cannot be encoded
+	}
+	return b.Len()
+}
+
+// checkConnectPacketSize returns ErrConnectPacketTooLarge if cm exceeds limit (the protocol limit
+// is used if limit <= 0)
+func checkConnectPacketSize(cm *packets.ConnectPacket, protocolVersion uint, limit int) error {
+	if limit <= 0 || limit > maxPacketSize {
+		limit = maxPacketSize
+	}
+	if connectPacketSize(cm, protocolVersion) > limit {
+		return ErrConnectPacketTooLarge
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package mqtt
+
+import (
+	"bytes"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// DeadbandPublisher wraps a Client, suppressing publishes of numeric readings that differ from the
+// last value published to the same topic by less than a threshold. This reduces the load caused by
+// noisy sensors. Other Client methods are passed straight through.
+//
+// Each payload is converted to a number using the parse function provided; publishes whose
+// payload cannot be parsed (or is of an unsupported type) are always passed on. A suppressed
+// publish returns a token that has already completed without error.
+type DeadbandPublisher struct {
+	Client
+	threshold  float64
+	parse      func([]byte) (float64, error)
+	suppressed uint64 // accessed atomically
+
+	mu   sync.Mutex
+	last map[string]float64 // topic -> last value published
+}
+
+// NewDeadbandPublisher returns a DeadbandPublisher wrapping client. A publish is only passed on if
+// the absolute difference between its value and the last value published to the topic is at least
+// threshold.
+func NewDeadbandPublisher(client Client, threshold float64, parse func([]byte) (float64, error)) *DeadbandPublisher {
+	return &DeadbandPublisher{
+		Client:    client,
+		threshold: threshold,
+		parse:     parse,
+		last:      make(map[string]float64),
+	}
+}
+
+// Publish passes the publish to the client unless it is within the dead band
+func (d *DeadbandPublisher) Publish(topic string, qos byte, retained bool, payload interface{}) Token {
+	var b []byte
+	switch p := payload.(type) {
+	case string:
+		b = []byte(p)
+	case []byte:
+		b = p
+	case bytes.Buffer:
+		b = p.Bytes()
+	default:
+		return d.Client.Publish(topic, qos, retained, payload)
+	}
+	v, err := d.parse(b)
+	if err != nil {
+		return d.Client.Publish(topic, qos, retained, payload)
+	}
+
+	d.mu.Lock()
+	last, ok := d.last[topic]
+	if ok && math.Abs(v-last) < d.threshold {
+		d.mu.Unlock()
+		atomic.AddUint64(&d.suppressed, 1)
+		t := newToken(packets.Publish).(*PublishToken)
+		t.flowComplete()
+		return t
+	}
+	d.last[topic] = v
+	d.mu.Unlock()
+	return d.Client.Publish(topic, qos, retained, payload)
+}
+
+// SuppressedCount returns the number of publishes that were not passed on
+func (d *DeadbandPublisher) SuppressedCount() uint64 {
+	return atomic.LoadUint64(&d.suppressed)
+}
@@ -0,0 +1,73 @@
+package mqtt
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// dedupWindow remembers the hashes of recently received QoS 0 messages so that duplicates
+// (e.g. caused by proxies or application level retransmission) can be dropped. It holds at
+// most size entries, evicting the least recently seen, and a message is only treated as a
+// duplicate if the original was received less than ttl ago.
+type dedupWindow struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[[sha256.Size]byte]*list.Element
+	order   *list.List // most recently seen at the front
+	dropped uint64     // number of duplicates dropped (accessed atomically)
+}
+
+type dedupEntry struct {
+	hash [sha256.Size]byte
+	seen time.Time
+}
+
+func newDedupWindow(size int, ttl time.Duration) *dedupWindow {
+	return &dedupWindow{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[[sha256.Size]byte]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// isDuplicate records the message and returns true if an identical message (same topic
+// and payload) was recorded within the window
+func (d *dedupWindow) isDuplicate(topic string, payload []byte) bool {
+	h := sha256.New()
+	h.Write([]byte(topic))
+	h.Write([]byte{0}) // topics cannot contain a null character so this separates topic and payload
+	h.Write(payload)
+	var hash [sha256.Size]byte
+	copy(hash[:], h.Sum(nil))
+
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.entries[hash]; ok {
+		entry := e.Value.(*dedupEntry)
+		d.order.MoveToFront(e)
+		if now.Sub(entry.seen) < d.ttl {
+			atomic.AddUint64(&d.dropped, 1)
+			return true
+		}
+		entry.seen = now
+		return false
+	}
+	d.entries[hash] = d.order.PushFront(&dedupEntry{hash: hash, seen: now})
+	if d.order.Len() > d.size {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.entries, oldest.Value.(*dedupEntry).hash)
+	}
+	return false
+}
+
+// duplicatesDropped returns the number of messages identified as duplicates
+func (d *dedupWindow) duplicatesDropped() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
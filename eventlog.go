@@ -0,0 +1,92 @@
+package mqtt
+
+import (
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// ConnectionEvent is an entry in the connection event log (see SetConnectionEventLog)
+type ConnectionEvent struct {
+	Timestamp time.Time
+	EventType string // one of the EventType constants
+	Detail    string // e.g. the packet type and id
+}
+
+// Types of ConnectionEvent
+const (
+	EventTypeConnected      = "connected"
+	EventTypeDisconnected   = "disconnected"
+	EventTypePacketSent     = "packet sent"
+	EventTypePacketReceived = "packet received"
+	EventTypeKeepAlive      = "keepalive" // a PINGREQ was sent
+)
+
+// connectionEventLog is a circular buffer holding the most recent connection events
+type connectionEventLog struct {
+	mu     sync.Mutex
+	events []ConnectionEvent
+	next   int
+	full   bool
+}
+
+func newConnectionEventLog(capacity int) *connectionEventLog {
+	return &connectionEventLog{events: make([]ConnectionEvent, capacity)}
+}
+
+func (l *connectionEventLog) add(eventType, detail string) {
+	l.mu.Lock()
+	l.events[l.next] = ConnectionEvent{Timestamp: time.Now(), EventType: eventType, Detail: detail}
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+	l.mu.Unlock()
+}
+
+// all returns the events, oldest first
+func (l *connectionEventLog) all() []ConnectionEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full {
+		return append([]ConnectionEvent(nil), l.events[:l.next]...)
+	}
+	return append(append([]ConnectionEvent(nil), l.events[l.next:]...), l.events[:l.next]...)
+}
+
+// record is subscribed to the event bus when the connection event log is enabled
+func (l *connectionEventLog) record(e Event) {
+	switch ev := e.(type) {
+	case ConnectedEvent:
+		if ev.SessionPresent {
+			l.add(EventTypeConnected, "session present")
+		} else {
+			l.add(EventTypeConnected, "")
+		}
+	case DisconnectedEvent:
+		if ev.Err != nil {
+			l.add(EventTypeDisconnected, ev.Err.Error())
+		} else {
+			l.add(EventTypeDisconnected, "requested")
+		}
+	case PacketSentEvent:
+		if _, ok := ev.Packet.(*packets.PingreqPacket); ok {
+			l.add(EventTypeKeepAlive, describePacket(ev.Packet))
+		} else {
+			l.add(EventTypePacketSent, describePacket(ev.Packet))
+		}
+	case PacketReceivedEvent:
+		l.add(EventTypePacketReceived, describePacket(ev.Packet))
+	}
+}
+
+// RecentConnectionEvents returns the entries in the connection event log, oldest first; nil if
+// the log is not enabled (see SetConnectionEventLog). The log is retained across disconnections
+// so it may be examined once the connection has been lost.
+func (c *client) RecentConnectionEvents() []ConnectionEvent {
+	if c.eventLog == nil {
+		return nil
+	}
+	return c.eventLog.all()
+}
@@ -0,0 +1,150 @@
+package mqtt
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// Event is implemented by all of the events published on the client's event bus
+// (see ClientOptions.SetEventHandler). Use a type switch to determine the event type.
+type Event interface {
+	isEvent()
+}
+
+// ConnectedEvent is published when the client has connected (or reconnected) to a broker
+type ConnectedEvent struct {
+	SessionPresent bool
+}
+
+// DisconnectedEvent is published when the connection to the broker is closed. Err is nil
+// if the disconnection was requested (by calling Disconnect), otherwise it holds the reason
+// the connection was lost.
+type DisconnectedEvent struct {
+	Err error
+}
+
+// PacketReceivedEvent is published whenever a packet is received from the broker
+type PacketReceivedEvent struct {
+	Packet packets.ControlPacket
+}
+
+// PacketSentEvent is published whenever a packet has been written to the network
+type PacketSentEvent struct {
+	Packet packets.ControlPacket
+}
+
+// InflightChangedEvent is published when the number of messages awaiting acknowledgement
+// (i.e. the number of message ids in use) changes
+type InflightChangedEvent struct {
+	Inflight int
+}
+
+func (ConnectedEvent) isEvent()       {}
+func (DisconnectedEvent) isEvent()    {}
+func (PacketReceivedEvent) isEvent()  {}
+func (PacketSentEvent) isEvent()      {}
+func (InflightChangedEvent) isEvent() {}
+
+// EventHandler is a callback type which can be set to be executed for every event
+// published on the client's event bus. Events are passed to it, in order, from a goroutine
+// of its own so a slow handler does not hold up the network; if it falls too far behind
+// events are dropped (see SetEventHandler). The packets in PacketSentEvent and
+// PacketReceivedEvent must not be modified.
+type EventHandler func(Event)
+
+// eventQueueSize is the number of events held for an EventHandler that is not keeping up
+const eventQueueSize = 1024
+
+// eventBus distributes events to any subscribed handlers. Handlers are called in the order
+// they subscribed, on the publishing goroutine.
+type eventBus struct {
+	sync.RWMutex
+	handlers []*eventSubscription
+}
+
+type eventSubscription struct {
+	handler EventHandler
+}
+
+// subscribe adds a handler that will be called for all events published; the returned function
+// removes it
+func (b *eventBus) subscribe(h EventHandler) func() {
+	sub := &eventSubscription{handler: h}
+	b.Lock()
+	defer b.Unlock()
+	b.handlers = append(b.handlers, sub)
+	return func() {
+		b.Lock()
+		defer b.Unlock()
+		// handlers is copied rather than modified in place as publish may be iterating over it
+		handlers := make([]*eventSubscription, 0, len(b.handlers))
+		for _, s := range b.handlers {
+			if s != sub {
+				handlers = append(handlers, s)
+			}
+		}
+		b.handlers = handlers
+	}
+}
+
+// publish passes the event to each subscribed handler
+func (b *eventBus) publish(e Event) {
+	b.RLock()
+	handlers := b.handlers
+	b.RUnlock()
+	for _, s := range handlers {
+		s.handler(e)
+	}
+}
+
+// asyncEventHandler passes events to an external handler (i.e. one provided by the user) from
+// its own goroutine. Events are published from the comms routines so calling the handler
+// directly would allow it to stall the network (and keepalive). Events that arrive when the
+// queue is full are dropped. The goroutine only runs while there are events to deliver.
+type asyncEventHandler struct {
+	handler EventHandler
+	queue   chan Event
+	running int32  // 1 while deliver is running
+	dropped uint64 // events dropped since the last was queued
+}
+
+func newAsyncEventHandler(h EventHandler, size int) *asyncEventHandler {
+	return &asyncEventHandler{handler: h, queue: make(chan Event, size)}
+}
+
+// handle queues e for delivery; it is subscribed to the event bus
+func (a *asyncEventHandler) handle(e Event) {
+	select {
+	case a.queue <- e:
+		if n := atomic.SwapUint64(&a.dropped, 0); n > 0 {
+			WARN.Println(CLI, "event handler queue was full,", n, "events dropped")
+		}
+	default:
+		if atomic.AddUint64(&a.dropped, 1) == 1 {
+			WARN.Println(CLI, "event handler queue full, dropping events")
+		}
+		return
+	}
+	if atomic.CompareAndSwapInt32(&a.running, 0, 1) {
+		go a.deliver()
+	}
+}
+
+// deliver calls the handler for each queued event, exiting when the queue is empty
+func (a *asyncEventHandler) deliver() {
+	for {
+		select {
+		case e := <-a.queue:
+			a.handler(e)
+		default:
+			atomic.StoreInt32(&a.running, 0)
+			// An event queued after the queue was found to be empty, but before running was
+			// cleared, would not start another goroutine so must be picked up here
+			if len(a.queue) == 0 || !atomic.CompareAndSwapInt32(&a.running, 0, 1) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,28 @@
+#!/bin/sh
+# Shell script to run golang Paho tests with docker mosquitto instance
+# Any arguments are passed through to "go test" (e.g. -race or -run Test_Start)
+cd "$(dirname "$0")" || exit 1
+
+if ! docker info >/dev/null 2>&1; then
+    echo "docker is not available; skipping FVT tests"
+    exit 0
+fi
+
+docker-compose up -d || exit 1
+
+# Wait for the broker to start accepting connections before running the tests
+tries=0
+until docker exec mosquitto-test mosquitto_sub -t '$SYS/broker/version' -C 1 -W 1 >/dev/null 2>&1; do
+    tries=$((tries + 1))
+    if [ "$tries" -ge 30 ]; then
+        echo "mosquitto did not become ready"
+        docker-compose down
+        exit 1
+    fi
+    sleep 1
+done
+
+go test -v "$@" ../../
+rc=$?
+docker-compose down
+exit $rc
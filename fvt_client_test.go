@@ -193,6 +193,110 @@ func Test_Subscribe(t *testing.T) {
 	s.Disconnect(250)
 }
 
+func Test_SubscribeWildcard(t *testing.T) {
+	received := make(chan string, 3)
+
+	pops := NewClientOptions()
+	pops.AddBroker(FVTTCP)
+	pops.SetClientID("SubscribeWildcard_tx")
+	p := NewClient(pops)
+
+	sops := NewClientOptions()
+	sops.AddBroker(FVTTCP)
+	sops.SetClientID("SubscribeWildcard_rx")
+	s := NewClient(sops)
+
+	if token := s.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Error on Client.Connect(): %v", token.Error())
+	}
+
+	var f MessageHandler = func(client Client, msg Message) {
+		received <- msg.Topic()
+	}
+	if token := s.Subscribe("/test/wildcard/+/temp", 1, f); token.Wait() && token.Error() != nil {
+		t.Fatalf("Error on Client.Subscribe(): %v", token.Error())
+	}
+
+	if token := p.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Error on Client.Connect(): %v", token.Error())
+	}
+
+	p.Publish("/test/wildcard/room1/temp", 1, false, "21").Wait()
+	p.Publish("/test/wildcard/room1/humidity", 1, false, "40").Wait()
+	p.Publish("/test/wildcard/room2/temp", 1, false, "19").Wait()
+
+	for _, exp := range []string{"/test/wildcard/room1/temp", "/test/wildcard/room2/temp"} {
+		select {
+		case topic := <-received:
+			if topic != exp {
+				t.Fatalf("expected message on %s, got %s", exp, topic)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for message on %s", exp)
+		}
+	}
+
+	select {
+	case topic := <-received:
+		t.Fatalf("received unexpected message on %s", topic)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	p.Disconnect(250)
+	s.Disconnect(250)
+}
+
+func Test_Retained(t *testing.T) {
+	topic := "/test/retained"
+	received := make(chan Message, 1)
+
+	pops := NewClientOptions()
+	pops.AddBroker(FVTTCP)
+	pops.SetClientID("Retained_tx")
+	p := NewClient(pops)
+
+	if token := p.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Error on Client.Connect(): %v", token.Error())
+	}
+	if token := p.Publish(topic, 1, true, "retained payload"); token.Wait() && token.Error() != nil {
+		t.Fatalf("Error on Client.Publish(): %v", token.Error())
+	}
+
+	sops := NewClientOptions()
+	sops.AddBroker(FVTTCP)
+	sops.SetClientID("Retained_rx")
+	s := NewClient(sops)
+
+	if token := s.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatalf("Error on Client.Connect(): %v", token.Error())
+	}
+
+	// The message was published before the subscription so will only be received if the broker retained it
+	if token := s.Subscribe(topic, 1, func(client Client, msg Message) {
+		received <- msg
+	}); token.Wait() && token.Error() != nil {
+		t.Fatalf("Error on Client.Subscribe(): %v", token.Error())
+	}
+
+	select {
+	case msg := <-received:
+		if !msg.Retained() {
+			t.Fatalf("message was not flagged as retained")
+		}
+		if string(msg.Payload()) != "retained payload" {
+			t.Fatalf("unexpected retained payload %q", msg.Payload())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for retained message")
+	}
+
+	// Clear the retained message so it does not impact later runs
+	p.Publish(topic, 1, true, []byte{}).Wait()
+
+	p.Disconnect(250)
+	s.Disconnect(250)
+}
+
 func Test_Will(t *testing.T) {
 	willmsgc := make(chan string, 1)
 
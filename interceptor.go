@@ -0,0 +1,88 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+//ErrPublishIntercepted is the error set on the Publish token when a PublishInterceptor returns
+//without passing the publish on (and without returning an error of its own)
+var ErrPublishIntercepted = errors.New("publish stopped by interceptor")
+
+// PublishRequest holds the arguments passed to Publish; interceptors may modify them
+type PublishRequest struct {
+	Topic    string
+	Qos      byte
+	Retained bool
+	Payload  interface{}
+}
+
+// PublishHandler passes a PublishRequest on to the next interceptor (or, at the end of the
+// chain, to the client)
+type PublishHandler func(ctx context.Context, req *PublishRequest) error
+
+// PublishInterceptor is called for each Publish. It should call next (possibly with a modified
+// context or request) unless the publish is to be stopped, in which case it returns an error.
+type PublishInterceptor func(ctx context.Context, req *PublishRequest, next PublishHandler) error
+
+// ReceiveHandler passes a received Message on to the next interceptor (or, at the end of the
+// chain, to the message handlers which are passed ctx)
+type ReceiveHandler func(ctx context.Context, msg Message) error
+
+// ReceiveInterceptor is called for each received message before it is routed to the message
+// handlers. It should call next (possibly with a modified context or message) unless the message
+// is to be discarded. Discarded messages are acknowledged.
+type ReceiveInterceptor func(ctx context.Context, msg Message, next ReceiveHandler) error
+
+// ChainPublishInterceptors combines interceptors into one; they are called in the order given
+func ChainPublishInterceptors(interceptors ...PublishInterceptor) PublishInterceptor {
+	return func(ctx context.Context, req *PublishRequest, next PublishHandler) error {
+		h := next
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], h
+			h = func(ctx context.Context, req *PublishRequest) error {
+				return interceptor(ctx, req, inner)
+			}
+		}
+		return h(ctx, req)
+	}
+}
+
+// ChainReceiveInterceptors combines interceptors into one; they are called in the order given
+func ChainReceiveInterceptors(interceptors ...ReceiveInterceptor) ReceiveInterceptor {
+	return func(ctx context.Context, msg Message, next ReceiveHandler) error {
+		h := next
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], h
+			h = func(ctx context.Context, msg Message) error {
+				return interceptor(ctx, msg, inner)
+			}
+		}
+		return h(ctx, msg)
+	}
+}
+
+// interceptPublish runs the PublishInterceptor, publishing the (possibly modified) request if
+// the interceptors pass it on
+func (c *client) interceptPublish(send func(*packets.PublishPacket, *PublishToken), topic string, qos byte, retained bool, payload interface{}) Token {
+	var token Token
+	req := &PublishRequest{Topic: topic, Qos: qos, Retained: retained, Payload: payload}
+	err := c.options.PublishInterceptor(context.Background(), req, func(ctx context.Context, r *PublishRequest) error {
+		token = c.publish(send, r.Topic, r.Qos, r.Retained, r.Payload)
+		return token.Error()
+	})
+	if token == nil {
+		if err == nil {
+			err = ErrPublishIntercepted
+		}
+		t := newToken(packets.Publish).(*PublishToken)
+		t.setError(err)
+		return t
+	}
+	if err != nil && err != token.Error() {
+		WARN.Println(CLI, "publish interceptor returned error after publishing:", err)
+	}
+	return token
+}
@@ -0,0 +1,55 @@
+package mqtt
+
+import (
+	"sync"
+)
+
+// LVC (last value cache) subscribes to topic filters and keeps the most recent message received on
+// each matching topic so that the current value can be read at any time. It acts as a client side
+// cache of retained messages; a retained message with an empty payload (which clears the retained
+// message on the broker) removes the topic from the cache.
+type LVC struct {
+	client Client
+
+	mu     sync.RWMutex
+	values map[string]Message // topic -> most recent message
+}
+
+// NewLVC returns an LVC that subscribes using client
+func NewLVC(client Client) *LVC {
+	return &LVC{client: client, values: make(map[string]Message)}
+}
+
+// Watch subscribes to filter, caching the messages received. The returned token completes when
+// the broker acknowledges the subscription.
+func (l *LVC) Watch(filter string, qos byte) Token {
+	return l.client.Subscribe(filter, qos, l.store)
+}
+
+// WatchMultiple subscribes (at QoS 1) to each of filters, caching the messages received
+func (l *LVC) WatchMultiple(filters []string) Token {
+	subs := make(map[string]byte, len(filters))
+	for _, f := range filters {
+		subs[f] = 1
+	}
+	return l.client.SubscribeMultiple(subs, l.store)
+}
+
+// Get returns the most recent message received on topic
+func (l *LVC) Get(topic string) (Message, bool) {
+	l.mu.RLock()
+	m, ok := l.values[topic]
+	l.mu.RUnlock()
+	return m, ok
+}
+
+// store is the MessageHandler for all watched filters
+func (l *LVC) store(_ Client, msg Message) {
+	l.mu.Lock()
+	if msg.Retained() && len(msg.Payload()) == 0 {
+		delete(l.values, msg.Topic())
+	} else {
+		l.values[msg.Topic()] = msg
+	}
+	l.mu.Unlock()
+}
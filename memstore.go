@@ -16,17 +16,27 @@ package mqtt
 
 import (
 	"sync"
+	"time"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
 )
 
+// MessageExpiredHandler is called by the MemoryStore expiry sweep for each message that has
+// been held for longer than the expiry interval. Returning true removes the message from the
+// store, returning false keeps it (the handler will be called again on the next sweep).
+type MessageExpiredHandler func(key string, message packets.ControlPacket) bool
+
 // MemoryStore implements the store interface to provide a "persistence"
 // mechanism wholly stored in memory. This is only useful for
 // as long as the client instance exists.
 type MemoryStore struct {
 	sync.RWMutex
 	messages map[string]packets.ControlPacket
+	stored   map[string]time.Time // when each message was Put
 	opened   bool
+
+	onExpired MessageExpiredHandler
+	stopSweep chan struct{} // closed to stop the expiry sweep; nil if it is not running
 }
 
 // NewMemoryStore returns a pointer to a new instance of
@@ -35,6 +45,7 @@ type MemoryStore struct {
 func NewMemoryStore() *MemoryStore {
 	store := &MemoryStore{
 		messages: make(map[string]packets.ControlPacket),
+		stored:   make(map[string]time.Time),
 		opened:   false,
 	}
 	return store
@@ -58,6 +69,7 @@ func (store *MemoryStore) Put(key string, message packets.ControlPacket) {
 		return
 	}
 	store.messages[key] = message
+	store.stored[key] = time.Now()
 }
 
 // Get takes a key and looks in the store for a matching Message
@@ -110,6 +122,7 @@ func (store *MemoryStore) Del(key string) {
 		WARN.Println(STR, "memorystore del: message", mid, "not found")
 	} else {
 		delete(store.messages, key)
+		delete(store.stored, key)
 		DEBUG.Println(STR, "memorystore del: message", mid, "was deleted")
 	}
 }
@@ -123,6 +136,10 @@ func (store *MemoryStore) Close() {
 		return
 	}
 	store.opened = false
+	if store.stopSweep != nil {
+		close(store.stopSweep)
+		store.stopSweep = nil
+	}
 	DEBUG.Println(STR, "memorystore closed")
 }
 
@@ -134,5 +151,80 @@ func (store *MemoryStore) Reset() {
 		ERROR.Println(STR, "Trying to reset memory store, but not open")
 	}
 	store.messages = make(map[string]packets.ControlPacket)
+	store.stored = make(map[string]time.Time)
 	WARN.Println(STR, "memorystore wiped")
 }
+
+// SetOnMessageExpired sets the handler called by the expiry sweep (see SetMessageExpiryInterval).
+// If no handler is set expired messages are removed.
+func (store *MemoryStore) SetOnMessageExpired(f MessageExpiredHandler) {
+	store.Lock()
+	store.onExpired = f
+	store.Unlock()
+}
+
+// SetMessageExpiryInterval starts a goroutine that checks the store every d and removes (subject
+// to the OnMessageExpired handler) messages that were stored more than d ago. This limits the
+// memory used by a long running client that is unable to deliver its messages. A d of 0 stops
+// the sweep, as does closing the store.
+//
+// Note that removing a message from the store does not complete the token associated with it.
+func (store *MemoryStore) SetMessageExpiryInterval(d time.Duration) {
+	store.Lock()
+	defer store.Unlock()
+	if store.stopSweep != nil {
+		close(store.stopSweep)
+		store.stopSweep = nil
+	}
+	if d <= 0 {
+		return
+	}
+	store.stopSweep = make(chan struct{})
+	go store.sweep(d, store.stopSweep)
+}
+
+// sweep calls expire every d until stop is closed
+func (store *MemoryStore) sweep(d time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			store.expire(now.Add(-d))
+		}
+	}
+}
+
+// expire offers messages stored before cutoff to the OnMessageExpired handler, removing those
+// it accepts. The handler is called without the lock held so it may use the store.
+func (store *MemoryStore) expire(cutoff time.Time) {
+	store.RLock()
+	if !store.opened {
+		store.RUnlock()
+		return
+	}
+	expired := make(map[string]packets.ControlPacket)
+	for k, t := range store.stored {
+		if t.Before(cutoff) {
+			expired[k] = store.messages[k]
+		}
+	}
+	onExpired := store.onExpired
+	store.RUnlock()
+
+	for k, m := range expired {
+		if onExpired != nil && !onExpired(k, m) {
+			continue
+		}
+		store.Lock()
+		// The message may have been replaced (or removed) while the handler was running
+		if t, ok := store.stored[k]; ok && t.Before(cutoff) {
+			delete(store.messages, k)
+			delete(store.stored, k)
+			WARN.Println(STR, "memorystore expired message", mIDFromKey(k))
+		}
+		store.Unlock()
+	}
+}
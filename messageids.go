@@ -15,6 +15,7 @@
 package mqtt
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -28,8 +29,14 @@ type MId uint16
 type messageIds struct {
 	sync.RWMutex
 	index map[uint16]tokenCompletor
+
+	inflightChanged func(inflight int) // if set, called (without the lock held) when the number of ids in use changes
 }
 
+//ErrNoMessageIDsAvailable is the error set on a token when the operation needs a message id but
+//all of them are in use (i.e. 65535 operations are awaiting acknowledgement)
+var ErrNoMessageIDsAvailable = errors.New("no message IDs available")
+
 const (
 	midMin uint16 = 1
 	midMax uint16 = 65535
@@ -52,39 +59,86 @@ func (mids *messageIds) cleanUp() {
 	}
 	mids.index = make(map[uint16]tokenCompletor)
 	mids.Unlock()
+	mids.notifyInflight(0)
 	DEBUG.Println(MID, "cleaned up")
 }
 
 func (mids *messageIds) freeID(id uint16) {
 	mids.Lock()
+	_, ok := mids.index[id]
 	delete(mids.index, id)
+	inflight := len(mids.index)
 	mids.Unlock()
+	if ok {
+		mids.notifyInflight(inflight)
+	}
+}
+
+// release replaces t, if it still holds id, with a PlaceHolderToken so that the id remains
+// reserved (until the broker acknowledges it) but t is no longer completed by the acknowledgement.
+// Returns false if id is no longer held by t.
+func (mids *messageIds) release(id uint16, t tokenCompletor) bool {
+	mids.Lock()
+	if mids.index[id] != t {
+		mids.Unlock()
+		return false
+	}
+	mids.index[id] = &PlaceHolderToken{id: id}
+	inflight := len(mids.index)
+	mids.Unlock()
+	// The count is unchanged but the message is no longer awaiting acknowledgement
+	mids.notifyInflight(inflight)
+	return true
+}
+
+// ifHeld calls fn, with the lock held, if id is held by t. This ensures that release cannot happen
+// part way through fn. Returns true if fn was called.
+func (mids *messageIds) ifHeld(id uint16, t tokenCompletor, fn func()) bool {
+	mids.RLock()
+	defer mids.RUnlock()
+	if mids.index[id] != t {
+		return false
+	}
+	fn()
+	return true
 }
 
 func (mids *messageIds) claimID(token tokenCompletor, id uint16) {
 	mids.Lock()
-	defer mids.Unlock()
-	if _, ok := mids.index[id]; !ok {
-		mids.index[id] = token
-	} else {
-		old := mids.index[id]
+	old, ok := mids.index[id]
+	if ok {
 		old.flowComplete()
-		mids.index[id] = token
+	}
+	mids.index[id] = token
+	inflight := len(mids.index)
+	mids.Unlock()
+	if !ok {
+		mids.notifyInflight(inflight)
 	}
 }
 
 func (mids *messageIds) getID(t tokenCompletor) uint16 {
 	mids.Lock()
-	defer mids.Unlock()
 	for i := midMin; i <= midMax; i++ {
 		if _, ok := mids.index[i]; !ok {
 			mids.index[i] = t
+			inflight := len(mids.index)
+			mids.Unlock()
+			mids.notifyInflight(inflight)
 			return i
 		}
 	}
+	mids.Unlock()
 	return 0
 }
 
+// notifyInflight calls inflightChanged (if set); must be called without the lock held
+func (mids *messageIds) notifyInflight(inflight int) {
+	if mids.inflightChanged != nil {
+		mids.inflightChanged(inflight)
+	}
+}
+
 func (mids *messageIds) getToken(id uint16) tokenCompletor {
 	mids.RLock()
 	defer mids.RUnlock()
@@ -0,0 +1,86 @@
+package mqtt
+
+import (
+	"sync/atomic"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// PacketTypeMetric holds the number of packets of one type sent and received
+type PacketTypeMetric struct {
+	Sent     int64
+	Received int64
+}
+
+// packetMetrics counts packets by type (indexed by the MQTT packet type); the counters are
+// accessed atomically
+type packetMetrics struct {
+	sent     [packets.Disconnect + 1]int64
+	received [packets.Disconnect + 1]int64
+}
+
+// record is subscribed to the event bus
+func (m *packetMetrics) record(e Event) {
+	switch ev := e.(type) {
+	case PacketSentEvent:
+		atomic.AddInt64(&m.sent[packetTypeOf(ev.Packet)], 1)
+	case PacketReceivedEvent:
+		atomic.AddInt64(&m.received[packetTypeOf(ev.Packet)], 1)
+	}
+}
+
+// packetTypeOf returns the MQTT packet type of cp (0 if unknown)
+func packetTypeOf(cp packets.ControlPacket) byte {
+	switch cp.(type) {
+	case *packets.ConnectPacket:
+		return packets.Connect
+	case *packets.ConnackPacket:
+		return packets.Connack
+	case *packets.PublishPacket:
+		return packets.Publish
+	case *packets.PubackPacket:
+		return packets.Puback
+	case *packets.PubrecPacket:
+		return packets.Pubrec
+	case *packets.PubrelPacket:
+		return packets.Pubrel
+	case *packets.PubcompPacket:
+		return packets.Pubcomp
+	case *packets.SubscribePacket:
+		return packets.Subscribe
+	case *packets.SubackPacket:
+		return packets.Suback
+	case *packets.UnsubscribePacket:
+		return packets.Unsubscribe
+	case *packets.UnsubackPacket:
+		return packets.Unsuback
+	case *packets.PingreqPacket:
+		return packets.Pingreq
+	case *packets.PingrespPacket:
+		return packets.Pingresp
+	case *packets.DisconnectPacket:
+		return packets.Disconnect
+	}
+	return 0
+}
+
+// PacketMetrics returns the number of packets of each type (keyed by the packet name, e.g.
+// "PUBLISH") sent and received since the client was created or ResetPacketMetrics was called
+func (c *client) PacketMetrics() map[string]PacketTypeMetric {
+	m := make(map[string]PacketTypeMetric, len(packets.PacketNames))
+	for t, name := range packets.PacketNames {
+		m[name] = PacketTypeMetric{
+			Sent:     atomic.LoadInt64(&c.packetMetrics.sent[t]),
+			Received: atomic.LoadInt64(&c.packetMetrics.received[t]),
+		}
+	}
+	return m
+}
+
+// ResetPacketMetrics sets the packet counters to zero
+func (c *client) ResetPacketMetrics() {
+	for t := range c.packetMetrics.sent {
+		atomic.StoreInt64(&c.packetMetrics.sent[t], 0)
+		atomic.StoreInt64(&c.packetMetrics.received[t], 0)
+	}
+}
@@ -15,6 +15,8 @@
 package mqtt
 
 import (
+	"errors"
+	"io"
 	"net"
 	"reflect"
 	"strings"
@@ -91,18 +93,91 @@ type inbound struct {
 	cp  packets.ControlPacket
 }
 
+// ErrReadStall is the error returned when a packet has been partially received but no further data
+// arrives within the ReadStallTimeout
+var ErrReadStall = errors.New("read stalled part way through a packet")
+
+// ErrPacketReadTimeout is the error returned when a packet is not completely received within the
+// PacketReadTimeout of its first byte arriving
+var ErrPacketReadTimeout = errors.New("packet not completely received within timeout")
+
+// stallReader wraps a net.Conn and detects connections that stall part way through a packet. Once the
+// first byte of a packet has been received each read must return within timeout (the deadline is
+// extended whenever data arrives) and the whole packet must arrive within packetTimeout (this deadline
+// is not extended). A zero value disables the relevant check. Waiting for the start of a packet is not
+// limited (the keepalive routine takes care of idle connections).
+type stallReader struct {
+	conn           net.Conn
+	timeout        time.Duration
+	packetTimeout  time.Duration
+	packetDeadline time.Time // time by which the current packet must be complete (if packetTimeout != 0)
+	started        bool      // true once the first byte of the current packet has been read
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	if r.started {
+		var deadline time.Time
+		if r.timeout > 0 {
+			deadline = time.Now().Add(r.timeout)
+		}
+		if r.packetTimeout > 0 && (deadline.IsZero() || r.packetDeadline.Before(deadline)) {
+			deadline = r.packetDeadline
+		}
+		if err := r.conn.SetReadDeadline(deadline); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.conn.Read(p)
+	if n > 0 && !r.started {
+		r.started = true
+		r.packetDeadline = time.Now().Add(r.packetTimeout)
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		if r.packetTimeout > 0 && !time.Now().Before(r.packetDeadline) {
+			return n, ErrPacketReadTimeout
+		}
+		return n, ErrReadStall
+	}
+	return n, err
+}
+
+// packetComplete must be called after each packet has been read; it removes the deadline so that the
+// connection can sit idle waiting for the next packet
+func (r *stallReader) packetComplete() {
+	if r.started {
+		r.started = false
+		if err := r.conn.SetReadDeadline(time.Time{}); err != nil {
+			ERROR.Println(NET, err)
+		}
+	}
+}
+
 // startIncoming initiates a goroutine that reads incoming messages off the wire and sends them to the channel (returned).
 // If there are any issues with the network connection then the returned cahnnel will be closed and the goroutine will exit
 // (so closing the connection will terminate the goroutine)
-func startIncoming(conn net.Conn) <-chan inbound {
+// If stallTimeout is non-zero then the read will fail with ErrReadStall if a partially received packet stalls for
+// longer than stallTimeout. If packetTimeout is non-zero then the read will fail with ErrPacketReadTimeout if a
+// packet is not complete within packetTimeout of its first byte arriving.
+func startIncoming(conn net.Conn, stallTimeout, packetTimeout time.Duration) <-chan inbound {
 	var err error
 	var cp packets.ControlPacket
+	var r io.Reader = conn
+	var sr *stallReader
 	ibound := make(chan inbound)
 
+	if stallTimeout > 0 || packetTimeout > 0 {
+		sr = &stallReader{conn: conn, timeout: stallTimeout, packetTimeout: packetTimeout}
+		r = sr
+	}
+
 	DEBUG.Println(NET, "incoming started")
 	go func() {
 		for {
-			if cp, err = packets.ReadPacket(conn); err != nil {
+			cp, err = packets.ReadPacket(r)
+			if sr != nil {
+				sr.packetComplete()
+			}
+			if err != nil {
 				// We do not want to log the error if it is due to the network connection having been closed
 				// elsewhere (i.e. after sending DisconnectPacket). Detecting this situation is the subject of
 				// https://github.com/golang/go/issues/4373
@@ -137,7 +212,7 @@ func startIncommingComms(conn net.Conn,
 	c commsFns,
 	inboundFromStore <-chan packets.ControlPacket,
 ) <-chan incommingComms {
-	ibound := startIncoming(conn) // Start goroutine that reads from network connection
+	ibound := startIncoming(conn, c.getReadStallTimeout(), c.getPacketReadTimeout()) // Start goroutine that reads from network connection
 	output := make(chan incommingComms)
 
 	DEBUG.Println(NET, "startIncommingComms started")
@@ -176,6 +251,7 @@ func startIncommingComms(conn net.Conn,
 
 				c.persistInbound(msg)
 				c.UpdateLastReceived() // Notify keepalive logic that we recently received a packet
+				c.notifyPacketReceived(msg)
 			}
 
 			switch m := msg.(type) {
@@ -188,8 +264,24 @@ func startIncommingComms(conn net.Conn,
 				switch t := token.(type) {
 				case *SubscribeToken:
 					DEBUG.Println(NET, "granted qoss", m.ReturnCodes)
+					rejected := 0
+					var granted []int // indexes of the granted filters (recorded once the lock is released)
+					t.m.Lock()
 					for i, qos := range m.ReturnCodes {
 						t.subResult[t.subs[i]] = qos
+						if qos == 0x80 {
+							rejected++
+						} else if i < len(t.filters) {
+							granted = append(granted, i)
+						}
+					}
+					t.m.Unlock()
+					for _, i := range granted {
+						c.addSubscription(t.filters[i], m.ReturnCodes[i])
+					}
+					if rejected > 0 && rejected == len(m.ReturnCodes) {
+						WARN.Println(NET, "all subscriptions rejected, id:", m.MessageID)
+						t.setError(ErrSubscriptionRejected)
 					}
 				}
 				token.flowComplete()
@@ -261,6 +353,16 @@ func startOutgoingComms(conn net.Conn,
 				}
 				msg := pub.p.(*packets.PublishPacket)
 
+				// Record in the store that the publish has been sent so that, if it is resent, DUP is
+				// set [MQTT-3.3.1-1]. This is skipped if the publish is no longer in flight (e.g. it has
+				// been abandoned by PublishWithDeadline) so that it is not put back in the store; the
+				// check and update are made with the id held so the publish cannot be abandoned between them.
+				if msg.Qos > 0 && !msg.Dup {
+					dup := *msg
+					dup.Dup = true
+					c.persistInFlight(&dup, pub.t)
+				}
+
 				writeTimeout := c.getWriteTimeOut()
 				if writeTimeout > 0 {
 					if err := conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
@@ -289,6 +391,7 @@ func startOutgoingComms(conn net.Conn,
 				if msg.Qos == 0 {
 					pub.t.flowComplete()
 				}
+				c.notifyPacketSent(msg)
 				DEBUG.Println(NET, "obound wrote msg, id:", msg.MessageID)
 			case msg, ok := <-oboundp:
 				if !ok {
@@ -304,7 +407,14 @@ func startOutgoingComms(conn net.Conn,
 					errChan <- err
 					continue
 				}
+				c.notifyPacketSent(msg.p)
 				switch msg.p.(type) {
+				case *packets.PingreqPacket:
+					seq := c.pingReqSent()
+					if t, ok := msg.t.(*pingToken); ok {
+						t.seq = seq
+						t.flowComplete()
+					}
 				case *packets.DisconnectPacket:
 					msg.t.(*DisconnectToken).flowComplete()
 					DEBUG.Println(NET, "outbound wrote disconnect, closing connection")
@@ -326,6 +436,7 @@ func startOutgoingComms(conn net.Conn,
 					errChan <- err
 					continue
 				}
+				c.notifyPacketSent(msg.p)
 			}
 			c.UpdateLastSent() // Record that a packet has been received (for keepalive routine)
 		}
@@ -335,21 +446,29 @@ func startOutgoingComms(conn net.Conn,
 
 // commsFns provide access to the client state (messageids, requesting disconnection and updating timing)
 type commsFns interface {
-	getToken(id uint16) tokenCompletor       // Retrieve the token for the specified messageid (if none then a dummy token must be returned)
-	freeID(id uint16)                        // Release the specified messageid (clearing out of any persistant store)
-	UpdateLastReceived()                     // Must be called whenever a packet is received
-	UpdateLastSent()                         // Must be called whenever a packet is successfully sent
-	getWriteTimeOut() time.Duration          // Return the writetimeout (or 0 if none)
-	persistOutbound(m packets.ControlPacket) // add the packet to the outbound store
-	persistInbound(m packets.ControlPacket)  // add the packet to the inbound store
-	pingRespReceived()                       // Called when a ping response is received
+	getToken(id uint16) tokenCompletor                         // Retrieve the token for the specified messageid (if none then a dummy token must be returned)
+	freeID(id uint16)                                          // Release the specified messageid (clearing out of any persistant store)
+	UpdateLastReceived()                                       // Must be called whenever a packet is received
+	UpdateLastSent()                                           // Must be called whenever a packet is successfully sent
+	getWriteTimeOut() time.Duration                            // Return the writetimeout (or 0 if none)
+	getReadStallTimeout() time.Duration                        // Return the read stall timeout (or 0 if none)
+	getPacketReadTimeout() time.Duration                       // Return the maximum time to read a packet (or 0 if none)
+	notifyPacketSent(m packets.ControlPacket)                  // Called whenever a packet is successfully sent
+	notifyPacketReceived(m packets.ControlPacket)              // Called whenever a packet is received off the network
+	persistOutbound(m packets.ControlPacket)                   // add the packet to the outbound store
+	persistInFlight(m packets.ControlPacket, t tokenCompletor) // persistOutbound if the packet's id is still held by t (atomically)
+	persistInbound(m packets.ControlPacket)                    // add the packet to the inbound store
+	pingRespReceived()                                         // Called when a ping response is received
+	pingReqSent() uint64                                       // Called when a ping request is written (returns the number written)
+	addSubscription(filter string, qos byte)                   // Called when the broker acknowledges a subscription
 }
 
 // startComms initiates goroutines that handles communications over the network connection
 // Messages will be stored (via commsFns) and deleted from the store as neccessary
 // It returns two channels:
-//  packets.PublishPacket - Will receive publish packets received over the network. Closed when incomming comms routines exit (on shutdown or if network link closed)
-//  error - Any errors will be sent on this channel. The channel is closed when all comms routines have shut down
+//
+//	packets.PublishPacket - Will receive publish packets received over the network. Closed when incomming comms routines exit (on shutdown or if network link closed)
+//	error - Any errors will be sent on this channel. The channel is closed when all comms routines have shut down
 //
 // Note: The comms routines monitoring oboundp and obound will not shutdown until those channels are both closed. Any messages received between the
 // connection being closed and those channels being closed will generate errors (and nothing will be sent). That way the chance of a deadlock is
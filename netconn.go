@@ -30,6 +30,12 @@ import (
 // This just establishes the network connection; once established the type of connection should be irrelevant
 //
 
+// DefaultOpenConnection opens a network connection to uri in the same way as a client that has no
+// CustomOpenConnectionFn set. It can be used as, or called from, an OpenConnectionFunc.
+func DefaultOpenConnection(uri *url.URL, options ClientOptions) (net.Conn, error) {
+	return openConnection(uri, options.TLSConfig, options.ConnectTimeout, options.HTTPHeaders)
+}
+
 // openConnection opens a network connection using the protocol indicated in the URL. Does not carry out any MQTT specific handshakes
 func openConnection(uri *url.URL, tlsc *tls.Config, timeout time.Duration, headers http.Header) (net.Conn, error) {
 	switch uri.Scheme {
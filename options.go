@@ -18,7 +18,9 @@
 package mqtt
 
 import (
+	"context"
 	"crypto/tls"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -35,6 +37,39 @@ type CredentialsProvider func() (username string, password string)
 // to which the client is subscribed.
 type MessageHandler func(Client, Message)
 
+// MessageHandlerWithContext is the same as MessageHandler but is also passed a context. The
+// context is cancelled when the connection the message was received on is closed (either
+// because Disconnect was called or the connection was lost) so long running handlers can
+// use it to exit early.
+type MessageHandlerWithContext func(context.Context, Client, Message)
+
+// ACLOperation identifies the operation being checked by a TopicACL
+type ACLOperation int
+
+const (
+	// ACLPublish is a publish to the topic
+	ACLPublish ACLOperation = iota
+	// ACLSubscribe is a subscription to the topic filter
+	ACLSubscribe
+)
+
+// TopicACL is called before a PUBLISH or SUBSCRIBE is sent and returns true if the operation on
+// the topic (or topic filter) is permitted
+type TopicACL func(topic string, op ACLOperation) bool
+
+// BufferSizeConfig holds the sizes of the client's internal channels (see SetInternalBufferSize).
+// A size of 0 means unbuffered (the default).
+type BufferSizeConfig struct {
+	PublishQueue    uint // outgoing PUBLISH packets waiting to be sent
+	SubscribeQueue  uint // outgoing SUBSCRIBE/UNSUBSCRIBE (and other non-publish) packets waiting to be sent
+	MsgReceiveQueue uint // incoming messages waiting to be passed to handlers
+}
+
+// ResubscribeHook is called for each subscription before it is restored following a reconnection
+// (see SetAutoResubscribe). It is passed the topic filter and the QoS previously granted and
+// returns the QoS to request.
+type ResubscribeHook func(filter string, qos byte) byte
+
 // ConnectionLostHandler is a callback type which can be set to be
 // executed upon an unintended disconnection from the MQTT broker.
 // Disconnects caused by calling Disconnect or ForceDisconnect will
@@ -50,6 +85,15 @@ type OnConnectHandler func(Client)
 // the initial connection is lost
 type ReconnectHandler func(Client, *ClientOptions)
 
+// PreConnectHandler is invoked before every connection attempt and
+// may modify the options (e.g. to refresh credentials)
+type PreConnectHandler func(*ClientOptions) error
+
+// OpenConnectionFunc is invoked to establish the underlying network connection
+// Its purpose is to allow custom network transports.
+// Does not carry out any MQTT specific handshakes.
+type OpenConnectionFunc func(uri *url.URL, options ClientOptions) (net.Conn, error)
+
 // ClientOptions contains configurable options for an Client.
 type ClientOptions struct {
 	Servers                 []*url.URL
@@ -83,6 +127,49 @@ type ClientOptions struct {
 	MessageChannelDepth     uint
 	ResumeSubs              bool
 	HTTPHeaders             http.Header
+	SubscribeTimeout        time.Duration
+	SubscribeRetryCount     int
+	ReadStallTimeout        time.Duration
+	PacketReadTimeout       time.Duration
+	CustomOpenConnectionFn  OpenConnectionFunc
+	EventHandler            EventHandler
+	DeduplicationSize       int
+	DeduplicationTTL        time.Duration
+	connectLimiter          *connectLimiter
+	BufferSizes             BufferSizeConfig
+	CertificatePins         [][]byte
+	MaxReconnectAttempts    int
+	TopicACL                TopicACL
+	QoSAckCallback          QoSAckCallback
+	HandlerConcurrency      int
+	handlerConcurrencySet   bool
+	SubscriptionManager     SubscriptionManager
+	PerTopicOrdering        bool
+	ProtocolVersionFallback bool
+	PublishInterceptor      PublishInterceptor
+	ReceiveInterceptor      ReceiveInterceptor
+	PacketLogLevel          PacketLogLevel
+	ClientIDValidator       func(id string) error
+	OnPreConnect            PreConnectHandler
+	ConnectionEventLogSize  int
+	MaxConnectPacketSize    int
+	TLSUseSystemCerts       bool
+	TLSExtraCACerts         [][]byte
+	PublishRetryPolicy      *RetryPolicy
+	DetailedConnectErrors   bool
+
+	// DefaultPublishHandlerWithContext, if set, is used in place of DefaultPublishHandler
+	DefaultPublishHandlerWithContext MessageHandlerWithContext
+	// AutoResubscribe and SkipResubscribeIfSessionPresent control the restoration of
+	// subscriptions following a reconnection (see SetAutoResubscribe)
+	AutoResubscribe                 bool
+	SkipResubscribeIfSessionPresent bool
+	ResubscribeHook                 ResubscribeHook
+	// PublishQueueAlarm is called when the publish queue crosses the water marks (see
+	// SetPublishQueueAlarm)
+	PublishQueueAlarm         func(aboveHigh bool)
+	PublishQueueHighWaterMark int
+	PublishQueueLowWaterMark  int
 }
 
 // NewClientOptions will create a new ClientClientOptions type with some
@@ -94,6 +181,7 @@ type ClientOptions struct {
 //   ConnectTimeout: 30 (seconds)
 //   MaxReconnectInterval 10 (minutes)
 //   AutoReconnect: True
+//   HandlerConcurrency: not set (handlers are called sequentially as Order is true)
 func NewClientOptions() *ClientOptions {
 	o := &ClientOptions{
 		Servers:                 nil,
@@ -122,7 +210,12 @@ func NewClientOptions() *ClientOptions {
 		WriteTimeout:            0, // 0 represents timeout disabled
 		ResumeSubs:              false,
 		HTTPHeaders:             make(map[string][]string),
+		SubscribeTimeout:        0, // 0 represents timeout disabled
+		SubscribeRetryCount:     0,
+		ReadStallTimeout:        0, // 0 represents timeout disabled
+		PacketReadTimeout:       0, // 0 represents timeout disabled
 	}
+	o.SkipResubscribeIfSessionPresent = true
 	return o
 }
 
@@ -167,6 +260,14 @@ func (o *ClientOptions) SetClientID(id string) *ClientOptions {
 	return o
 }
 
+// SetClientIDValidator sets a function used to check the client id against a naming policy. It is
+// called by NewClient (which logs any error) and before every connection attempt; if it returns an
+// error the attempt is abandoned, without contacting the broker, with an InvalidClientIDError.
+func (o *ClientOptions) SetClientIDValidator(fn func(id string) error) *ClientOptions {
+	o.ClientIDValidator = fn
+	return o
+}
+
 // SetUsername will set the username to be used by this client when connecting
 // to the MQTT broker. Note: without the use of SSL/TLS, this information will
 // be sent in plaintext across the wire.
@@ -207,11 +308,63 @@ func (o *ClientOptions) SetCleanSession(clean bool) *ClientOptions {
 // each QoS level. By default, this value is true. If set to false,
 // this flag indicates that messages can be delivered asynchronously
 // from the client to the application and possibly arrive out of order.
+// A concurrency set with SetHandlerConcurrency takes precedence over this setting.
 func (o *ClientOptions) SetOrderMatters(order bool) *ClientOptions {
 	o.Order = order
 	return o
 }
 
+// PerTopicConcurrency may be passed to SetHandlerConcurrency to run handlers in parallel whilst
+// retaining the order of messages received on each topic
+const PerTopicConcurrency = -1
+
+// SetHandlerConcurrency determines how message handlers are called. With n=1 handlers are called
+// sequentially in the order messages are received. With n=0 each handler is called in its own
+// goroutine and with n>1 a pool of n goroutines calls the handlers so order is not guaranteed.
+// PerTopicConcurrency spreads topics over a goroutine per CPU so messages on the same topic are
+// handled in order. This takes precedence over SetOrderMatters, which only determines how handlers
+// are called (n=1 if true, n=0 if false) when no concurrency has been set.
+func (o *ClientOptions) SetHandlerConcurrency(n int) *ClientOptions {
+	o.HandlerConcurrency = n
+	o.handlerConcurrencySet = true
+	return o
+}
+
+// handlerConcurrency returns the concurrency passed to the router. If it has not been set (with
+// SetHandlerConcurrency or, for a value other than 0, by setting HandlerConcurrency) it follows Order.
+func (o *ClientOptions) handlerConcurrency() int {
+	if o.HandlerConcurrency != 0 || o.handlerConcurrencySet {
+		return o.HandlerConcurrency
+	}
+	if o.Order {
+		return 1
+	}
+	return 0
+}
+
+// SetPerTopicOrdering, if true, serialises publishes to each topic; a publish is not passed to
+// the network until the previous publish to the same topic has completed (been acknowledged by
+// the broker, or written for QoS 0). Publishes to different topics proceed in parallel. If more
+// than 100 publishes to one topic are waiting, Publish blocks until one completes.
+func (o *ClientOptions) SetPerTopicOrdering(ordered bool) *ClientOptions {
+	o.PerTopicOrdering = ordered
+	return o
+}
+
+// SetPublishInterceptor sets an interceptor that is called for every Publish; use
+// ChainPublishInterceptors to combine several
+func (o *ClientOptions) SetPublishInterceptor(i PublishInterceptor) *ClientOptions {
+	o.PublishInterceptor = i
+	return o
+}
+
+// SetReceiveInterceptor sets an interceptor that is called for every message received before it
+// is routed to the message handlers; use ChainReceiveInterceptors to combine several
+func (o *ClientOptions) SetReceiveInterceptor(i ReceiveInterceptor) *ClientOptions {
+	o.ReceiveInterceptor = i
+	return o
+}
+
 // SetTLSConfig will set an SSL/TLS configuration to be used when connecting
 // to an MQTT broker. Please read the official Go documentation for more
 // information.
@@ -233,6 +386,12 @@ func (o *ClientOptions) SetStore(s Store) *ClientOptions {
 // should wait before sending a PING request to the broker. This will
 // allow the client to know that a connection has not been lost with the
 // server.
+// A value of 0 disables the keepalive mechanism; no PINGREQ packets are
+// sent and a dead connection may go undetected until the client next tries
+// to send. 0 is also sent to the broker, which means that the client is
+// opting out, not requesting an infinite keepalive; the broker may still
+// disconnect the client at any time. Values of less than a second are
+// treated as 0.
 func (o *ClientOptions) SetKeepAlive(k time.Duration) *ClientOptions {
 	o.KeepAlive = int64(k / time.Second)
 	return o
@@ -262,6 +421,32 @@ func (o *ClientOptions) UnsetWill() *ClientOptions {
 	return o
 }
 
+// SetDetailedConnectErrors causes Connect to return a *ConnectError, holding the CONNACK return
+// code and protocol version, when the broker refuses the connection. By default the matching
+// error from packets.ConnErrors is returned so that it can be compared with ==; a ConnectError
+// only matches those errors with errors.Is.
+func (o *ClientOptions) SetDetailedConnectErrors(detailed bool) *ClientOptions {
+	o.DetailedConnectErrors = detailed
+	return o
+}
+
+// SetProtocolVersionFallback, if true, allows the client to retry with MQTT 3.1 when the broker
+// rejects an MQTT 3.1.1 connection with "unacceptable protocol version" even though the version
+// was set with SetProtocolVersion (where no version is set the client always falls back).
+func (o *ClientOptions) SetProtocolVersionFallback(fallback bool) *ClientOptions {
+	o.ProtocolVersionFallback = fallback
+	return o
+}
+
+// SetPacketLogLevel sets which packets sent and received are logged to the PACKET logger; for
+// example LogConnections logs only CONNECT, CONNACK and DISCONNECT (and errors) whilst LogAll logs
+// every packet. Each packet is logged with its type, id and key fields (topic, QoS, return codes);
+// payloads and passwords are never logged. The default is LogNone.
+func (o *ClientOptions) SetPacketLogLevel(level PacketLogLevel) *ClientOptions {
+	o.PacketLogLevel = level
+	return o
+}
+
 // SetWill accepts a string will message to be set. When the client connects,
 // it will give this will message to the broker, which will then publish the
 // provided payload (the will) to any clients that are subscribed to the provided
@@ -291,6 +476,14 @@ func (o *ClientOptions) SetDefaultPublishHandler(defaultHandler MessageHandler)
 	return o
 }
 
+// SetDefaultPublishHandlerWithContext is the same as SetDefaultPublishHandler but the handler
+// is also passed a context that is cancelled when the connection is closed. If set it is used
+// in place of any handler set with SetDefaultPublishHandler.
+func (o *ClientOptions) SetDefaultPublishHandlerWithContext(defaultHandler MessageHandlerWithContext) *ClientOptions {
+	o.DefaultPublishHandlerWithContext = defaultHandler
+	return o
+}
+
 // SetOnConnectHandler sets the function to be called when the client is connected. Both
 // at initial connection time and upon automatic reconnect.
 func (o *ClientOptions) SetOnConnectHandler(onConn OnConnectHandler) *ClientOptions {
@@ -312,6 +505,16 @@ func (o *ClientOptions) SetReconnectingHandler(cb ReconnectHandler) *ClientOptio
 	return o
 }
 
+// SetOnPreConnect sets a callback that is executed before every connection attempt, both the
+// initial connection (including retries when ConnectRetry is set) and reconnections. It may
+// update options such as the Username and Password, for example to supply a fresh token. If it
+// returns an error the attempt is abandoned; the error is returned by Connect or, when
+// reconnecting, passed to the ConnectionLostHandler (the client does not retry).
+func (o *ClientOptions) SetOnPreConnect(cb PreConnectHandler) *ClientOptions {
+	o.OnPreConnect = cb
+	return o
+}
+
 // SetWriteTimeout puts a limit on how long a mqtt publish should block until it unblocks with a
 // timeout error. A duration of 0 never times out. Default never times out
 func (o *ClientOptions) SetWriteTimeout(t time.Duration) *ClientOptions {
@@ -327,6 +530,15 @@ func (o *ClientOptions) SetConnectTimeout(t time.Duration) *ClientOptions {
 	return o
 }
 
+// SetMaxReconnectAttempts sets the number of consecutive failed reconnection attempts after which
+// the client gives up and moves to the disconnected state. The ConnectionLostHandler is then
+// called with a MaxReconnectAttemptsError (which wraps the error from the final attempt). The
+// default, 0, means the client will keep trying indefinitely.
+func (o *ClientOptions) SetMaxReconnectAttempts(n int) *ClientOptions {
+	o.MaxReconnectAttempts = n
+	return o
+}
+
 // SetMaxReconnectInterval sets the maximum time that will be waited between reconnection attempts
 // when connection is lost
 func (o *ClientOptions) SetMaxReconnectInterval(t time.Duration) *ClientOptions {
@@ -366,9 +578,228 @@ func (o *ClientOptions) SetMessageChannelDepth(s uint) *ClientOptions {
 	return o
 }
 
+// SetInternalBufferSize sets the sizes of the channels that queue outgoing packets and incoming
+// messages inside the client. By default these are unbuffered so, for example, Publish blocks until
+// the comms routine picks up the packet. A larger PublishQueue suits clients that publish in bursts
+// and a larger MsgReceiveQueue allows the network to be read while handlers are busy. Once
+// MsgReceiveQueue messages are waiting the client stops reading from the network, so that TCP flow
+// control slows the broker down; where handlers each run on their own goroutine (see
+// SetOrderMatters) MsgReceiveQueue also limits how many may run at once. While reading is paused
+// other packets (such as PINGRESP) are not read either. Packets still queued when the connection
+// drops are discarded: QoS 1 and 2 packets are resent from the store (as packets awaiting
+// acknowledgement are) and others fail with ErrNotConnected.
+func (o *ClientOptions) SetInternalBufferSize(sizes BufferSizeConfig) *ClientOptions {
+	o.BufferSizes = sizes
+	return o
+}
+
+// SetMaxConnectPacketSize sets the largest CONNECT packet the broker will accept (e.g. where the
+// broker limits the packet size). If the CONNECT packet (see EstimateConnectPacketSize) is larger,
+// Connect fails with ErrConnectPacketTooLarge before the connection is opened. 0 (the default)
+// checks only against the largest packet MQTT can encode.
+func (o *ClientOptions) SetMaxConnectPacketSize(size int) *ClientOptions {
+	o.MaxConnectPacketSize = size
+	return o
+}
+
+// SetPublishQueueAlarm sets a function that is called with true when the number of publishes
+// queued for sending (see SetInternalBufferSize) rises above highWaterMark and with false when it
+// then falls below lowWaterMark. It allows the application to stop producing data while the
+// connection cannot keep up (QoS 0 has no flow control). highWaterMark must be less than the
+// PublishQueue size for the alarm to be raised. fn is called synchronously (by Publish or the comms
+// routines) so must not block.
+func (o *ClientOptions) SetPublishQueueAlarm(highWaterMark, lowWaterMark int, fn func(aboveHigh bool)) *ClientOptions {
+	o.PublishQueueHighWaterMark = highWaterMark
+	o.PublishQueueLowWaterMark = lowWaterMark
+	o.PublishQueueAlarm = fn
+	return o
+}
+
+// UseTLSWithSystemCerts configures TLS connections to verify the server using the certificate
+// authorities trusted by the operating system, along with any added with AddTLSCACert. It may be
+// combined with SetTLSConfig (for example to set client certificates) but replaces the RootCAs.
+func (o *ClientOptions) UseTLSWithSystemCerts() *ClientOptions {
+	o.TLSUseSystemCerts = true
+	return o
+}
+
+// AddTLSCACert adds the PEM encoded CA certificate(s) to those used to verify the server. It may
+// be called more than once. Unless UseTLSWithSystemCerts is also used only the certificates added
+// are trusted (replacing any RootCAs in the TLSConfig).
+func (o *ClientOptions) AddTLSCACert(pemBytes []byte) *ClientOptions {
+	o.TLSExtraCACerts = append(o.TLSExtraCACerts, pemBytes)
+	return o
+}
+
+// SetPublishRetryPolicy sets the policy used to retry a Publish that fails with a transient error,
+// such as ErrNoMessageIDsAvailable when the maximum number of messages are in flight, rather than
+// failing the token. nil (the default) disables retries.
+func (o *ClientOptions) SetPublishRetryPolicy(policy *RetryPolicy) *ClientOptions {
+	o.PublishRetryPolicy = policy
+	return o
+}
+
+// SetCertificatePins restricts TLS connections to servers presenting a certificate (anywhere in
+// the chain) whose SHA-256 hash matches one of pins (see GenerateCertificatePin). The check is
+// made in addition to the normal verification performed according to TLSConfig; to pin a
+// self-signed certificate set InsecureSkipVerify in the TLSConfig so that the pin is the only check.
+func (o *ClientOptions) SetCertificatePins(pins [][]byte) *ClientOptions {
+	o.CertificatePins = pins
+	return o
+}
+
+// SetTopicACL sets a function that is called to check each topic passed to Publish and each
+// filter passed to Subscribe (and SubscribeMultiple/SubscribeFilters). If it returns false the
+// token completes with ErrTopicForbidden and nothing is sent to the broker. This is a defense in
+// depth measure and does not replace authorisation by the broker.
+func (o *ClientOptions) SetTopicACL(acl TopicACL) *ClientOptions {
+	o.TopicACL = acl
+	return o
+}
+
+// SetQoSAckCallback sets a function that is called whenever a PUBACK, PUBREC, PUBREL or PUBCOMP
+// is received, allowing each stage of the QoS 1/2 handshakes to be observed (e.g. to measure per
+// stage latency or find where QoS 2 flows stall). The callback is called, in order, from a
+// separate goroutine so it cannot block the network read loop; if it falls more than 1024
+// acknowledgements behind, further acknowledgements are dropped (and a warning logged) until it
+// catches up. MQTT 3.1.1 acknowledgements carry no reason code.
+func (o *ClientOptions) SetQoSAckCallback(cb QoSAckCallback) *ClientOptions {
+	o.QoSAckCallback = cb
+	return o
+}
+
 // SetHTTPHeaders sets the additional HTTP headers that will be sent in the WebSocket
 // opening handshake.
 func (o *ClientOptions) SetHTTPHeaders(h http.Header) *ClientOptions {
 	o.HTTPHeaders = h
 	return o
 }
+
+// SetSubscribeTimeout sets how long the client will wait for a SUBACK after sending a SUBSCRIBE
+// packet. If no SUBACK is received in time the subscribe token completes with ErrSubscribeTimeout
+// (once any retries set with SetSubscribeRetryCount are exhausted). A duration of 0 (the default)
+// waits indefinitely. This is independent of PacketReadTimeout, which only limits how long a packet
+// takes to arrive once it has started, so a broker that is slow to authorise a subscription will
+// not cause a packet read timeout.
+func (o *ClientOptions) SetSubscribeTimeout(t time.Duration) *ClientOptions {
+	o.SubscribeTimeout = t
+	return o
+}
+
+// SetSubscribeRetryCount sets the number of times a SUBSCRIBE packet will be resent (with the same
+// message id) when SubscribeTimeout expires without a SUBACK. The wait doubles after each attempt.
+// Only used when SubscribeTimeout is non-zero. Default 0 (no retries).
+func (o *ClientOptions) SetSubscribeRetryCount(n int) *ClientOptions {
+	o.SubscribeRetryCount = n
+	return o
+}
+
+// SetReadStallTimeout sets the maximum time that may pass without any data being received once the
+// client has started receiving a packet. If this is exceeded the connection is considered lost (with
+// ErrReadStall) and, if enabled, the client will reconnect. This detects connections that remain open
+// but deliver data extremely slowly; it does not limit how long the connection may be idle between
+// packets. A duration of 0 (the default) disables the check.
+func (o *ClientOptions) SetReadStallTimeout(t time.Duration) *ClientOptions {
+	o.ReadStallTimeout = t
+	return o
+}
+
+// SetPacketReadTimeout sets the maximum time allowed to receive a complete packet, measured from the
+// arrival of its first byte. If this is exceeded the connection is considered lost (with
+// ErrPacketReadTimeout). Like ReadStallTimeout it does not apply while waiting for a packet to start,
+// but it limits the total time taken so also catches packets that trickle in a byte at a time.
+// A duration of 0 (the default) disables the check.
+func (o *ClientOptions) SetPacketReadTimeout(d time.Duration) *ClientOptions {
+	o.PacketReadTimeout = d
+	return o
+}
+
+// SetCustomOpenConnectionFn replaces the inbuilt function that establishes a network connection with a custom function.
+// The passed in function should return an open `net.Conn` or an error (see the existing openConnection function for an example)
+// It enables custom networking types in addition to the defaults (tcp, tls, websockets...)
+func (o *ClientOptions) SetCustomOpenConnectionFn(customOpenConnectionFn OpenConnectionFunc) *ClientOptions {
+	if customOpenConnectionFn != nil {
+		o.CustomOpenConnectionFn = customOpenConnectionFn
+	}
+	return o
+}
+
+// SetEventHandler sets a function that will be called for every event published on the client's
+// internal event bus (connection state changes, packets sent/received and changes to the number of
+// inflight messages). It is intended for monitoring. The handler is called from a separate
+// goroutine, in the order events occur; if it falls more than 1024 events behind, further events
+// are dropped (and a warning logged) until it catches up.
+func (o *ClientOptions) SetEventHandler(h EventHandler) *ClientOptions {
+	o.EventHandler = h
+	return o
+}
+
+// SetConnectionEventLog enables a log of the last capacity connection events (state changes,
+// keepalives and packets sent and received) which can be retrieved with RecentConnectionEvents,
+// for example to see what happened before the connection was lost. 0 (the default) disables it.
+func (o *ClientOptions) SetConnectionEventLog(capacity int) *ClientOptions {
+	o.ConnectionEventLogSize = capacity
+	return o
+}
+
+// SetDeduplicationWindow enables dropping of duplicate QoS 0 messages. The client remembers a hash of
+// the topic and payload of the last size QoS 0 messages received; a message matching one received
+// less than ttl ago is discarded before being passed to any handler. A size of 0 (the default)
+// disables deduplication. Note that this will also drop legitimate repeats of the same reading
+// within the ttl.
+func (o *ClientOptions) SetDeduplicationWindow(size int, ttl time.Duration) *ClientOptions {
+	o.DeduplicationSize = size
+	o.DeduplicationTTL = ttl
+	return o
+}
+
+// SetConnectRateLimit limits the rate of reconnection attempts to rps per second (with bursts of
+// up to burst attempts). Any delay imposed by the limit is in addition to the usual reconnect
+// backoff. The limit is shared by all clients created from these ClientOptions so, when many
+// clients in a process lose their connection at the same time (e.g. because the broker restarted),
+// they will not all attempt to reconnect at once. A rps of 0 or less removes the limit.
+func (o *ClientOptions) SetConnectRateLimit(rps float64, burst int) *ClientOptions {
+	if rps <= 0 {
+		o.connectLimiter = nil
+		return o
+	}
+	o.connectLimiter = newConnectLimiter(rps, burst)
+	return o
+}
+
+// SetAutoResubscribe will, when set to true, cause the client to restore all of the subscriptions
+// acknowledged by the broker after an automatic reconnection. By default (see
+// SetSkipResubscribeIfSessionPresent) this only happens if the broker reports that the session
+// was not present (i.e. CleanSession is true or the broker discarded the session); otherwise the
+// broker already holds the subscriptions. Subscriptions removed with Unsubscribe are not restored.
+func (o *ClientOptions) SetAutoResubscribe(resubscribe bool) *ClientOptions {
+	o.AutoResubscribe = resubscribe
+	return o
+}
+
+// SetSkipResubscribeIfSessionPresent determines whether subscriptions are restored (when
+// AutoResubscribe is enabled) even if the broker reports that the session is present. Defaults
+// to true (do not resubscribe when the session is present).
+func (o *ClientOptions) SetSkipResubscribeIfSessionPresent(skip bool) *ClientOptions {
+	o.SkipResubscribeIfSessionPresent = skip
+	return o
+}
+
+// SetSubscriptionManager sets a SubscriptionManager used to persist subscriptions. Subscriptions
+// are loaded from the manager by NewClient and saved whenever the broker acknowledges a
+// subscription or an unsubscribe is sent. If the broker does not report a session being
+// present on Connect (or on reconnection, subject to SetSkipResubscribeIfSessionPresent) the
+// persisted subscriptions are restored. Handlers are not persisted so AddRoute (or a default
+// publish handler) should be used to process messages on restored subscriptions.
+func (o *ClientOptions) SetSubscriptionManager(m SubscriptionManager) *ClientOptions {
+	o.SubscriptionManager = m
+	return o
+}
+
+// SetResubscribeHook sets a function that is called for each subscription before it is restored by
+// AutoResubscribe. It can return a different QoS (e.g. to reduce the load on the broker after an
+// outage) or simply the QoS passed in to leave the subscription unchanged.
+func (o *ClientOptions) SetResubscribeHook(hook ResubscribeHook) *ClientOptions {
+	o.ResubscribeHook = hook
+	return o
+}
@@ -159,3 +159,147 @@ func (r *ClientOptionsReader) HTTPHeaders() http.Header {
 	h := r.options.HTTPHeaders
 	return h
 }
+
+//SubscribeTimeout returns how long to wait for a SUBACK (0 means wait indefinitely)
+func (r *ClientOptionsReader) SubscribeTimeout() time.Duration {
+	s := r.options.SubscribeTimeout
+	return s
+}
+
+//SubscribeRetryCount returns how many times a SUBSCRIBE will be resent if SubscribeTimeout expires
+func (r *ClientOptionsReader) SubscribeRetryCount() int {
+	s := r.options.SubscribeRetryCount
+	return s
+}
+
+//ReadStallTimeout returns the maximum time a partially received packet may stall for (0 means no limit)
+func (r *ClientOptionsReader) ReadStallTimeout() time.Duration {
+	s := r.options.ReadStallTimeout
+	return s
+}
+
+//DeduplicationSize returns the number of QoS 0 messages remembered for deduplication (0 means disabled)
+func (r *ClientOptionsReader) DeduplicationSize() int {
+	s := r.options.DeduplicationSize
+	return s
+}
+
+//DeduplicationTTL returns how long a QoS 0 message is remembered for deduplication
+func (r *ClientOptionsReader) DeduplicationTTL() time.Duration {
+	s := r.options.DeduplicationTTL
+	return s
+}
+
+//AutoResubscribe returns whether subscriptions are restored following a reconnection
+func (r *ClientOptionsReader) AutoResubscribe() bool {
+	s := r.options.AutoResubscribe
+	return s
+}
+
+//SkipResubscribeIfSessionPresent returns whether restoring subscriptions is skipped when the session is present
+func (r *ClientOptionsReader) SkipResubscribeIfSessionPresent() bool {
+	s := r.options.SkipResubscribeIfSessionPresent
+	return s
+}
+
+//PacketReadTimeout returns the maximum time allowed to receive a packet once it has started (0 means no limit)
+func (r *ClientOptionsReader) PacketReadTimeout() time.Duration {
+	s := r.options.PacketReadTimeout
+	return s
+}
+
+//BufferSizes returns the sizes of the client's internal channels
+func (r *ClientOptionsReader) BufferSizes() BufferSizeConfig {
+	s := r.options.BufferSizes
+	return s
+}
+
+//MaxReconnectAttempts returns the number of failed reconnection attempts after which the client gives up (0 means never)
+func (r *ClientOptionsReader) MaxReconnectAttempts() int {
+	s := r.options.MaxReconnectAttempts
+	return s
+}
+
+//HandlerConcurrency returns how message handlers are called (see SetHandlerConcurrency)
+func (r *ClientOptionsReader) HandlerConcurrency() int {
+	s := r.options.handlerConcurrency()
+	return s
+}
+
+//PerTopicOrdering returns true if publishes to each topic are serialised
+func (r *ClientOptionsReader) PerTopicOrdering() bool {
+	s := r.options.PerTopicOrdering
+	return s
+}
+
+//ProtocolVersionFallback returns true if the client may fall back to MQTT 3.1 when an explicitly set MQTT 3.1.1 is rejected
+func (r *ClientOptionsReader) ProtocolVersionFallback() bool {
+	s := r.options.ProtocolVersionFallback
+	return s
+}
+
+//PacketLogLevel returns the level at which packets are logged to the PACKET logger
+func (r *ClientOptionsReader) PacketLogLevel() PacketLogLevel {
+	s := r.options.PacketLogLevel
+	return s
+}
+
+//ClientIDValidator returns the function used to check the client id (nil if not set)
+func (r *ClientOptionsReader) ClientIDValidator() func(id string) error {
+	s := r.options.ClientIDValidator
+	return s
+}
+
+//OnPreConnect returns the callback executed before each connection attempt (nil if not set)
+func (r *ClientOptionsReader) OnPreConnect() PreConnectHandler {
+	s := r.options.OnPreConnect
+	return s
+}
+
+//ConnectionEventLogSize returns the capacity of the connection event log (0 if disabled)
+func (r *ClientOptionsReader) ConnectionEventLogSize() int {
+	s := r.options.ConnectionEventLogSize
+	return s
+}
+
+//PublishQueueHighWaterMark returns the publish queue length above which the PublishQueueAlarm is raised
+func (r *ClientOptionsReader) PublishQueueHighWaterMark() int {
+	s := r.options.PublishQueueHighWaterMark
+	return s
+}
+
+//PublishQueueLowWaterMark returns the publish queue length below which the PublishQueueAlarm is cleared
+func (r *ClientOptionsReader) PublishQueueLowWaterMark() int {
+	s := r.options.PublishQueueLowWaterMark
+	return s
+}
+
+//MaxConnectPacketSize returns the largest CONNECT packet the client will send (0 for the protocol limit)
+func (r *ClientOptionsReader) MaxConnectPacketSize() int {
+	s := r.options.MaxConnectPacketSize
+	return s
+}
+
+//TLSUseSystemCerts returns true if the system's certificate authorities are used to verify the server
+func (r *ClientOptionsReader) TLSUseSystemCerts() bool {
+	s := r.options.TLSUseSystemCerts
+	return s
+}
+
+//TLSExtraCACerts returns the PEM encoded CA certificates added with AddTLSCACert
+func (r *ClientOptionsReader) TLSExtraCACerts() [][]byte {
+	s := r.options.TLSExtraCACerts
+	return s
+}
+
+//PublishRetryPolicy returns the policy used to retry publishes that fail with a transient error
+func (r *ClientOptionsReader) PublishRetryPolicy() *RetryPolicy {
+	s := r.options.PublishRetryPolicy
+	return s
+}
+
+//DetailedConnectErrors returns true if a refused connection is reported with a *ConnectError
+func (r *ClientOptionsReader) DetailedConnectErrors() bool {
+	s := r.options.DetailedConnectErrors
+	return s
+}
@@ -0,0 +1,116 @@
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// PacketLogLevel determines which packets are written to the PACKET logger (see
+// ClientOptions.SetPacketLogLevel). Each level includes everything logged at the levels below it.
+type PacketLogLevel int
+
+// Packet log levels
+const (
+	LogNone          PacketLogLevel = iota // Nothing is logged
+	LogErrors                              // Refused connections, rejected subscriptions and lost connections
+	LogConnections                         // CONNECT, CONNACK and DISCONNECT
+	LogSubscriptions                       // SUBSCRIBE, SUBACK, UNSUBSCRIBE and UNSUBACK
+	LogPublish                             // PUBLISH and its acknowledgements
+	LogAll                                 // Every packet (i.e. PINGREQ and PINGRESP as well)
+)
+
+// packetLogEvents is subscribed to the event bus when a PacketLogLevel is set
+func (c *client) packetLogEvents(e Event) {
+	switch ev := e.(type) {
+	case PacketSentEvent:
+		c.logPacket("sent", ev.Packet)
+	case PacketReceivedEvent:
+		c.logPacket("received", ev.Packet)
+	case DisconnectedEvent:
+		if ev.Err != nil {
+			PACKET.Println(PKT, "connection lost:", ev.Err)
+		}
+	}
+}
+
+// logPacket logs cp if the PacketLogLevel requires it
+func (c *client) logPacket(direction string, cp packets.ControlPacket) {
+	if packetLogLevel(cp) <= c.options.PacketLogLevel {
+		PACKET.Println(PKT, direction, describePacket(cp))
+	}
+}
+
+// packetLogLevel returns the lowest level at which cp is logged
+func packetLogLevel(cp packets.ControlPacket) PacketLogLevel {
+	switch p := cp.(type) {
+	case *packets.ConnackPacket:
+		if p.ReturnCode != packets.Accepted {
+			return LogErrors
+		}
+		return LogConnections
+	case *packets.ConnectPacket, *packets.DisconnectPacket:
+		return LogConnections
+	case *packets.SubackPacket:
+		for _, rc := range p.ReturnCodes {
+			if rc == 0x80 { // Failure
+				return LogErrors
+			}
+		}
+		return LogSubscriptions
+	case *packets.SubscribePacket, *packets.UnsubscribePacket, *packets.UnsubackPacket:
+		return LogSubscriptions
+	case *packets.PublishPacket, *packets.PubackPacket, *packets.PubrecPacket, *packets.PubrelPacket, *packets.PubcompPacket:
+		return LogPublish
+	}
+	return LogAll
+}
+
+// describePacket returns the packet type, id and key fields of cp. Payloads and passwords are
+// not included.
+func describePacket(cp packets.ControlPacket) string {
+	var b strings.Builder
+	switch p := cp.(type) {
+	case *packets.ConnectPacket:
+		fmt.Fprintf(&b, "CONNECT clientid=%q version=%d cleansession=%t keepalive=%d", p.ClientIdentifier, p.ProtocolVersion, p.CleanSession, p.Keepalive)
+		if p.UsernameFlag {
+			fmt.Fprintf(&b, " username=%q", p.Username)
+		}
+		if p.WillFlag {
+			fmt.Fprintf(&b, " willtopic=%q willqos=%d", p.WillTopic, p.WillQos)
+		}
+	case *packets.ConnackPacket:
+		fmt.Fprintf(&b, "CONNACK sessionpresent=%t returncode=%d (%s)", p.SessionPresent, p.ReturnCode, packets.ConnackReturnCodes[p.ReturnCode])
+	case *packets.PublishPacket:
+		fmt.Fprintf(&b, "PUBLISH id=%d topic=%q qos=%d retain=%t dup=%t len=%d", p.MessageID, p.TopicName, p.Qos, p.Retain, p.Dup, len(p.Payload))
+	case *packets.SubscribePacket:
+		fmt.Fprintf(&b, "SUBSCRIBE id=%d", p.MessageID)
+		for i, t := range p.Topics {
+			fmt.Fprintf(&b, " %q:%d", t, p.Qoss[i])
+		}
+	case *packets.SubackPacket:
+		fmt.Fprintf(&b, "SUBACK id=%d returncodes=%v", p.MessageID, p.ReturnCodes)
+	case *packets.UnsubscribePacket:
+		fmt.Fprintf(&b, "UNSUBSCRIBE id=%d topics=%q", p.MessageID, p.Topics)
+	case *packets.PubackPacket:
+		fmt.Fprintf(&b, "PUBACK id=%d", p.MessageID)
+	case *packets.PubrecPacket:
+		fmt.Fprintf(&b, "PUBREC id=%d", p.MessageID)
+	case *packets.PubrelPacket:
+		fmt.Fprintf(&b, "PUBREL id=%d", p.MessageID)
+	case *packets.PubcompPacket:
+		fmt.Fprintf(&b, "PUBCOMP id=%d", p.MessageID)
+	case *packets.UnsubackPacket:
+		fmt.Fprintf(&b, "UNSUBACK id=%d", p.MessageID)
+	case *packets.PingreqPacket:
+		b.WriteString("PINGREQ")
+	case *packets.PingrespPacket:
+		b.WriteString("PINGRESP")
+	case *packets.DisconnectPacket:
+		b.WriteString("DISCONNECT")
+	default:
+		fmt.Fprintf(&b, "%T", cp)
+	}
+	return b.String()
+}
@@ -235,3 +235,42 @@ func TestEncoding(t *testing.T) {
 		}
 	}
 }
+
+func TestMatchesFilter(t *testing.T) {
+	tests := []struct {
+		topic, filter string
+		match         bool
+	}{
+		{"sensors/1/temperature", "sensors/+/temperature", true},
+		{"sensors/1/humidity", "sensors/+/temperature", false},
+		{"sensors/1/2/temperature", "sensors/+/temperature", false},
+		{"sensors", "sensors/#", true},
+		{"sensors/1/temperature", "sensors/#", true},
+		{"sensors/1/temperature", "#", true},
+		{"sensors/", "sensors/+", true},
+		{"/sensors", "+/sensors", true},
+		{"sensors/1", "sensors/1", true},
+		{"sensors/1", "sensors/2", false},
+		{"sensors/1", "sensors/#/1", false},
+		{"$SYS/broker/uptime", "#", false},
+		{"$SYS/broker/uptime", "+/broker/uptime", false},
+		{"$SYS/broker/uptime", "$SYS/#", true},
+		{"$SYS/broker/uptime", "$SYS/+/uptime", true},
+	}
+	for _, tt := range tests {
+		if res := MatchesFilter(tt.topic, tt.filter); res != tt.match {
+			t.Errorf("MatchesFilter(%q, %q) returned %v, expected %v", tt.topic, tt.filter, res, tt.match)
+		}
+	}
+}
+
+func TestMatchTopics(t *testing.T) {
+	topics := []string{"sensors/1/temperature", "sensors/1/humidity", "sensors/2/temperature", "$SYS/temperature"}
+	res := MatchTopics("sensors/+/temperature", topics)
+	if len(res) != 2 || res[0] != "sensors/1/temperature" || res[1] != "sensors/2/temperature" {
+		t.Errorf("MatchTopics returned %v", res)
+	}
+	if res := MatchTopics("+/temperature", topics); len(res) != 0 {
+		t.Errorf("MatchTopics should not match $ topics with a leading wildcard, returned %v", res)
+	}
+}
@@ -0,0 +1,42 @@
+package packets
+
+import "strings"
+
+//MatchesFilter returns true if the topic name matches the topic filter according
+//to the MQTT rules. '+' matches exactly one level and '#' (which must be the last
+//character of the filter) matches any number of levels including the parent level.
+//Topics starting with '$' are not matched by filters starting with a wildcard
+//(section 4.7.2).
+func MatchesFilter(topic, filter string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	return matchLevels(strings.Split(filter, "/"), strings.Split(topic, "/"))
+}
+
+//MatchTopics returns the subset of topics that match the topic filter (the order
+//of topics is preserved)
+func MatchTopics(filter string, topics []string) []string {
+	var matched []string
+	for _, t := range topics {
+		if MatchesFilter(t, filter) {
+			matched = append(matched, t)
+		}
+	}
+	return matched
+}
+
+func matchLevels(filter []string, topic []string) bool {
+	for i, f := range filter {
+		if f == "#" {
+			return i == len(filter)-1
+		}
+		if i >= len(topic) {
+			return false
+		}
+		if f != "+" && f != topic[i] {
+			return false
+		}
+	}
+	return len(filter) == len(topic)
+}
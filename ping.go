@@ -15,6 +15,7 @@
 package mqtt
 
 import (
+	"context"
 	"errors"
 	"io"
 	"sync/atomic"
@@ -28,16 +29,17 @@ import (
 func keepalive(c *client, conn io.Writer) {
 	defer c.workers.Done()
 	DEBUG.Println(PNG, "keepalive starting")
-	var checkInterval int64
+	var checkInterval time.Duration
 	var pingSent time.Time
 
 	if c.options.KeepAlive > 10 {
-		checkInterval = 5
+		checkInterval = 5 * time.Second
 	} else {
-		checkInterval = c.options.KeepAlive / 2
+		// time.Duration is used so that a KeepAlive of 1 results in a 500ms interval (not 0)
+		checkInterval = time.Duration(c.options.KeepAlive) * time.Second / 2
 	}
 
-	intervalTicker := time.NewTicker(time.Duration(checkInterval * int64(time.Second)))
+	intervalTicker := time.NewTicker(checkInterval)
 	defer intervalTicker.Stop()
 
 	for {
@@ -59,6 +61,9 @@ func keepalive(c *client, conn io.Writer) {
 					atomic.StoreInt32(&c.pingOutstanding, 1)
 					if err := ping.Write(conn); err != nil {
 						ERROR.Println(PNG, err)
+					} else {
+						c.pingReqSent()
+						c.notifyPacketSent(ping)
 					}
 					c.lastSent.Store(time.Now())
 					pingSent = time.Now()
@@ -72,3 +77,103 @@ func keepalive(c *client, conn io.Writer) {
 		}
 	}
 }
+
+// ErrPingTimeout is the error returned by Healthcheck when the client is connected but no
+// PINGRESP was received before the context was done
+var ErrPingTimeout = errors.New("pingresp not received")
+
+// Healthcheck sends a PINGREQ to the broker and waits for a PINGRESP, the context controls how long
+// to wait. It returns ErrNotConnected if there is no active connection and ErrPingTimeout if the
+// broker does not respond in time. As the broker responds to PINGREQs in order, the check is
+// satisfied by the response to this request (or to one sent after it by the keepalive routine)
+// but not by a response to an earlier request.
+func (c *client) Healthcheck(ctx context.Context) error {
+	if !c.IsConnectionOpen() {
+		return ErrNotConnected
+	}
+
+	ping := packets.NewControlPacket(packets.Pingreq).(*packets.PingreqPacket)
+	token := &pingToken{baseToken: baseToken{complete: make(chan struct{})}}
+	select {
+	case c.oboundP <- &PacketAndToken{p: ping, t: token}:
+	case <-ctx.Done():
+		return ErrPingTimeout
+	}
+	if !waitTokenContext(ctx, token) {
+		return ErrPingTimeout
+	}
+	if err := token.Error(); err != nil {
+		return err
+	}
+
+	resp := c.addPingWaiter(token.seq)
+	defer c.removePingWaiter(resp)
+	select {
+	case <-resp:
+		return nil
+	case <-ctx.Done():
+		return ErrPingTimeout
+	}
+}
+
+// pingToken is completed when the PINGREQ sent by Healthcheck has been written
+type pingToken struct {
+	baseToken
+	seq uint64 // the number of PINGREQs written, including this one
+}
+
+// pingWaiter is a Healthcheck call waiting for the PINGRESP to the seq'th PINGREQ
+type pingWaiter struct {
+	seq uint64
+	ch  chan struct{}
+}
+
+// pingReqSent is called whenever a PINGREQ has been written and returns the number written
+func (c *client) pingReqSent() uint64 {
+	c.pingWaitersMu.Lock()
+	defer c.pingWaitersMu.Unlock()
+	c.pingsSent++
+	return c.pingsSent
+}
+
+// addPingWaiter returns a channel that is closed once seq PINGRESPs have been received
+func (c *client) addPingWaiter(seq uint64) chan struct{} {
+	c.pingWaitersMu.Lock()
+	defer c.pingWaitersMu.Unlock()
+	ch := make(chan struct{})
+	if c.pingResps >= seq {
+		close(ch)
+		return ch
+	}
+	c.pingWaiters = append(c.pingWaiters, pingWaiter{seq: seq, ch: ch})
+	return ch
+}
+
+// notifyPingWaiters records that a PINGRESP has been received, releasing any Healthcheck calls
+// waiting on it
+func (c *client) notifyPingWaiters() {
+	c.pingWaitersMu.Lock()
+	defer c.pingWaitersMu.Unlock()
+	c.pingResps++
+	waiting := c.pingWaiters[:0]
+	for _, w := range c.pingWaiters {
+		if c.pingResps >= w.seq {
+			close(w.ch)
+		} else {
+			waiting = append(waiting, w)
+		}
+	}
+	c.pingWaiters = waiting
+}
+
+// removePingWaiter removes the channel from the waiter list (if it is still present)
+func (c *client) removePingWaiter(w chan struct{}) {
+	c.pingWaitersMu.Lock()
+	defer c.pingWaitersMu.Unlock()
+	for i, pw := range c.pingWaiters {
+		if pw.ch == w {
+			c.pingWaiters = append(c.pingWaiters[:i], c.pingWaiters[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package mqtt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+//ErrCertificateNotPinned is the error returned from the TLS handshake when none of the
+//certificates presented by the server match a pin set with SetCertificatePins
+var ErrCertificateNotPinned = errors.New("server certificate does not match any pinned certificate")
+
+// GenerateCertificatePin returns the pin for cert (the SHA-256 hash of its DER encoding) for use
+// with ClientOptions.SetCertificatePins
+func GenerateCertificatePin(cert *x509.Certificate) []byte {
+	h := sha256.Sum256(cert.Raw)
+	return h[:]
+}
+
+// pinnedTLSConfig returns a copy of conf (which may be nil) that, in addition to any existing
+// verification, requires at least one of the certificates presented by the server to match a pin
+func pinnedTLSConfig(conf *tls.Config, pins [][]byte) *tls.Config {
+	if conf == nil {
+		conf = &tls.Config{}
+	} else {
+		conf = conf.Clone()
+	}
+	verify := conf.VerifyPeerCertificate
+	conf.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if verify != nil {
+			if err := verify(rawCerts, verifiedChains); err != nil {
+				return err
+			}
+		}
+		for _, raw := range rawCerts {
+			h := sha256.Sum256(raw)
+			for _, pin := range pins {
+				if bytes.Equal(h[:], pin) {
+					return nil
+				}
+			}
+		}
+		return ErrCertificateNotPinned
+	}
+	return conf
+}
@@ -0,0 +1,137 @@
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+//ErrNotSupported is the error returned when an operation is not supported, for example
+//subscribing using a PublishPool
+var ErrNotSupported = errors.New("operation not supported")
+
+// PublishPool spreads publishes over a fixed number of connections to the same broker so that
+// throughput is not limited by a single TCP stream. Each connection is a separate Client which
+// reconnects independently (as configured in the options). Subscriptions are not supported.
+//
+// Messages published through the pool may be delivered out of order as they may be sent on
+// different connections.
+type PublishPool struct {
+	clients []*client
+	next    uint32
+}
+
+// NewPublishPool creates a pool of size clients using the options provided. The ClientID of each
+// client has "-" and its index appended (an empty ClientID is left empty). Each client uses its
+// own MemoryStore; any Store (and SubscriptionManager) in the options is ignored.
+func NewPublishPool(size int, o *ClientOptions) *PublishPool {
+	if size < 1 {
+		size = 1
+	}
+	p := &PublishPool{clients: make([]*client, size)}
+	for i := range p.clients {
+		co := *o
+		if co.ClientID != "" {
+			co.ClientID = fmt.Sprintf("%s-%d", o.ClientID, i)
+		}
+		co.Store = nil
+		co.SubscriptionManager = nil
+		p.clients[i] = NewClient(&co).(*client)
+	}
+	return p
+}
+
+// Clients returns the clients in the pool, for example so their status can be monitored
+func (p *PublishPool) Clients() []Client {
+	cs := make([]Client, len(p.clients))
+	for i, c := range p.clients {
+		cs[i] = c
+	}
+	return cs
+}
+
+// Connect connects all of the clients in the pool, waiting for each to complete. The error from
+// the first client that failed to connect is returned; clients that did connect remain connected.
+func (p *PublishPool) Connect() error {
+	errs := make([]error, len(p.clients))
+	var wg sync.WaitGroup
+	for i, c := range p.clients {
+		wg.Add(1)
+		go func(i int, c *client) {
+			defer wg.Done()
+			t := c.Connect()
+			t.Wait()
+			errs[i] = t.Error()
+		}(i, c)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsConnected returns true if any of the clients in the pool is connected
+func (p *PublishPool) IsConnected() bool {
+	for _, c := range p.clients {
+		if c.IsConnected() {
+			return true
+		}
+	}
+	return false
+}
+
+// Disconnect disconnects all of the clients in the pool, waiting up to quiesce milliseconds for
+// each to complete existing work
+func (p *PublishPool) Disconnect(quiesce uint) {
+	var wg sync.WaitGroup
+	for _, c := range p.clients {
+		wg.Add(1)
+		go func(c *client) {
+			defer wg.Done()
+			c.Disconnect(quiesce)
+		}(c)
+	}
+	wg.Wait()
+}
+
+// Publish publishes the message using the connected client with the fewest publishes in flight
+// (queued for sending or awaiting acknowledgement). Ties are broken round-robin.
+func (p *PublishPool) Publish(topic string, qos byte, retained bool, payload interface{}) Token {
+	c := p.pick()
+	if c == nil {
+		token := newToken(packets.Publish).(*PublishToken)
+		token.setError(ErrNotConnected)
+		return token
+	}
+	return c.Publish(topic, qos, retained, payload)
+}
+
+// pick returns the least loaded connected client, or nil if no client is connected
+func (p *PublishPool) pick() *client {
+	start := int(atomic.AddUint32(&p.next, 1)) % len(p.clients)
+	var best *client
+	bestLoad := 0
+	for i := range p.clients {
+		c := p.clients[(start+i)%len(p.clients)]
+		if !c.IsConnectionOpen() {
+			continue
+		}
+		if load := c.pendingPublishes(); best == nil || load < bestLoad {
+			best, bestLoad = c, load
+		}
+	}
+	return best
+}
+
+// Subscribe is not supported; the token returned has the error ErrNotSupported
+func (p *PublishPool) Subscribe(topic string, qos byte, callback MessageHandler) Token {
+	token := newToken(packets.Subscribe).(*SubscribeToken)
+	token.setError(ErrNotSupported)
+	return token
+}
@@ -0,0 +1,97 @@
+package mqtt
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// priorityItem is a publish made with PriorityPublish
+type priorityItem struct {
+	priority int
+	seq      uint64 // maintains the order of publishes with the same priority
+	req      publishRequest
+}
+
+// priorityHeap implements heap.Interface; the highest priority (then the earliest) item is first
+type priorityHeap []priorityItem
+
+func (h priorityHeap) Len() int { return len(h) }
+func (h priorityHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h priorityHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *priorityHeap) Push(x interface{}) { *h = append(*h, x.(priorityItem)) }
+func (h *priorityHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// priorityQueue holds publishes made with PriorityPublish until they can be passed to the comms
+// goroutines. Publishes move straight through while there is room in the outgoing queue so
+// priority only changes the order when the connection cannot keep up.
+type priorityQueue struct {
+	mu      sync.Mutex
+	items   priorityHeap
+	seq     uint64
+	running bool // true while servicePriorityQueue is running
+}
+
+// PriorityPublish publishes as Publish but publishes waiting to be sent are sent in priority order
+// (higher values first, publishes with the same priority in the order made). Priority has no
+// effect while publishes can be sent as soon as they are made and only applies to publishes made
+// with PriorityPublish (PerTopicOrdering is not applied to them).
+func (c *client) PriorityPublish(priority int, topic string, qos byte, retained bool, payload interface{}) Token {
+	send := func(pub *packets.PublishPacket, token *PublishToken) {
+		c.queuePriorityPublish(priority, pub, token)
+	}
+	if c.options.PublishInterceptor != nil {
+		return c.interceptPublish(send, topic, qos, retained, payload)
+	}
+	return c.publish(send, topic, qos, retained, payload)
+}
+
+// queuePriorityPublish adds the publish to the priority queue, starting the goroutine that
+// services the queue if it is not running
+func (c *client) queuePriorityPublish(priority int, pub *packets.PublishPacket, token *PublishToken) {
+	q := &c.priorityQ
+	q.mu.Lock()
+	heap.Push(&q.items, priorityItem{priority: priority, seq: q.seq, req: publishRequest{pub: pub, token: token}})
+	q.seq++
+	if !q.running {
+		q.running = true
+		go c.servicePriorityQueue()
+	}
+	q.mu.Unlock()
+}
+
+// servicePriorityQueue sends the highest priority publish, waiting until it has been accepted
+// into the outgoing queue before taking the next. It exits when the queue is empty.
+func (c *client) servicePriorityQueue() {
+	q := &c.priorityQ
+	for {
+		q.mu.Lock()
+		if len(q.items) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		req := heap.Pop(&q.items).(priorityItem).req
+		q.mu.Unlock()
+
+		if c.connectionStatus() == disconnected {
+			if req.pub.MessageID != 0 {
+				c.freeID(req.pub.MessageID)
+			}
+			req.token.setError(ErrNotConnected)
+			continue
+		}
+		c.sendPublish(req.pub, req.token)
+	}
+}
@@ -0,0 +1,64 @@
+package mqtt
+
+import "github.com/eclipse/paho.mqtt.golang/packets"
+
+// QoSStage identifies the acknowledgement packet passed to a QoSAckCallback
+type QoSStage int
+
+const (
+	// StagePUBACK is a PUBACK received for a QoS 1 message sent by the client
+	StagePUBACK QoSStage = iota
+	// StagePUBREC is a PUBREC received for a QoS 2 message sent by the client
+	StagePUBREC
+	// StagePUBREL is a PUBREL received for a QoS 2 message sent by the broker
+	StagePUBREL
+	// StagePUBCOMP is a PUBCOMP received for a QoS 2 message sent by the client
+	StagePUBCOMP
+)
+
+func (s QoSStage) String() string {
+	switch s {
+	case StagePUBACK:
+		return "PUBACK"
+	case StagePUBREC:
+		return "PUBREC"
+	case StagePUBREL:
+		return "PUBREL"
+	case StagePUBCOMP:
+		return "PUBCOMP"
+	}
+	return "unknown"
+}
+
+// QoSAckCallback is called for each QoS 1/2 acknowledgement packet received (see SetQoSAckCallback)
+type QoSAckCallback func(stage QoSStage, packetID uint16)
+
+// newQoSAckHandler returns the event handler that passes acknowledgement packets to cb. cb is
+// called, in order, from a goroutine of its own so that a slow callback does not hold up the read
+// loop; if it falls too far behind, acknowledgements are dropped (and a warning logged).
+func newQoSAckHandler(cb QoSAckCallback) EventHandler {
+	out := newAsyncEventHandler(func(e Event) {
+		p := e.(PacketReceivedEvent).Packet
+		cb(qosStage(p), p.Details().MessageID)
+	}, eventQueueSize)
+	return func(e Event) {
+		if ev, ok := e.(PacketReceivedEvent); ok && qosStage(ev.Packet) >= 0 {
+			out.handle(e)
+		}
+	}
+}
+
+// qosStage returns the stage that p acknowledges, or -1 if it is not an acknowledgement packet
+func qosStage(p packets.ControlPacket) QoSStage {
+	switch p.(type) {
+	case *packets.PubackPacket:
+		return StagePUBACK
+	case *packets.PubrecPacket:
+		return StagePUBREC
+	case *packets.PubrelPacket:
+		return StagePUBREL
+	case *packets.PubcompPacket:
+		return StagePUBCOMP
+	}
+	return -1
+}
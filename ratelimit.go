@@ -0,0 +1,42 @@
+package mqtt
+
+import (
+	"sync"
+	"time"
+)
+
+// connectLimiter is a token bucket used to limit the rate at which reconnection attempts are made.
+// A single limiter may be shared by many clients.
+type connectLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64 // maximum number of tokens
+	tokens float64
+	last   time.Time
+}
+
+// newConnectLimiter returns a limiter allowing rps attempts per second with bursts of up to burst
+// attempts
+func newConnectLimiter(rps float64, burst int) *connectLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &connectLimiter{rate: rps, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// reserve takes a token from the bucket and returns how long the caller must wait before using it
+func (l *connectLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
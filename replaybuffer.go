@@ -0,0 +1,137 @@
+package mqtt
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// ReplayBuffer wraps a Client, keeping the most recent messages received on watched topics so
+// that they can be replayed to new subscribers (unlike retained messages, which are limited to
+// one per topic). Messages are recorded as they are passed to handlers registered through the
+// ReplayBuffer's Subscribe; other Client methods are passed straight through.
+type ReplayBuffer struct {
+	Client
+	size int
+
+	mu      sync.Mutex
+	watches map[string]int         // topic filter -> number of messages to keep per topic
+	buffers map[string]*replayRing // topic -> recent messages
+}
+
+// replayRing is a circular buffer holding the most recent messages on a topic
+type replayRing struct {
+	msgs []Message
+	next int
+	full bool
+}
+
+func (r *replayRing) add(m Message) {
+	r.msgs[r.next] = m
+	r.next = (r.next + 1) % len(r.msgs)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// last returns the most recently added message (nil if none)
+func (r *replayRing) last() Message {
+	if !r.full && r.next == 0 {
+		return nil
+	}
+	return r.msgs[(r.next+len(r.msgs)-1)%len(r.msgs)]
+}
+
+// all returns the messages, oldest first
+func (r *replayRing) all() []Message {
+	if !r.full {
+		return append([]Message(nil), r.msgs[:r.next]...)
+	}
+	return append(append([]Message(nil), r.msgs[r.next:]...), r.msgs[:r.next]...)
+}
+
+// NewReplayBuffer returns a ReplayBuffer wrapping c; size is the number of messages kept per
+// topic where Watch is called with n <= 0
+func NewReplayBuffer(c Client, size int) *ReplayBuffer {
+	return &ReplayBuffer{
+		Client:  c,
+		size:    size,
+		watches: make(map[string]int),
+		buffers: make(map[string]*replayRing),
+	}
+}
+
+// Watch starts recording messages on topics matching filter, keeping the last n on each topic
+func (b *ReplayBuffer) Watch(filter string, n int) {
+	if n <= 0 {
+		n = b.size
+	}
+	b.mu.Lock()
+	b.watches[filter] = n
+	b.mu.Unlock()
+}
+
+// Subscribe subscribes (via the wrapped Client) to topic. Once the subscription is acknowledged
+// any buffered messages on topics matching it are passed to callback (oldest first for each
+// topic); these may be interleaved with new messages. A nil callback (the default handler) is
+// passed straight through without replay.
+func (b *ReplayBuffer) Subscribe(topic string, qos byte, callback MessageHandler) Token {
+	if callback == nil {
+		return b.Client.Subscribe(topic, qos, nil)
+	}
+	t := b.Client.Subscribe(topic, qos, func(c Client, m Message) {
+		b.record(m)
+		callback(c, m)
+	})
+	go func() {
+		if t.Wait() && t.Error() == nil {
+			for _, m := range b.buffered(topic) {
+				callback(b.Client, m)
+			}
+		}
+	}()
+	return t
+}
+
+// record adds m to the buffer for its topic if the topic is watched. A message that matches
+// multiple subscriptions is only recorded once.
+func (b *ReplayBuffer) record(m Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ring, ok := b.buffers[m.Topic()]
+	if !ok {
+		n := 0
+		for filter, keep := range b.watches {
+			if keep > n && packets.MatchesFilter(m.Topic(), filter) {
+				n = keep
+			}
+		}
+		if n <= 0 {
+			return
+		}
+		ring = &replayRing{msgs: make([]Message, n)}
+		b.buffers[m.Topic()] = ring
+	}
+	if ring.last() != m {
+		ring.add(m)
+	}
+}
+
+// buffered returns the buffered messages on topics matching filter
+func (b *ReplayBuffer) buffered(filter string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	topics := make([]string, 0, len(b.buffers))
+	for topic := range b.buffers {
+		if packets.MatchesFilter(topic, filter) {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Strings(topics)
+	var msgs []Message
+	for _, topic := range topics {
+		msgs = append(msgs, b.buffers[topic].all()...)
+	}
+	return msgs
+}
@@ -0,0 +1,99 @@
+package mqtt
+
+import (
+	"errors"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// Backoff returns how long to wait before retry number attempt (starting at 1)
+type Backoff func(attempt int) time.Duration
+
+// ExponentialBackoff returns a Backoff that waits min before the first retry, doubling the wait
+// for each further retry up to max
+func ExponentialBackoff(min, max time.Duration) Backoff {
+	return func(attempt int) time.Duration {
+		d := min
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+}
+
+// RetryPolicy determines how a Publish that fails is retried (see ClientOptions.SetPublishRetryPolicy)
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts made, including the first
+	MaxAttempts int
+	// Backoff determines the wait between attempts; if nil ExponentialBackoff(100ms, 10s) is used
+	Backoff Backoff
+	// RetryOn returns true if a publish that failed with err should be retried; if nil
+	// DefaultRetryOn is used
+	RetryOn func(err error) bool
+}
+
+// DefaultRetryOn returns true for ErrNoMessageIDsAvailable, which is set when the maximum number of
+// messages are awaiting acknowledgement so the publish may succeed once some are acknowledged
+func DefaultRetryOn(err error) bool {
+	return errors.Is(err, ErrNoMessageIDsAvailable)
+}
+
+// publishWithRetry makes a Publish, retrying according to policy. Each attempt is a complete
+// Publish, so any PublishInterceptor is called for every attempt. The token returned completes
+// with the result of the final attempt.
+func (c *client) publishWithRetry(policy *RetryPolicy, topic string, qos byte, retained bool, payload interface{}) Token {
+	retryOn := policy.RetryOn
+	if retryOn == nil {
+		retryOn = DefaultRetryOn
+	}
+	backoff := policy.Backoff
+	if backoff == nil {
+		backoff = ExponentialBackoff(100*time.Millisecond, 10*time.Second)
+	}
+
+	first := c.publishOnce(topic, qos, retained, payload)
+	if policy.MaxAttempts <= 1 {
+		return first
+	}
+	// Most publishes either succeed or fail permanently, in which case the token can be returned
+	// without starting a goroutine. Errors such as ErrNoMessageIDsAvailable are set before
+	// publishOnce returns.
+	if pt, ok := first.(*PublishToken); ok {
+		select {
+		case <-pt.complete:
+			if pt.Error() == nil || !retryOn(pt.Error()) {
+				return first
+			}
+		default:
+		}
+	}
+
+	token := newToken(packets.Publish).(*PublishToken)
+	go func() {
+		t := first
+		for attempt := 1; ; attempt++ {
+			t.Wait()
+			err := t.Error()
+			if err == nil || attempt >= policy.MaxAttempts || !retryOn(err) {
+				break
+			}
+			wait := backoff(attempt)
+			DEBUG.Println(CLI, "publish to", topic, "failed, retrying in", wait, ":", err)
+			time.Sleep(wait)
+			t = c.publishOnce(topic, qos, retained, payload)
+		}
+		if pt, ok := t.(*PublishToken); ok {
+			token.messageID = pt.messageID
+		}
+		if err := t.Error(); err != nil {
+			token.setError(err)
+			return
+		}
+		token.flowComplete()
+	}()
+	return token
+}
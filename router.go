@@ -16,6 +16,9 @@ package mqtt
 
 import (
 	"container/list"
+	"context"
+	"hash/fnv"
+	"runtime"
 	"strings"
 	"sync"
 
@@ -27,7 +30,7 @@ import (
 // with a subscription to that topic.
 type route struct {
 	topic    string
-	callback MessageHandler
+	callback MessageHandlerWithContext
 }
 
 // match takes a slice of strings which represent the route being tested having been split on '/'
@@ -70,6 +73,13 @@ func routeSplit(route string) []string {
 	return result
 }
 
+// routeKey returns the route used for a subscription to filter; $queue/ is removed as the broker
+// does not include it in the topic of messages, a $share/{group}/ prefix is kept (see routeSplit)
+// so that the route remains distinct from that of a normal subscription to the same filter
+func routeKey(filter string) string {
+	return strings.TrimPrefix(filter, "$queue/")
+}
+
 // match takes the topic string of the published message and does a basic compare to the
 // string of the current Route, if they match it returns true
 func (r *route) match(topic string) bool {
@@ -79,7 +89,7 @@ func (r *route) match(topic string) bool {
 type router struct {
 	sync.RWMutex
 	routes         *list.List
-	defaultHandler MessageHandler
+	defaultHandler MessageHandlerWithContext
 	messages       chan *packets.PublishPacket
 }
 
@@ -94,6 +104,11 @@ func newRouter() *router {
 // routes to see if there is already a matching Route. If there is it replaces the current
 // callback with the new one. If not it add a new entry to the list of Routes.
 func (r *router) addRoute(topic string, callback MessageHandler) {
+	r.addRouteWithContext(topic, withContext(callback))
+}
+
+// addRouteWithContext is the same as addRoute but takes a handler which accepts a context
+func (r *router) addRouteWithContext(topic string, callback MessageHandlerWithContext) {
 	r.Lock()
 	defer r.Unlock()
 	for e := r.routes.Front(); e != nil; e = e.Next() {
@@ -122,54 +137,138 @@ func (r *router) deleteRoute(topic string) {
 // setDefaultHandler assigns a default callback that will be called if no matching Route
 // is found for an incoming Publish.
 func (r *router) setDefaultHandler(handler MessageHandler) {
+	r.setDefaultHandlerWithContext(withContext(handler))
+}
+
+// setDefaultHandlerWithContext is the same as setDefaultHandler but takes a handler which
+// accepts a context
+func (r *router) setDefaultHandlerWithContext(handler MessageHandlerWithContext) {
 	r.Lock()
 	defer r.Unlock()
 	r.defaultHandler = handler
 }
 
+// withContext converts a MessageHandler into a MessageHandlerWithContext (the context is ignored)
+func withContext(handler MessageHandler) MessageHandlerWithContext {
+	if handler == nil {
+		return nil
+	}
+	return func(_ context.Context, c Client, m Message) {
+		handler(c, m)
+	}
+}
+
 // matchAndDispatch takes a channel of Message pointers as input and starts a go routine that
 // takes messages off the channel, matches them against the internal route list and calls the
 // associated callback (or the defaultHandler, if one exists and no other route matched). If
-// anything is sent down the stop channel the function will end.
-func (r *router) matchAndDispatch(messages <-chan *packets.PublishPacket, order bool, client *client) {
-	for message := range messages {
-		// DEBUG.Println(ROU, "matchAndDispatch received message")
-		sent := false
+// anything is sent down the stop channel the function will end. ctx is passed to the handlers.
+// concurrency determines how handlers are run (see ClientOptions.SetHandlerConcurrency).
+func (r *router) matchAndDispatch(ctx context.Context, messages <-chan *packets.PublishPacket, concurrency int, client *client) {
+	// Messages are passed to workers when handlers run on a fixed number of goroutines
+	var workers []chan func()
+	var wg sync.WaitGroup
+	switch {
+	case concurrency == PerTopicConcurrency:
+		workers = make([]chan func(), runtime.NumCPU())
+	case concurrency > 1:
+		workers = make([]chan func(), 1)
+	}
+	for i := range workers {
+		workers[i] = make(chan func())
+		n := 1
+		if concurrency > 1 {
+			n = concurrency
+		}
+		for j := 0; j < n; j++ {
+			wg.Add(1)
+			go func(work <-chan func()) {
+				defer wg.Done()
+				for f := range work {
+					f()
+				}
+			}(workers[i])
+		}
+	}
+
+	// When handlers each run on a new goroutine the number running may be limited so that, once the
+	// limit is reached, messages stop being read from the network
+	var running chan struct{}
+	if concurrency == 0 && client.options.BufferSizes.MsgReceiveQueue > 0 {
+		running = make(chan struct{}, client.options.BufferSizes.MsgReceiveQueue)
+	}
+
+	// dispatch routes m to the matching handlers, which are passed ctx
+	dispatch := func(ctx context.Context, m Message) {
 		r.RLock()
-		m := messageFromPublish(message, ackFunc(client.oboundP, client.persist, message))
-		handlers := []MessageHandler{}
+		handlers := []MessageHandlerWithContext{}
 		for e := r.routes.Front(); e != nil; e = e.Next() {
-			if e.Value.(*route).match(message.TopicName) {
-				if order {
-					handlers = append(handlers, e.Value.(*route).callback)
-				} else {
-					hd := e.Value.(*route).callback
-					go func() {
-						hd(client, m)
-						m.Ack()
-					}()
-				}
-				sent = true
+			if e.Value.(*route).match(m.Topic()) {
+				handlers = append(handlers, e.Value.(*route).callback)
 			}
 		}
-		if !sent && r.defaultHandler != nil {
-			if order {
-				handlers = append(handlers, r.defaultHandler)
-			} else {
+		if len(handlers) == 0 && r.defaultHandler != nil {
+			handlers = append(handlers, r.defaultHandler)
+		}
+		r.RUnlock()
+
+		switch {
+		case concurrency == 0:
+			for _, handler := range handlers {
+				hd := handler
+				if running != nil {
+					running <- struct{}{}
+				}
 				go func() {
-					r.defaultHandler(client, m)
+					hd(ctx, client, m)
 					m.Ack()
+					if running != nil {
+						<-running
+					}
 				}()
 			}
-		}
-		r.RUnlock()
-		for _, handler := range handlers {
-			func() {
-				handler(client, m)
+		case workers == nil:
+			for _, handler := range handlers {
+				handler(ctx, client, m)
 				m.Ack()
-			}()
+			}
+		default:
+			w := workers[0]
+			if len(workers) > 1 {
+				h := fnv.New32a()
+				_, _ = h.Write([]byte(m.Topic()))
+				w = workers[h.Sum32()%uint32(len(workers))]
+			}
+			w <- func() {
+				for _, handler := range handlers {
+					handler(ctx, client, m)
+					m.Ack()
+				}
+			}
+		}
+	}
+
+	for message := range messages {
+		// DEBUG.Println(ROU, "matchAndDispatch received message")
+		m := messageFromPublish(message, ackFunc(client.oboundP, client.persist, message))
+		if client.options.ReceiveInterceptor == nil {
+			dispatch(ctx, m)
+			continue
+		}
+		dispatched := false
+		err := client.options.ReceiveInterceptor(ctx, m, func(ctx context.Context, m Message) error {
+			dispatched = true
+			dispatch(ctx, m)
+			return nil
+		})
+		if !dispatched {
+			DEBUG.Println(ROU, "message on", message.TopicName, "discarded by interceptor:", err)
+			m.Ack()
 		}
 		// DEBUG.Println(ROU, "matchAndDispatch handled message")
 	}
+	for _, w := range workers {
+		close(w)
+	}
+	wg.Wait()
 	DEBUG.Println(ROU, "matchAndDispatch exiting")
 }
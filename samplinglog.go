@@ -0,0 +1,71 @@
+package mqtt
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// SamplingLogger logs one in every sampleRate PUBLISH packets received by a client so that
+// intermittent issues can be diagnosed on high throughput connections without the cost of
+// logging every message. Each entry holds the topic, QoS, message id, retain and duplicate flags
+// and the payload size (the payload itself is not logged).
+type SamplingLogger struct {
+	logger      Logger
+	out         *asyncEventHandler // logs sampled events without holding up the comms routines
+	rate        uint64
+	received    uint64 // PUBLISH packets received since the logger was created
+	unsubscribe func()
+}
+
+// NewSamplingLogger starts logging every sampleRate-th message received by c (a sampleRate
+// below 1 logs every message). c must have been created with NewClient; for any other
+// implementation of Client nothing is logged.
+func NewSamplingLogger(c Client, sampleRate int, logger Logger) *SamplingLogger {
+	if sampleRate < 1 {
+		sampleRate = 1
+	}
+	s := &SamplingLogger{logger: logger, rate: uint64(sampleRate), unsubscribe: func() {}}
+	s.out = newAsyncEventHandler(s.log, eventQueueSize)
+	cl, ok := c.(*client)
+	if !ok {
+		WARN.Println(CLI, "sampling logger requires a client created with NewClient")
+		return s
+	}
+	var once sync.Once
+	unsubscribe := cl.events.subscribe(s.sample)
+	s.unsubscribe = func() { once.Do(unsubscribe) }
+	return s
+}
+
+// Received returns the number of messages seen by the logger (including those not logged)
+func (s *SamplingLogger) Received() uint64 {
+	return atomic.LoadUint64(&s.received)
+}
+
+// Stop stops logging; it is safe to call more than once
+func (s *SamplingLogger) Stop() {
+	s.unsubscribe()
+}
+
+// sample is the event handler that counts received publishes, passing those sampled to log
+func (s *SamplingLogger) sample(e Event) {
+	ev, ok := e.(PacketReceivedEvent)
+	if !ok {
+		return
+	}
+	if _, ok := ev.Packet.(*packets.PublishPacket); !ok {
+		return
+	}
+	if atomic.AddUint64(&s.received, 1)%s.rate == 0 {
+		s.out.handle(ev)
+	}
+}
+
+// log writes a sampled publish to the logger
+func (s *SamplingLogger) log(e Event) {
+	p := e.(PacketReceivedEvent).Packet.(*packets.PublishPacket)
+	s.logger.Printf("%s sampled message: topic=%q qos=%d id=%d retain=%t dup=%t len=%d",
+		CLI, p.TopicName, p.Qos, p.MessageID, p.Retain, p.Dup, len(p.Payload))
+}
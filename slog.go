@@ -0,0 +1,102 @@
+//go:build go1.21
+// +build go1.21
+
+package mqtt
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"runtime"
+	"strings"
+)
+
+// SlogHandler is a slog.Handler that publishes each log record, encoded as JSON (in the format
+// produced by slog.JSONHandler), to the topic
+//
+//	<prefix>/<level>/<function>
+//
+// where level is debug, info, warn or error (levels between these are rounded down) and function is the function that made the logging
+// call (with any '/' replaced by '.'); the function level is omitted if it is unknown. Records are
+// published at QoS 0 and Handle does not wait for them to be sent. The handler must not be used
+// for the logging of the client it publishes through (see DEBUG etc) as this would recurse.
+type SlogHandler struct {
+	client Client
+	prefix string
+	level  slog.Leveler
+	ops    []func(slog.Handler) slog.Handler // WithAttrs/WithGroup calls, applied in order
+}
+
+// NewSlogHandler returns a SlogHandler publishing through c to topics beginning with prefix.
+// Records below level are discarded (a nil level means slog.LevelInfo).
+func NewSlogHandler(c Client, prefix string, level slog.Leveler) *SlogHandler {
+	if level == nil {
+		level = slog.LevelInfo
+	}
+	return &SlogHandler{client: c, prefix: prefix, level: level}
+}
+
+// Enabled reports whether records at level are published
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle publishes r; an error is returned if the client is unable to accept the message
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	var jh slog.Handler = slog.NewJSONHandler(&buf, &slog.HandlerOptions{AddSource: r.PC != 0})
+	for _, op := range h.ops {
+		jh = op(jh)
+	}
+	if err := jh.Handle(ctx, r); err != nil {
+		return err
+	}
+	payload := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	return h.client.Publish(h.topic(r), 0, false, payload).Error()
+}
+
+// topic returns the topic that r is published to
+func (h *SlogHandler) topic(r slog.Record) string {
+	var level string
+	switch {
+	case r.Level < slog.LevelInfo:
+		level = "debug"
+	case r.Level < slog.LevelWarn:
+		level = "info"
+	case r.Level < slog.LevelError:
+		level = "warn"
+	default:
+		level = "error"
+	}
+	topic := h.prefix + "/" + level
+	if r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		if frame.Function != "" {
+			topic += "/" + strings.ReplaceAll(frame.Function, "/", ".")
+		}
+	}
+	return topic
+}
+
+// WithAttrs returns a handler that includes attrs in every record
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	return h.with(func(jh slog.Handler) slog.Handler { return jh.WithAttrs(attrs) })
+}
+
+// WithGroup returns a handler that places subsequent attributes in the named group
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(func(jh slog.Handler) slog.Handler { return jh.WithGroup(name) })
+}
+
+// with returns a copy of h with op appended
+func (h *SlogHandler) with(op func(slog.Handler) slog.Handler) *SlogHandler {
+	h2 := *h
+	h2.ops = append(append([]func(slog.Handler) slog.Handler(nil), h.ops...), op)
+	return &h2
+}
@@ -0,0 +1,62 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// SubscriptionManager persists the subscriptions acknowledged by the broker so that they can be
+// restored when the client starts without a session (e.g. following a power failure). Save is
+// called with the full set of subscriptions (topic filter to granted QoS) whenever it changes.
+type SubscriptionManager interface {
+	Load() (map[string]byte, error)
+	Save(subs map[string]byte) error
+}
+
+// FileSubscriptionManager implements SubscriptionManager by storing the subscriptions, as JSON,
+// in a single file
+type FileSubscriptionManager struct {
+	sync.Mutex
+	filename string
+}
+
+// NewFileSubscriptionManager returns a FileSubscriptionManager that stores subscriptions in filename
+func NewFileSubscriptionManager(filename string) *FileSubscriptionManager {
+	return &FileSubscriptionManager{filename: filename}
+}
+
+// Load returns the stored subscriptions (an empty map if the file does not exist)
+func (m *FileSubscriptionManager) Load() (map[string]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+	subs := make(map[string]byte)
+	b, err := ioutil.ReadFile(m.filename)
+	if os.IsNotExist(err) {
+		return subs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// Save replaces the stored subscriptions. The file is written via a temporary file so a failure
+// part way through does not lose the previous contents.
+func (m *FileSubscriptionManager) Save(subs map[string]byte) error {
+	m.Lock()
+	defer m.Unlock()
+	b, err := json.Marshal(subs)
+	if err != nil {
+		return err
+	}
+	tmp := m.filename + tmpExt
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.filename)
+}
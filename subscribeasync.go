@@ -0,0 +1,87 @@
+package mqtt
+
+import (
+	"context"
+	"time"
+)
+
+// Limits on the delay between SubscribeAsync attempts; the delay doubles after each failure
+const (
+	subscribeAsyncMinBackoff = 500 * time.Millisecond
+	subscribeAsyncMaxBackoff = 30 * time.Second
+)
+
+// SubscribeAsync subscribes to filter in the background, retrying (with an increasing delay) until
+// the broker acknowledges the subscription. It may be called before Connect; the subscription is
+// made once the client connects. Attempts stop when the subscription succeeds or the client stops
+// (Disconnect is called, or the connection is lost and will not be re-established). The handler is
+// added immediately so it also applies to messages received (e.g. due to a persistent session)
+// before the subscription is acknowledged.
+func (c *client) SubscribeAsync(filter string, qos byte, handler MessageHandler) {
+	if err := validateTopicAndQos(filter, qos); err != nil {
+		ERROR.Println(CLI, "SubscribeAsync to", filter, "failed:", err)
+		return
+	}
+	if handler != nil {
+		c.msgRouter.addRoute(routeKey(filter), handler)
+	}
+
+	// ctx is cancelled when the client stops, which abandons any attempt in progress; wake is
+	// signalled whenever the client connects so that an attempt can be made straight away
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := c.stopped()
+	go func() {
+		select {
+		case <-stopped:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	wake := make(chan struct{}, 1)
+	unsubscribe := c.events.subscribe(func(e Event) {
+		if _, ok := e.(ConnectedEvent); ok {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	go func() {
+		defer unsubscribe()
+		defer cancel()
+		backoff := subscribeAsyncMinBackoff
+		for {
+			if c.IsConnectionOpen() {
+				t := c.Subscribe(filter, qos, handler)
+				timeout := c.options.SubscribeTimeout
+				if timeout == 0 {
+					timeout = subscribeAsyncMaxBackoff
+				}
+				tctx, tcancel := context.WithTimeout(ctx, timeout)
+				completed := waitTokenContext(tctx, t)
+				tcancel()
+				if completed && t.Error() == nil {
+					DEBUG.Println(CLI, "SubscribeAsync to", filter, "complete")
+					return
+				}
+				if ctx.Err() == nil {
+					WARN.Println(CLI, "SubscribeAsync to", filter, "failed, will retry:", t.Error())
+				}
+			}
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				DEBUG.Println(CLI, "SubscribeAsync to", filter, "abandoned as the client has stopped")
+				return
+			case <-wake: // Connected so try again now
+				timer.Stop()
+			case <-timer.C:
+				if backoff *= 2; backoff > subscribeAsyncMaxBackoff {
+					backoff = subscribeAsyncMaxBackoff
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,111 @@
+package mqtt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// subscriptionRegistry holds the subscriptions that the broker has acknowledged (keyed by the
+// topic filter sent to the broker, including any $share prefix) so that they can be restored if
+// the session is lost
+type subscriptionRegistry struct {
+	sync.Mutex
+	subs map[string]byte
+}
+
+// add records that filter has been subscribed to at the given (granted) qos
+func (r *subscriptionRegistry) add(filter string, qos byte) {
+	r.Lock()
+	defer r.Unlock()
+	if r.subs == nil {
+		r.subs = make(map[string]byte)
+	}
+	r.subs[filter] = qos
+}
+
+// remove deletes the filters from the registry
+func (r *subscriptionRegistry) remove(filters ...string) {
+	r.Lock()
+	defer r.Unlock()
+	for _, f := range filters {
+		delete(r.subs, f)
+	}
+}
+
+// snapshot returns a copy of the current subscriptions
+func (r *subscriptionRegistry) snapshot() map[string]byte {
+	r.Lock()
+	defer r.Unlock()
+	s := make(map[string]byte, len(r.subs))
+	for f, q := range r.subs {
+		s[f] = q
+	}
+	return s
+}
+
+// subscriptionNode is a level in the tree built by tree
+type subscriptionNode struct {
+	children map[string]*subscriptionNode
+	qos      byte
+	isFilter bool // true if a subscribed filter ends at this level
+}
+
+// tree renders the subscriptions as an ASCII tree with a branch for each topic level, e.g.
+//
+//	sensors
+//	└── +
+//	    └── temperature (QoS 1)
+func (r *subscriptionRegistry) tree() string {
+	root := &subscriptionNode{children: make(map[string]*subscriptionNode)}
+	for filter, qos := range r.snapshot() {
+		n := root
+		for _, level := range strings.Split(filter, "/") {
+			child, ok := n.children[level]
+			if !ok {
+				child = &subscriptionNode{children: make(map[string]*subscriptionNode)}
+				n.children[level] = child
+			}
+			n = child
+		}
+		n.isFilter = true
+		n.qos = qos
+	}
+	var b strings.Builder
+	for _, level := range sortedLevels(root) {
+		writeSubscriptionNode(&b, level, root.children[level], "", "")
+	}
+	return b.String()
+}
+
+// writeSubscriptionNode writes n (and its children) to b; prefix is written before the name of
+// this level and childPrefix before those of its children
+func writeSubscriptionNode(b *strings.Builder, level string, n *subscriptionNode, prefix, childPrefix string) {
+	if level == "" {
+		level = `""` // empty level (e.g. the leading / in /a)
+	}
+	b.WriteString(prefix + level)
+	if n.isFilter {
+		fmt.Fprintf(b, " (QoS %d)", n.qos)
+	}
+	b.WriteString("\n")
+	levels := sortedLevels(n)
+	for i, l := range levels {
+		if i == len(levels)-1 {
+			writeSubscriptionNode(b, l, n.children[l], childPrefix+"└── ", childPrefix+"    ")
+		} else {
+			writeSubscriptionNode(b, l, n.children[l], childPrefix+"├── ", childPrefix+"│   ")
+		}
+	}
+}
+
+// sortedLevels returns the names of the children of n in order
+func sortedLevels(n *subscriptionNode) []string {
+	levels := make([]string, 0, len(n.children))
+	for l := range n.children {
+		levels = append(levels, l)
+	}
+	sort.Strings(levels)
+	return levels
+}
@@ -0,0 +1,34 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// systemCertsTLSConfig returns a copy of conf (which may be nil) that verifies the server using the
+// system's trusted certificate authorities (if useSystem is set) together with any extra CA
+// certificates (PEM encoded). Any RootCAs already set in conf are replaced.
+func systemCertsTLSConfig(conf *tls.Config, useSystem bool, extraCAs [][]byte) *tls.Config {
+	if conf == nil {
+		conf = &tls.Config{}
+	} else {
+		conf = conf.Clone()
+	}
+	var pool *x509.CertPool
+	if useSystem {
+		var err error
+		if pool, err = x509.SystemCertPool(); err != nil {
+			WARN.Println(CLI, "unable to load system certificate pool:", err)
+		}
+	}
+	if pool == nil {
+		pool = x509.NewCertPool()
+	}
+	for _, pem := range extraCAs {
+		if !pool.AppendCertsFromPEM(pem) {
+			ERROR.Println(CLI, "no certificates found in CA certificate added with AddTLSCACert")
+		}
+	}
+	conf.RootCAs = pool
+	return conf
+}
@@ -0,0 +1,169 @@
+package mqtt
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tapQueueSize is the number of packets that can be waiting to be written to a Tap sink; packets
+// are dropped when the queue is full so that a slow sink does not delay the connection
+const tapQueueSize = 1024
+
+// Tap is a net.Conn that passes a copy of every MQTT packet read from, or written to, the wrapped
+// connection to a sink (see NewTap). Each packet is written to the sink as a record comprising:
+//   - direction (1 byte, PacketSent or PacketReceived)
+//   - timestamp (8 bytes, big endian nanoseconds since the Unix epoch)
+//   - length (4 bytes, big endian)
+//   - the packet (fixed header included)
+//
+// ReadTapRecord reads a record in this format.
+type Tap struct {
+	net.Conn
+	sink    io.Writer
+	records chan []byte
+	done    chan struct{}
+	once    sync.Once
+	dropped uint64
+
+	readMu  sync.Mutex
+	read    tapFramer
+	writeMu sync.Mutex
+	written tapFramer
+}
+
+// NewTap wraps conn so that all packets passing through it are copied to sink. The copies are
+// written by a separate goroutine; if the sink falls behind packets are dropped (see Dropped).
+// The result can be returned from an OpenConnectionFunc (see SetCustomOpenConnectionFn).
+func NewTap(conn net.Conn, sink io.Writer) net.Conn {
+	t := &Tap{
+		Conn:    conn,
+		sink:    sink,
+		records: make(chan []byte, tapQueueSize),
+		done:    make(chan struct{}),
+		read:    tapFramer{direction: PacketReceived},
+		written: tapFramer{direction: PacketSent},
+	}
+	go t.forward()
+	return t
+}
+
+// Read reads from the wrapped connection, copying any complete packets to the sink
+func (t *Tap) Read(b []byte) (int, error) {
+	n, err := t.Conn.Read(b)
+	if n > 0 {
+		t.readMu.Lock()
+		t.read.feed(b[:n], t.queue)
+		t.readMu.Unlock()
+	}
+	return n, err
+}
+
+// Write writes to the wrapped connection, copying any complete packets to the sink
+func (t *Tap) Write(b []byte) (int, error) {
+	n, err := t.Conn.Write(b)
+	if n > 0 {
+		t.writeMu.Lock()
+		t.written.feed(b[:n], t.queue)
+		t.writeMu.Unlock()
+	}
+	return n, err
+}
+
+// Close closes the wrapped connection and stops forwarding to the sink once queued packets
+// have been written
+func (t *Tap) Close() error {
+	t.once.Do(func() { close(t.done) })
+	return t.Conn.Close()
+}
+
+// Dropped returns the number of packets that were not passed to the sink because it fell behind
+func (t *Tap) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// queue passes a record to the forwarding goroutine without blocking
+func (t *Tap) queue(record []byte) {
+	select {
+	case t.records <- record:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// forward writes records to the sink until the Tap is closed (errors from the sink are ignored)
+func (t *Tap) forward() {
+	for {
+		select {
+		case r := <-t.records:
+			_, _ = t.sink.Write(r)
+		case <-t.done:
+			for {
+				select {
+				case r := <-t.records:
+					_, _ = t.sink.Write(r)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// tapFramer splits a stream of bytes in one direction into MQTT packets
+type tapFramer struct {
+	direction PacketDirection
+	buf       []byte
+}
+
+// feed adds b to the stream, passing a record for each packet completed to emit
+func (f *tapFramer) feed(b []byte, emit func([]byte)) {
+	f.buf = append(f.buf, b...)
+	for {
+		size, ok := packetSize(f.buf)
+		if !ok && len(f.buf) > 4 {
+			f.buf = nil // the remaining length is invalid so the stream cannot be followed
+			return
+		}
+		if !ok || len(f.buf) < size {
+			return
+		}
+		record := make([]byte, 13+size)
+		record[0] = byte(f.direction)
+		binary.BigEndian.PutUint64(record[1:9], uint64(time.Now().UnixNano()))
+		binary.BigEndian.PutUint32(record[9:13], uint32(size))
+		copy(record[13:], f.buf[:size])
+		emit(record)
+		f.buf = f.buf[size:]
+	}
+}
+
+// packetSize returns the total size of the packet at the start of b (false if the fixed header
+// is incomplete)
+func packetSize(b []byte) (int, bool) {
+	var remaining, multiplier = 0, 1
+	for i := 1; i < len(b) && i <= 4; i++ {
+		remaining += int(b[i]&127) * multiplier
+		if b[i]&128 == 0 {
+			return 1 + i + remaining, true
+		}
+		multiplier *= 128
+	}
+	return 0, false
+}
+
+// ReadTapRecord reads a single record, as written by a Tap, from r
+func ReadTapRecord(r io.Reader) (direction PacketDirection, timestamp time.Time, packet []byte, err error) {
+	var header [13]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	direction = PacketDirection(header[0])
+	timestamp = time.Unix(0, int64(binary.BigEndian.Uint64(header[1:9])))
+	packet = make([]byte, binary.BigEndian.Uint32(header[9:13]))
+	_, err = io.ReadFull(r, packet)
+	return
+}
@@ -13,6 +13,8 @@
 package mqtt
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -77,6 +79,11 @@ func (b *baseToken) WaitTimeout(d time.Duration) bool {
 	return false
 }
 
+// done returns a channel that is closed when the token completes
+func (b *baseToken) done() <-chan struct{} {
+	return b.complete
+}
+
 func (b *baseToken) flowComplete() {
 	select {
 	case <-b.complete:
@@ -98,6 +105,26 @@ func (b *baseToken) setError(e error) {
 	b.m.Unlock()
 }
 
+// waitTokenContext waits for t to complete or ctx to be done, returning true if t completed
+// first. Unlike calling t.Wait on another goroutine, nothing is left waiting once it returns.
+func waitTokenContext(ctx context.Context, t Token) bool {
+	if d, ok := t.(interface{ done() <-chan struct{} }); ok {
+		select {
+		case <-d.done():
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	// Tokens implemented outside this package have no channel to select on
+	for !t.WaitTimeout(10 * time.Millisecond) {
+		if ctx.Err() != nil {
+			return false
+		}
+	}
+	return true
+}
+
 func newToken(tType byte) tokenCompletor {
 	switch tType {
 	case packets.Connect:
@@ -156,6 +183,7 @@ func (p *PublishToken) MessageID() uint16 {
 type SubscribeToken struct {
 	baseToken
 	subs      []string
+	filters   []string // topic filters as sent to the broker (subs may have had $share/$queue removed)
 	subResult map[string]byte
 	messageID uint16
 }
@@ -169,6 +197,33 @@ func (s *SubscribeToken) Result() map[string]byte {
 	return s.subResult
 }
 
+//ErrSubscriptionRejected is the error set on a SubscribeToken when the broker rejects every
+//topic filter in the SUBSCRIBE (return code 0x80)
+var ErrSubscriptionRejected = errors.New("subscription rejected by broker")
+
+// SubscribeResult is the outcome of subscribing to a single topic filter
+type SubscribeResult struct {
+	GrantedQoS byte  // QoS granted by the broker (0x80 if rejected)
+	Error      error // ErrSubscriptionRejected if the broker rejected the filter
+}
+
+// Results returns the outcome for each topic that was subscribed to. Where some filters
+// are granted and others rejected the token error is nil, so Results must be checked to
+// detect a partial failure.
+func (s *SubscribeToken) Results() map[string]SubscribeResult {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	res := make(map[string]SubscribeResult, len(s.subResult))
+	for topic, code := range s.subResult {
+		r := SubscribeResult{GrantedQoS: code}
+		if code == 0x80 {
+			r.Error = ErrSubscriptionRejected
+		}
+		res[topic] = r
+	}
+	return res
+}
+
 // UnsubscribeToken is an extension of Token containing the extra fields
 // required to provide information about calls to Unsubscribe()
 type UnsubscribeToken struct {
@@ -0,0 +1,42 @@
+package tools
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// ReplayCapture acts as the broker end of conn, replaying a capture made with
+// Diagnostics.StartPacketCapture to a new client. Packets that the original client received are
+// written to conn; for packets that it sent a packet is read from conn and its type compared
+// (other fields, such as message ids, may legitimately differ between runs). The capture must
+// start with the CONNECT (i.e. capture started before Connect was called) and, as PINGREQ
+// timing is not deterministic, keepalive should be disabled when capturing and replaying.
+// An error is returned if the client deviates from the capture or the connection fails.
+func ReplayCapture(conn io.ReadWriter, capture []mqtt.CapturedPacket) error {
+	for i, cp := range capture {
+		switch cp.Direction {
+		case mqtt.PacketReceived:
+			if err := cp.Packet.Write(conn); err != nil {
+				return fmt.Errorf("packet %d: error writing %s: %w", i, packetName(cp.Packet), err)
+			}
+		case mqtt.PacketSent:
+			p, err := packets.ReadPacket(conn)
+			if err != nil {
+				return fmt.Errorf("packet %d: error reading %s: %w", i, packetName(cp.Packet), err)
+			}
+			if packetName(p) != packetName(cp.Packet) {
+				return fmt.Errorf("packet %d: expected %s, client sent %s", i, packetName(cp.Packet), packetName(p))
+			}
+		}
+	}
+	return nil
+}
+
+// packetName returns the name of the packets type (e.g. PublishPacket)
+func packetName(p packets.ControlPacket) string {
+	return reflect.TypeOf(p).Elem().Name()
+}
@@ -0,0 +1,158 @@
+// Package tools provides utilities that help when developing and testing applications that use
+// the mqtt package. They are not intended for use in production code.
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// WillTester validates a will configuration against a real broker. It connects the client
+// described by the options, drops the network connection without sending a DISCONNECT (as
+// would happen if the device lost power) and checks that the will message is published.
+type WillTester struct {
+	// Options are the options of the client whose will is being tested (they are copied
+	// before use). WillEnabled must be true.
+	Options *mqtt.ClientOptions
+	// ObserverClientID is the client id used by the client that subscribes to the will topic.
+	// Defaults to the tested client's id with "-willobserver" appended.
+	ObserverClientID string
+	// Timeout is how long to wait for the will message after the connection is dropped.
+	Timeout time.Duration
+}
+
+// WillResult holds the outcome of a WillTester run
+type WillResult struct {
+	Received bool          // true if the will message was received within the timeout
+	Topic    string        // topic the will was received on
+	Payload  []byte        // payload of the will
+	Qos      byte          // QoS the will was delivered at
+	Retained bool          // true if the broker retained the will (checked with a second subscription)
+	Delay    time.Duration // time between the connection being dropped and the will being received
+}
+
+// NewWillTester returns a WillTester for the client configured by opts with a default timeout
+// of 10 seconds
+func NewWillTester(opts *mqtt.ClientOptions) *WillTester {
+	return &WillTester{
+		Options: opts,
+		Timeout: 10 * time.Second,
+	}
+}
+
+// Run performs the test. An error is returned if the test could not be carried out (e.g. the
+// broker is unreachable); a will that is not received is reported via WillResult.Received.
+func (w *WillTester) Run() (*WillResult, error) {
+	if w.Options == nil || !w.Options.WillEnabled {
+		return nil, errors.New("will is not enabled in the options being tested")
+	}
+	opts := *w.Options
+	observerID := w.ObserverClientID
+	if observerID == "" {
+		observerID = opts.ClientID + "-willobserver"
+	}
+
+	wills := make(chan mqtt.Message, 1)
+	oOpts := mqtt.NewClientOptions().SetClientID(observerID).SetTLSConfig(opts.TLSConfig).
+		SetAutoReconnect(false)
+	oOpts.Servers = opts.Servers
+	oOpts.Username, oOpts.Password = opts.Username, opts.Password
+	oOpts.CustomOpenConnectionFn = opts.CustomOpenConnectionFn
+	observer := mqtt.NewClient(oOpts)
+	if t := observer.Connect(); t.Wait() && t.Error() != nil {
+		return nil, fmt.Errorf("observer failed to connect: %w", t.Error())
+	}
+	defer observer.Disconnect(250)
+
+	if t := observer.Subscribe(opts.WillTopic, 2, func(c mqtt.Client, m mqtt.Message) {
+		select {
+		case wills <- m:
+		default:
+		}
+	}); t.Wait() && t.Error() != nil {
+		return nil, fmt.Errorf("observer failed to subscribe to will topic: %w", t.Error())
+	}
+
+	dropper := &connDropper{open: opts.CustomOpenConnectionFn}
+	opts.CustomOpenConnectionFn = dropper.openConnection
+	opts.AutoReconnect = false
+	opts.ConnectRetry = false
+	opts.OnConnectionLost = nil
+	tested := mqtt.NewClient(&opts)
+	if t := tested.Connect(); t.Wait() && t.Error() != nil {
+		return nil, fmt.Errorf("client being tested failed to connect: %w", t.Error())
+	}
+	defer tested.Disconnect(0)
+
+	dropped := time.Now()
+	dropper.drop()
+
+	res := &WillResult{}
+	select {
+	case m := <-wills:
+		res.Received = true
+		res.Delay = time.Since(dropped)
+		res.Topic = m.Topic()
+		res.Payload = m.Payload()
+		res.Qos = m.Qos()
+	case <-time.After(w.Timeout):
+		return res, nil
+	}
+
+	// Messages forwarded to existing subscriptions never have the retain flag set so a second
+	// subscription is needed to find out if the broker retained the will
+	retained := make(chan bool, 1)
+	if t := observer.Subscribe(opts.WillTopic, 2, func(c mqtt.Client, m mqtt.Message) {
+		select {
+		case retained <- m.Retained():
+		default:
+		}
+	}); t.Wait() && t.Error() == nil {
+		select {
+		case res.Retained = <-retained:
+		case <-time.After(time.Second):
+		}
+	}
+	return res, nil
+}
+
+// connDropper opens connections (using the users OpenConnectionFunc if provided, otherwise the
+// client's default dialer) and retains a reference so that the connection can be closed without
+// the client being involved
+type connDropper struct {
+	open mqtt.OpenConnectionFunc
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (d *connDropper) openConnection(uri *url.URL, options mqtt.ClientOptions) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if d.open != nil {
+		conn, err = d.open(uri, options)
+	} else {
+		conn, err = mqtt.DefaultOpenConnection(uri, options)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.conn = conn
+	d.mu.Unlock()
+	return conn, nil
+}
+
+// drop closes the network connection (no DISCONNECT packet is sent so the broker will publish the will)
+func (d *connDropper) drop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn != nil {
+		d.conn.Close()
+	}
+}
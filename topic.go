@@ -17,6 +17,7 @@ package mqtt
 import (
 	"errors"
 	"strings"
+	"unicode/utf8"
 )
 
 //ErrInvalidQos is the error returned when an packet is to be sent
@@ -27,11 +28,27 @@ var ErrInvalidQos = errors.New("invalid QoS")
 //is passed in that is 0 length
 var ErrInvalidTopicEmptyString = errors.New("invalid Topic; empty string")
 
+//ErrTopicForbidden is the error returned when a publish or subscribe is rejected by
+//the TopicACL set in the ClientOptions
+var ErrTopicForbidden = errors.New("topic forbidden by ACL")
+
 //ErrInvalidTopicMultilevel is the error returned when a topic string
 //is passed in that has the multi level wildcard in any position but
 //the last
 var ErrInvalidTopicMultilevel = errors.New("invalid Topic; multi-level wildcard must be last level")
 
+//ErrInvalidTopicWildcard is the error returned when a topic name (to
+//publish to) contains a wildcard character
+var ErrInvalidTopicWildcard = errors.New("invalid Topic; topic name must not contain wildcards")
+
+//ErrInvalidTopicLength is the error returned when a topic string is
+//longer than 65535 bytes
+var ErrInvalidTopicLength = errors.New("invalid Topic; longer than 65535 bytes")
+
+//ErrInvalidTopicEncoding is the error returned when a topic string is
+//not valid UTF-8 or contains the null character
+var ErrInvalidTopicEncoding = errors.New("invalid Topic; must be UTF-8 without null characters")
+
 // Topic Names and Topic Filters
 // The MQTT v3.1.1 spec clarifies a number of ambiguities with regard
 // to the validity of Topic strings.
@@ -49,19 +66,26 @@ var ErrInvalidTopicMultilevel = errors.New("invalid Topic; multi-level wildcard
 // - A TopicFilter with a # will match the absence of a level
 //     Example:  a subscription to "foo/#" will match messages published to "foo".
 
-func validateSubscribeMap(subs map[string]byte) ([]string, []byte, error) {
-	if len(subs) == 0 {
+// SubscriptionFilter describes one topic filter in a call to SubscribeFilters
+type SubscriptionFilter struct {
+	Filter  string         // the topic filter (may include wildcards and a $share prefix)
+	QoS     byte           // the maximum QoS at which messages will be received
+	Handler MessageHandler // called for messages matching Filter (nil for the default handler)
+}
+
+func validateSubscriptionFilters(filters []SubscriptionFilter) ([]string, []byte, error) {
+	if len(filters) == 0 {
 		return nil, nil, errors.New("invalid subscription; subscribe map must not be empty")
 	}
 
-	var topics []string
-	var qoss []byte
-	for topic, qos := range subs {
-		if err := validateTopicAndQos(topic, qos); err != nil {
+	topics := make([]string, 0, len(filters))
+	qoss := make([]byte, 0, len(filters))
+	for _, f := range filters {
+		if err := validateTopicAndQos(f.Filter, f.QoS); err != nil {
 			return nil, nil, err
 		}
-		topics = append(topics, topic)
-		qoss = append(qoss, qos)
+		topics = append(topics, f.Filter)
+		qoss = append(qoss, f.QoS)
 	}
 
 	return topics, qoss, nil
@@ -84,3 +108,19 @@ func validateTopicAndQos(topic string, qos byte) error {
 	}
 	return nil
 }
+
+// ValidateTopic checks that topic is a valid topic name to publish to; that is it is between
+// 1 and 65535 bytes of UTF-8 (without null characters) and does not contain a wildcard
+func ValidateTopic(topic string) error {
+	switch {
+	case len(topic) == 0:
+		return ErrInvalidTopicEmptyString
+	case len(topic) > 65535:
+		return ErrInvalidTopicLength
+	case !utf8.ValidString(topic) || strings.ContainsRune(topic, 0):
+		return ErrInvalidTopicEncoding
+	case strings.ContainsAny(topic, "+#"):
+		return ErrInvalidTopicWildcard
+	}
+	return nil
+}
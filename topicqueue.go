@@ -0,0 +1,130 @@
+package mqtt
+
+import (
+	"sync"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// topicQueueSize is the number of publishes that can be waiting on a single topic (see
+// SetPerTopicOrdering) before Publish blocks
+const topicQueueSize = 100
+
+// publishRequest is a publish waiting in a topic queue
+type publishRequest struct {
+	pub   *packets.PublishPacket
+	token *PublishToken
+}
+
+// topicQueue holds the publishes waiting to be sent to a single topic
+type topicQueue struct {
+	reqs    chan publishRequest
+	pending int // publishes queued (or about to be) that the worker has not yet taken
+	qos0    int // the number of those without a message id
+}
+
+// topicQueues holds a queue, serviced by its own goroutine, for each topic with publishes waiting.
+// Queues are removed when they become empty so topics that are published to once do not each
+// leave a goroutine behind.
+type topicQueues struct {
+	mu     sync.Mutex
+	queues map[string]*topicQueue
+	stop   chan struct{} // closed by stopAll; workers started afterwards get a new channel
+}
+
+// pendingQoS0 returns the number of QoS 0 publishes waiting in all topic queues (publishes with a
+// message id are already counted by messageIds)
+func (t *topicQueues) pendingQoS0() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := 0
+	for _, q := range t.queues {
+		n += q.qos0
+	}
+	return n
+}
+
+// stopAll stops the workers waiting for publishes to complete; anything still queued fails with
+// ErrNotConnected. Publishes made afterwards go to new queues.
+func (t *topicQueues) stopAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stop != nil {
+		close(t.stop)
+		t.stop = nil
+	}
+	t.queues = nil
+}
+
+// queuePublish adds the publish to the queue for its topic, starting the goroutine that services
+// the queue if there is not one already
+func (c *client) queuePublish(pub *packets.PublishPacket, token *PublishToken) {
+	t := &c.topicQueues
+	t.mu.Lock()
+	q, ok := t.queues[pub.TopicName]
+	if !ok {
+		if t.queues == nil {
+			t.queues = make(map[string]*topicQueue)
+		}
+		if t.stop == nil {
+			t.stop = make(chan struct{})
+		}
+		q = &topicQueue{reqs: make(chan publishRequest, topicQueueSize)}
+		t.queues[pub.TopicName] = q
+		go c.serviceTopicQueue(pub.TopicName, q, t.stop)
+	}
+	q.pending++ // before sending so that the worker does not exit while we wait for space
+	if pub.MessageID == 0 {
+		q.qos0++
+	}
+	t.mu.Unlock()
+	q.reqs <- publishRequest{pub: pub, token: token}
+}
+
+// serviceTopicQueue sends each publish in the queue, waiting for it to complete (be acknowledged,
+// or written in the case of QoS 0) before sending the next. It returns, removing the queue, once
+// the queue is empty.
+func (c *client) serviceTopicQueue(topic string, q *topicQueue, stop <-chan struct{}) {
+	t := &c.topicQueues
+	for req := range q.reqs {
+		t.mu.Lock()
+		q.pending--
+		if req.pub.MessageID == 0 {
+			q.qos0--
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-stop:
+			c.failQueuedPublish(req)
+		default:
+			if c.connectionStatus() == disconnected {
+				c.failQueuedPublish(req)
+				break
+			}
+			c.sendPublish(req.pub, req.token)
+			select {
+			case <-req.token.done():
+			case <-stop: // the token is completed when the message ids are cleaned up
+			}
+		}
+
+		t.mu.Lock()
+		if q.pending == 0 {
+			if t.queues[topic] == q {
+				delete(t.queues, topic)
+			}
+			t.mu.Unlock()
+			return
+		}
+		t.mu.Unlock()
+	}
+}
+
+// failQueuedPublish fails a publish that was not sent because the client is disconnected
+func (c *client) failQueuedPublish(req publishRequest) {
+	if req.pub.MessageID != 0 {
+		c.freeID(req.pub.MessageID)
+	}
+	req.token.setError(ErrNotConnected)
+}
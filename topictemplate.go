@@ -0,0 +1,60 @@
+package mqtt
+
+import (
+	"strings"
+	"text/template"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// TopicTemplate builds topic names from a text/template, for example "devices/{{.DeviceID}}/telemetry".
+// A TopicTemplate may be used concurrently.
+type TopicTemplate struct {
+	tmpl *template.Template
+}
+
+// NewTopicTemplate parses text as a topic template. Referencing a missing map key is an error
+// rather than rendering "<no value>".
+func NewTopicTemplate(text string) (*TopicTemplate, error) {
+	tmpl, err := template.New("topic").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &TopicTemplate{tmpl: tmpl}, nil
+}
+
+// MustTopicTemplate is as NewTopicTemplate but panics if text cannot be parsed. It is intended
+// for templates held in package level variables.
+func MustTopicTemplate(text string) *TopicTemplate {
+	return &TopicTemplate{tmpl: template.Must(template.New("topic").Option("missingkey=error").Parse(text))}
+}
+
+// WrapTopicTemplate returns a TopicTemplate that renders tmpl
+func WrapTopicTemplate(tmpl *template.Template) *TopicTemplate {
+	return &TopicTemplate{tmpl: tmpl}
+}
+
+// Render executes the template with data and returns the topic, which is checked with ValidateTopic
+func (t *TopicTemplate) Render(data interface{}) (string, error) {
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	topic := b.String()
+	if err := ValidateTopic(topic); err != nil {
+		return "", err
+	}
+	return topic, nil
+}
+
+// PublishTemplate renders tmpl with data and publishes the message to the resulting topic. If
+// the template cannot be rendered the error is returned through the token.
+func (c *client) PublishTemplate(tmpl *TopicTemplate, data interface{}, qos byte, retained bool, payload interface{}) Token {
+	topic, err := tmpl.Render(data)
+	if err != nil {
+		token := newToken(packets.Publish).(*PublishToken)
+		token.setError(err)
+		return token
+	}
+	return c.Publish(topic, qos, retained, payload)
+}
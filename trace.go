@@ -37,4 +37,6 @@ var (
 	CRITICAL Logger = NOOPLogger{}
 	WARN     Logger = NOOPLogger{}
 	DEBUG    Logger = NOOPLogger{}
+	// PACKET receives the packets logged by clients with a PacketLogLevel set
+	PACKET Logger = NOOPLogger{}
 )
@@ -0,0 +1,216 @@
+// Package awsiot provides a dialer for connecting to AWS IoT Core using MQTT over WebSockets with the
+// connection request authenticated by an AWS Signature Version 4 (SigV4) presigned URL.
+//
+// The broker added to the client options should be the account's data endpoint, for example
+// "wss://abcdefgh123456-ats.iot.us-east-1.amazonaws.com:443/mqtt"; the dialer always connects using TLS
+// to the "/mqtt" path of that host. The URL is signed every time a connection is opened so reconnections
+// use current credentials.
+//
+// The signature is calculated using only the standard library so the AWS SDK is not required. To use
+// credentials from the SDK wrap its provider in a CredentialsProvider, for example:
+//
+//	type sdkCredentials struct{ p aws.CredentialsProvider }
+//
+//	func (s sdkCredentials) Retrieve(ctx context.Context) (awsiot.Credentials, error) {
+//		c, err := s.p.Retrieve(ctx)
+//		return awsiot.Credentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey,
+//			SessionToken: c.SessionToken, CanExpire: c.CanExpire, Expires: c.Expires}, err
+//	}
+package awsiot
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	service   = "iotdevicegateway"
+	algorithm = "AWS4-HMAC-SHA256"
+	// emptyPayloadHash is the hex encoded SHA-256 hash of an empty request body
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// expiryWindow is how long before credentials expire that they are refreshed
+	expiryWindow = 5 * time.Minute
+)
+
+// DialFunc opens the network connection for a client; it may be passed to
+// ClientOptions.SetCustomOpenConnectionFn
+type DialFunc = mqtt.OpenConnectionFunc
+
+// Credentials are the AWS credentials used to sign the connection request. The fields match those of
+// aws.Credentials in the AWS SDK for Go v2.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // Optional, required for temporary credentials
+	CanExpire       bool
+	Expires         time.Time
+}
+
+// CredentialsProvider supplies credentials. Retrieve is called whenever a connection is opened and the
+// credentials previously retrieved have expired, or will expire within five minutes.
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// StaticCredentials is a CredentialsProvider that always returns the same credentials
+type StaticCredentials Credentials
+
+// Retrieve implements CredentialsProvider
+func (s StaticCredentials) Retrieve(context.Context) (Credentials, error) {
+	return Credentials(s), nil
+}
+
+// NewAWSIoTDialer returns a DialFunc that connects to AWS IoT Core in region using the provided
+// credentials (sessionToken may be empty if the credentials are not temporary)
+func NewAWSIoTDialer(region, accessKey, secretKey, sessionToken string) DialFunc {
+	return NewAWSIoTDialerWithProvider(region, StaticCredentials{
+		AccessKeyID:     accessKey,
+		SecretAccessKey: secretKey,
+		SessionToken:    sessionToken,
+	})
+}
+
+// NewAWSIoTDialerWithProvider returns a DialFunc that connects to AWS IoT Core in region using credentials
+// obtained from provider. Credentials are cached and only retrieved again when they are due to expire.
+func NewAWSIoTDialerWithProvider(region string, provider CredentialsProvider) DialFunc {
+	cache := &credentialsCache{provider: provider}
+	return func(uri *url.URL, options mqtt.ClientOptions) (net.Conn, error) {
+		ctx := context.Background()
+		if options.ConnectTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, options.ConnectTimeout)
+			defer cancel()
+		}
+		creds, err := cache.retrieve(ctx)
+		if err != nil {
+			return nil, err
+		}
+		signed, err := presignURL(uri.Host, region, creds, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return mqtt.NewWebsocket(signed, options.TLSConfig, options.ConnectTimeout, options.HTTPHeaders)
+	}
+}
+
+// credentialsCache holds the most recently retrieved credentials
+type credentialsCache struct {
+	provider CredentialsProvider
+
+	mu    sync.Mutex
+	creds *Credentials
+}
+
+func (c *credentialsCache) retrieve(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.creds != nil && (!c.creds.CanExpire || time.Now().Add(expiryWindow).Before(c.creds.Expires)) {
+		return *c.creds, nil
+	}
+	creds, err := c.provider.Retrieve(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return Credentials{}, errors.New("awsiot: access key and secret key are required")
+	}
+	c.creds = &creds
+	return creds, nil
+}
+
+// presignURL returns the wss URL for the AWS IoT endpoint at host with a SigV4 signature in the query.
+// AWS IoT requires the session token to be added after the signature is calculated.
+func presignURL(host, region string, creds Credentials, now time.Time) (string, error) {
+	if host == "" {
+		return "", errors.New("awsiot: broker host is required")
+	}
+	query := presign("GET", host, "/mqtt", service, region, creds.AccessKeyID, creds.SecretAccessKey, emptyPayloadHash, nil, now)
+	if creds.SessionToken != "" {
+		query += "&X-Amz-Security-Token=" + escape(creds.SessionToken)
+	}
+	return "wss://" + host + "/mqtt?" + query, nil
+}
+
+// presign calculates a SigV4 signature for a request that is authenticated using query parameters
+// (signing only the host header) and returns the canonical query string including the signature
+func presign(method, host, path, service, region, accessKey, secretKey, payloadHash string, extra map[string]string, now time.Time) string {
+	now = now.UTC()
+	date := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+
+	params := map[string]string{
+		"X-Amz-Algorithm":     algorithm,
+		"X-Amz-Credential":    accessKey + "/" + scope,
+		"X-Amz-Date":          amzDate,
+		"X-Amz-SignedHeaders": "host",
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	query := canonicalQuery(params)
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		query,
+		"host:" + host + "\n",
+		"host",
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		algorithm,
+		amzDate,
+		scope,
+		hexHash(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	return query + "&X-Amz-Signature=" + signature
+}
+
+// canonicalQuery encodes params sorted by name as required by SigV4
+func canonicalQuery(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = escape(k) + "=" + escape(params[k])
+	}
+	return strings.Join(parts, "&")
+}
+
+// escape percent encodes everything other than the RFC 3986 unreserved characters
+func escape(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+func hexHash(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,228 @@
+//go:build mqtt_http2
+// +build mqtt_http2
+
+// Package http2 provides an experimental transport that carries MQTT over a single HTTP/2 stream. This
+// allows clients to connect through proxies and firewalls that only permit HTTP traffic.
+//
+// The client opens a POST request and streams the MQTT bytes it sends as the request body; the bytes
+// sent by the broker are returned as the response body. HTTP/2 frames the body in DATA frames so no
+// Transfer-Encoding header is needed (chunked encoding does not exist in HTTP/2). Plain text (h2c, with
+// prior knowledge) and TLS connections are supported.
+//
+// The broker end is provided by Handler, an http.Handler which passes each stream on as a net.Conn (for
+// example to ProxyTo which relays it to a standard MQTT listener). For h2c the handler must be wrapped
+// with golang.org/x/net/http2/h2c.NewHandler.
+//
+// The package is only built with the mqtt_http2 build tag as the transport is experimental. Deadlines
+// are not supported by the connections it creates (they are ignored) so options such as WriteTimeout
+// and ReadStallTimeout have no effect.
+package http2
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	xhttp2 "golang.org/x/net/http2"
+)
+
+// DialFunc opens the network connection for a client; it may be passed to
+// ClientOptions.SetCustomOpenConnectionFn
+type DialFunc = mqtt.OpenConnectionFunc
+
+// NewHTTP2Dialer returns a DialFunc that connects to the HTTP/2 endpoint at rawURL (rather than the broker
+// URL in the client options, which is ignored). An "http" URL uses h2c (HTTP/2 without TLS, the server
+// must support prior knowledge) and an "https" URL uses TLS with tlsConf (which may be nil).
+func NewHTTP2Dialer(rawURL string, tlsConf *tls.Config) DialFunc {
+	return func(_ *url.URL, options mqtt.ClientOptions) (net.Conn, error) {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		var t *xhttp2.Transport
+		switch u.Scheme {
+		case "http":
+			t = &xhttp2.Transport{
+				AllowHTTP: true,
+				DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+					return net.DialTimeout(network, addr, options.ConnectTimeout)
+				},
+			}
+		case "https":
+			t = &xhttp2.Transport{TLSClientConfig: tlsConf}
+		default:
+			return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+		}
+		return dial(t, u, options.HTTPHeaders, options.ConnectTimeout)
+	}
+}
+
+// dial opens the stream and waits for the response headers (which the handler sends immediately)
+func dial(t *xhttp2.Transport, u *url.URL, headers http.Header, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, u.String(), pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, cancel)
+	}
+	resp, err := t.RoundTrip(req)
+	if timer != nil && !timer.Stop() {
+		err = errors.New("timeout waiting for HTTP/2 response")
+	}
+	if err != nil {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		cancel()
+		pw.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		pw.Close()
+		return nil, fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+	return &conn{
+		r:      resp.Body,
+		w:      pw,
+		local:  addr(""),
+		remote: addr(u.Host),
+		close: func() {
+			pw.Close()
+			resp.Body.Close()
+			cancel()
+			t.CloseIdleConnections()
+		},
+	}, nil
+}
+
+// Handler is an http.Handler that accepts streams opened by NewHTTP2Dialer. Each stream is passed to
+// Serve as a net.Conn; the stream remains open until Serve returns.
+type Handler struct {
+	Serve func(net.Conn)
+}
+
+// ServeHTTP implements http.Handler
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "HTTP/2 required", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	f.Flush() // The client waits for the headers before it can start sending
+
+	done := make(chan struct{})
+	var once sync.Once
+	h.Serve(&conn{
+		r:      r.Body,
+		w:      &flushWriter{w: w, f: f, done: done},
+		local:  addr(r.Host),
+		remote: addr(r.RemoteAddr),
+		close:  func() { once.Do(func() { close(done) }) },
+	})
+}
+
+// ProxyTo returns a function, suitable for use as Handler.Serve, that relays each stream to the MQTT
+// broker listening on the TCP address brokerAddr
+func ProxyTo(brokerAddr string) func(net.Conn) {
+	return func(c net.Conn) {
+		defer c.Close()
+		b, err := net.Dial("tcp", brokerAddr)
+		if err != nil {
+			return
+		}
+		defer b.Close()
+		go func() {
+			io.Copy(b, c)
+			b.Close()
+		}()
+		io.Copy(c, b)
+	}
+}
+
+// flushWriter writes to the response, flushing after each write so that packets are not delayed
+type flushWriter struct {
+	w    io.Writer
+	f    http.Flusher
+	done chan struct{}
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	select {
+	case <-fw.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	n, err := fw.w.Write(p)
+	if err == nil {
+		fw.f.Flush()
+	}
+	return n, err
+}
+
+// conn presents one end of a stream as a net.Conn
+type conn struct {
+	r      io.ReadCloser
+	w      io.Writer
+	local  net.Addr
+	remote net.Addr
+
+	closeOnce sync.Once
+	close     func()
+}
+
+func (c *conn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c *conn) Write(p []byte) (int, error) { return c.w.Write(p) }
+func (c *conn) LocalAddr() net.Addr         { return c.local }
+func (c *conn) RemoteAddr() net.Addr        { return c.remote }
+
+// Close closes the stream
+func (c *conn) Close() error {
+	c.closeOnce.Do(c.close)
+	return nil
+}
+
+// SetDeadline is not supported (the deadline is ignored)
+func (c *conn) SetDeadline(t time.Time) error { return nil }
+
+// SetReadDeadline is not supported (the deadline is ignored)
+func (c *conn) SetReadDeadline(t time.Time) error { return nil }
+
+// SetWriteDeadline is not supported (the deadline is ignored)
+func (c *conn) SetWriteDeadline(t time.Time) error { return nil }
+
+// addr is a net.Addr for the endpoints of a stream
+type addr string
+
+func (a addr) Network() string { return "http2" }
+func (a addr) String() string  { return string(a) }
@@ -0,0 +1,44 @@
+//go:build mqtt_http2
+// +build mqtt_http2
+
+package http2
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestHTTP2Dialer(t *testing.T) {
+	srv := httptest.NewUnstartedServer(&Handler{Serve: func(c net.Conn) {
+		defer c.Close()
+		io.Copy(c, c) // echo
+	}})
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	dial := NewHTTP2Dialer(srv.URL, &tls.Config{InsecureSkipVerify: true})
+	c, err := dial(nil, *mqtt.NewClientOptions())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer c.Close()
+
+	for _, msg := range []string{"hello", "world"} {
+		if _, err := c.Write([]byte(msg)); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		b := make([]byte, len(msg))
+		if _, err := io.ReadFull(c, b); err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		if string(b) != msg {
+			t.Fatalf("expected %q, got %q", msg, b)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package mqtt
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_Bridge(t *testing.T) {
+	b := NewBridge()
+	b.SetDeduplication(100, time.Minute)
+	var brokers []net.Conn
+	for i := 0; i < 2; i++ {
+		c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+		defer broker.Close()
+		defer c.forceDisconnect()
+		b.AddSource(c, []string{"sensors/#"})
+		ackSubscribe(t, broker)
+		brokers = append(brokers, broker)
+	}
+	sink, sinkBroker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer sinkBroker.Close()
+	defer sink.forceDisconnect()
+	b.SetSink(sink, func(msg Message) string { return "bridged/" + msg.Topic() })
+
+	// The same reading arrives from both sources followed by a different reading
+	for i, payload := range []string{"20", "20", "21"} {
+		pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		pub.TopicName = "sensors/temp"
+		pub.Payload = []byte(payload)
+		if err := pub.Write(brokers[i%2]); err != nil {
+			t.Fatalf("error writing publish: %v", err)
+		}
+	}
+
+	for _, expected := range []string{"20", "21"} {
+		sinkBroker.SetReadDeadline(time.Now().Add(5 * time.Second))
+		cp, err := packets.ReadPacket(sinkBroker)
+		if err != nil {
+			t.Fatalf("error reading republished message: %v", err)
+		}
+		pub := cp.(*packets.PublishPacket)
+		if pub.TopicName != "bridged/sensors/temp" || string(pub.Payload) != expected {
+			t.Fatalf("unexpected message %q on %q", pub.Payload, pub.TopicName)
+		}
+	}
+	if d := b.Duplicates(); d != 1 {
+		t.Fatalf("expected 1 duplicate, got %d", d)
+	}
+}
+
+func Test_Bridge_acknowledgesAfterSink(t *testing.T) {
+	b := NewBridge() // no deduplication
+	src, srcBroker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer srcBroker.Close()
+	defer src.forceDisconnect()
+	b.AddSource(src, []string{"sensors/#"})
+	ackSubscribe(t, srcBroker)
+	sink, sinkBroker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer sinkBroker.Close()
+	defer sink.forceDisconnect()
+	b.SetSink(sink, nil)
+
+	// Identical readings are both republished when deduplication is not enabled
+	for i := uint16(1); i <= 2; i++ {
+		pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		pub.TopicName = "sensors/temp"
+		pub.Qos = 1
+		pub.MessageID = i
+		pub.Payload = []byte("20")
+		if err := pub.Write(srcBroker); err != nil {
+			t.Fatalf("error writing publish: %v", err)
+		}
+
+		sinkBroker.SetReadDeadline(time.Now().Add(5 * time.Second))
+		cp, err := packets.ReadPacket(sinkBroker)
+		if err != nil {
+			t.Fatalf("error reading republished message: %v", err)
+		}
+		out := cp.(*packets.PublishPacket)
+		if out.TopicName != "sensors/temp" || string(out.Payload) != "20" {
+			t.Fatalf("unexpected message %q on %q", out.Payload, out.TopicName)
+		}
+
+		// The source is not acknowledged until the sink has acknowledged the publish
+		srcBroker.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if cp, err := packets.ReadPacket(srcBroker); err == nil {
+			t.Fatalf("source acknowledged before the sink: %s", cp.String())
+		}
+		ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+		ack.MessageID = out.MessageID
+		if err := ack.Write(sinkBroker); err != nil {
+			t.Fatalf("error writing puback: %v", err)
+		}
+		srcBroker.SetReadDeadline(time.Now().Add(5 * time.Second))
+		cp, err = packets.ReadPacket(srcBroker)
+		if err != nil {
+			t.Fatalf("error reading puback: %v", err)
+		}
+		if pa, ok := cp.(*packets.PubackPacket); !ok || pa.MessageID != i {
+			t.Fatalf("expected puback %d, got %s", i, cp.String())
+		}
+	}
+	if d := b.Duplicates(); d != 0 {
+		t.Fatalf("expected no duplicates, got %d", d)
+	}
+}
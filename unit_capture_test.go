@@ -0,0 +1,68 @@
+package mqtt
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_PacketCapture(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	// net.Pipe is synchronous so the client may not have recorded the SUBSCRIBE as sent by the time
+	// the broker has read it; hold back the SUBACK until it has (with a real network connection the
+	// round trip ensures this). The capture subscribes first so records the packet before sent is closed.
+	capture := c.StartPacketCapture()
+	sent := make(chan struct{})
+	unsubscribe := c.events.subscribe(func(e Event) {
+		if ev, ok := e.(PacketSentEvent); ok {
+			if _, ok := ev.Packet.(*packets.SubscribePacket); ok {
+				close(sent)
+			}
+		}
+	})
+	defer unsubscribe()
+	token := c.Subscribe("test/capture", 1, nil)
+	ackSubscribe(t, gatedConn{Conn: broker, gate: sent}, 1)
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("subscribe did not complete")
+	}
+	captured := capture.Stop()
+
+	// Packets after Stop must not be recorded
+	c.Publish("test/capture", 0, false, "ignored")
+	if _, err := packets.ReadPacket(broker); err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+
+	if len(captured) != 2 {
+		t.Fatalf("expected 2 packets to be captured, got %d", len(captured))
+	}
+	if _, ok := captured[0].Packet.(*packets.SubscribePacket); !ok || captured[0].Direction != PacketSent {
+		t.Fatalf("expected SUBSCRIBE to be sent first, got %s %s", captured[0].Direction, captured[0].Packet)
+	}
+	if _, ok := captured[1].Packet.(*packets.SubackPacket); !ok || captured[1].Direction != PacketReceived {
+		t.Fatalf("expected SUBACK to be received, got %s %s", captured[1].Direction, captured[1].Packet)
+	}
+	if captured[1].Timestamp.Before(captured[0].Timestamp) {
+		t.Fatalf("packets not in order")
+	}
+	if len(capture.Stop()) != 2 {
+		t.Fatalf("second call to Stop returned different packets")
+	}
+}
+
+// gatedConn is a net.Conn whose writes wait until gate is closed
+type gatedConn struct {
+	net.Conn
+	gate <-chan struct{}
+}
+
+func (g gatedConn) Write(b []byte) (int, error) {
+	<-g.gate
+	return g.Conn.Write(b)
+}
@@ -15,12 +15,24 @@
 package mqtt
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	_ "net/http/pprof"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
 )
 
 func init() {
@@ -105,3 +117,1068 @@ func Test_isConnectionOpenNegative(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// newPipeClient returns a client with its comms workers running over one end of a net.Pipe, the
+// other end is returned so that the test can act as the broker (the CONNECT handshake is skipped)
+func newPipeClient(o *ClientOptions) (*client, net.Conn) {
+	c := NewClient(o).(*client)
+	c.persist.Open()
+	conn, broker := net.Pipe()
+	inboundFromStore := make(chan packets.ControlPacket)
+	c.startCommsWorkers(conn, false, inboundFromStore)
+	close(inboundFromStore)
+	return c, broker
+}
+
+// ackSubscribe reads a SUBSCRIBE from broker and acknowledges it with codes (or, if none are given,
+// grants the QoS requested for each topic). The SUBSCRIBE is returned.
+func ackSubscribe(t *testing.T, broker net.Conn, codes ...byte) *packets.SubscribePacket {
+	t.Helper()
+	return ackSubscribeWith(t, broker, func(sub *packets.SubscribePacket) []byte {
+		if len(codes) == 0 {
+			return sub.Qoss
+		}
+		return codes
+	})
+}
+
+// ackSubscribeWith is ackSubscribe for tests where the return codes depend on the SUBSCRIBE
+func ackSubscribeWith(t *testing.T, broker net.Conn, codes func(*packets.SubscribePacket) []byte) *packets.SubscribePacket {
+	t.Helper()
+	cp, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading subscribe: %v", err)
+	}
+	sub, ok := cp.(*packets.SubscribePacket)
+	if !ok {
+		t.Fatalf("expected SUBSCRIBE, got %s", cp.String())
+	}
+	sa := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+	sa.MessageID = sub.MessageID
+	sa.ReturnCodes = codes(sub)
+	if err := sa.Write(broker); err != nil {
+		t.Fatalf("error writing suback: %v", err)
+	}
+	return sub
+}
+
+func Test_Healthcheck_notConnected(t *testing.T) {
+	c := NewClient(NewClientOptions())
+
+	if err := c.Healthcheck(context.Background()); err != ErrNotConnected {
+		t.Fatalf("expected ErrNotConnected, got %v", err)
+	}
+}
+
+func Test_Healthcheck(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	go func() {
+		for {
+			cp, err := packets.ReadPacket(broker)
+			if err != nil {
+				return
+			}
+			if _, ok := cp.(*packets.PingreqPacket); ok {
+				packets.NewControlPacket(packets.Pingresp).Write(broker)
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Healthcheck(ctx); err != nil {
+		t.Fatalf("unexpected error from Healthcheck: %v", err)
+	}
+}
+
+// Test_Healthcheck_earlierPing checks that the response to a PINGREQ sent before the Healthcheck
+// (e.g. by the keepalive routine) does not satisfy it
+func Test_Healthcheck_earlierPing(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	c.pingReqSent() // a PINGREQ awaiting its response
+	pings := make(chan struct{}, 1)
+	go func() {
+		for {
+			cp, err := packets.ReadPacket(broker)
+			if err != nil {
+				return
+			}
+			if _, ok := cp.(*packets.PingreqPacket); ok {
+				pings <- struct{}{}
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() { result <- c.Healthcheck(ctx) }()
+	select {
+	case <-pings:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("PINGREQ not sent")
+	}
+
+	if err := packets.NewControlPacket(packets.Pingresp).Write(broker); err != nil {
+		t.Fatalf("error writing pingresp: %v", err)
+	}
+	select {
+	case err := <-result:
+		t.Fatalf("Healthcheck satisfied by the response to an earlier PINGREQ (err %v)", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := packets.NewControlPacket(packets.Pingresp).Write(broker); err != nil {
+		t.Fatalf("error writing pingresp: %v", err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("unexpected error from Healthcheck: %v", err)
+	}
+}
+
+func Test_Healthcheck_timeout(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	go func() { // Read (and ignore) everything so the PINGREQ can be sent
+		for {
+			if _, err := packets.ReadPacket(broker); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := c.Healthcheck(ctx); err != ErrPingTimeout {
+		t.Fatalf("expected ErrPingTimeout, got %v", err)
+	}
+}
+
+// Messages are delivered at the lower of the publish QoS and the subscription QoS so a QoS 2
+// subscription may receive QoS 1 messages; these must be acknowledged with a PUBACK.
+func Test_ReceiveDowngradedQos(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	received := make(chan Message, 1)
+	token := c.Subscribe("test/downgrade", 2, func(client Client, msg Message) {
+		received <- msg
+	})
+
+	ackSubscribe(t, broker, 2)
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("subscribe did not complete: %v", token.Error())
+	}
+
+	pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	pub.TopicName = "test/downgrade"
+	pub.Qos = 1
+	pub.MessageID = 42
+	pub.Payload = []byte("qos1 payload")
+	if err := pub.Write(broker); err != nil {
+		t.Fatalf("error writing publish: %v", err)
+	}
+
+	select {
+	case msg := <-received:
+		if msg.Qos() != 1 {
+			t.Fatalf("expected message at QoS 1, got %d", msg.Qos())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message was not delivered")
+	}
+
+	ack, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading acknowledgement: %v", err)
+	}
+	pa, ok := ack.(*packets.PubackPacket)
+	if !ok {
+		t.Fatalf("expected PUBACK, got %s", ack.String())
+	}
+	if pa.MessageID != 42 {
+		t.Fatalf("PUBACK had message id %d, expected 42", pa.MessageID)
+	}
+}
+
+func Test_SubscribeTimeout(t *testing.T) {
+	ops := NewClientOptions().SetKeepAlive(0).SetSubscribeTimeout(50 * time.Millisecond).SetSubscribeRetryCount(1)
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	token := c.Subscribe("test/timeout", 1, nil)
+
+	var ids []uint16
+	for i := 0; i < 2; i++ {
+		sub, err := packets.ReadPacket(broker)
+		if err != nil {
+			t.Fatalf("error reading subscribe: %v", err)
+		}
+		ids = append(ids, sub.Details().MessageID)
+	}
+	if ids[0] != ids[1] {
+		t.Fatalf("retried subscribe used message id %d, original was %d", ids[1], ids[0])
+	}
+
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("subscribe token did not complete")
+	}
+	if token.Error() != ErrSubscribeTimeout {
+		t.Fatalf("expected ErrSubscribeTimeout, got %v", token.Error())
+	}
+}
+
+func Test_SubscribeTimeout_retrySucceeds(t *testing.T) {
+	ops := NewClientOptions().SetKeepAlive(0).SetSubscribeTimeout(50 * time.Millisecond).SetSubscribeRetryCount(3)
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	token := c.Subscribe("test/timeout", 1, nil)
+
+	// Ignore the original subscribe and acknowledge the first retry
+	if _, err := packets.ReadPacket(broker); err != nil {
+		t.Fatalf("error reading subscribe: %v", err)
+	}
+	ackSubscribe(t, broker, 1)
+
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("subscribe token did not complete")
+	}
+	if token.Error() != nil {
+		t.Fatalf("unexpected error: %v", token.Error())
+	}
+}
+
+func Test_CustomOpenConnectionFn(t *testing.T) {
+	var called *url.URL
+	ops := NewClientOptions().AddBroker("tcp://10.10.0.1:1883").SetCustomOpenConnectionFn(
+		func(uri *url.URL, options ClientOptions) (net.Conn, error) {
+			called = uri
+			return nil, errors.New("custom open connection failed")
+		})
+	c := NewClient(ops)
+
+	token := c.Connect()
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("connect did not complete")
+	}
+	if token.Error() == nil {
+		t.Fatalf("expected error from connect")
+	}
+	if called == nil || called.Host != "10.10.0.1:1883" {
+		t.Fatalf("custom open connection function not called with broker URL, got %v", called)
+	}
+}
+
+// Test_HandlerContext checks that the context passed to a MessageHandlerWithContext is cancelled
+// when the connection is closed
+func Test_HandlerContext(t *testing.T) {
+	started := make(chan struct{})
+	done := make(chan error)
+	ops := NewClientOptions().SetKeepAlive(0).SetOrderMatters(false).
+		SetDefaultPublishHandlerWithContext(func(ctx context.Context, c Client, m Message) {
+			close(started)
+			<-ctx.Done()
+			done <- ctx.Err()
+		})
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+
+	pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	pub.TopicName = "test/ctx"
+	pub.Payload = []byte("hello")
+	if err := pub.Write(broker); err != nil {
+		t.Fatalf("error writing publish: %v", err)
+	}
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("handler not called")
+	}
+
+	c.forceDisconnect()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("handler context not cancelled on disconnect")
+	}
+}
+
+func Test_MaxReconnectAttempts(t *testing.T) {
+	dialErr := errors.New("dial failed")
+	lost := make(chan error, 2)
+	ops := NewClientOptions().SetKeepAlive(0).AddBroker("tcp://127.0.0.1:1883").
+		SetAutoReconnect(true).SetMaxReconnectAttempts(2).
+		SetCustomOpenConnectionFn(func(uri *url.URL, options ClientOptions) (net.Conn, error) {
+			return nil, dialErr
+		}).
+		SetConnectionLostHandler(func(c Client, err error) { lost <- err })
+	c, broker := newPipeClient(ops)
+	broker.Close() // drop the connection so the client starts reconnecting
+
+	// The first call reports the lost connection, the second that reconnection has been abandoned
+	var err error
+	for i := 0; i < 2; i++ {
+		select {
+		case err = <-lost:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("connection lost handler not called")
+		}
+	}
+	if !errors.Is(err, ErrMaxReconnectAttemptsExceeded) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if last := errors.Unwrap(err); last == nil || !strings.Contains(last.Error(), dialErr.Error()) {
+		t.Fatalf("error did not wrap the last connection error: %v", last)
+	}
+	if c.ReconnectAttempts() != 2 {
+		t.Fatalf("expected 2 reconnect attempts, got %d", c.ReconnectAttempts())
+	}
+	if c.IsConnected() {
+		t.Fatalf("client should be disconnected")
+	}
+}
+
+func Test_TopicACL(t *testing.T) {
+	ops := NewClientOptions().SetKeepAlive(0).SetTopicACL(func(topic string, op ACLOperation) bool {
+		return !(op == ACLPublish && topic == "secret") && !(op == ACLSubscribe && topic == "#")
+	})
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	if err := c.Publish("secret", 0, false, "x").Error(); err != ErrTopicForbidden {
+		t.Fatalf("expected ErrTopicForbidden from Publish, got %v", err)
+	}
+	if err := c.Subscribe("#", 0, nil).Error(); err != ErrTopicForbidden {
+		t.Fatalf("expected ErrTopicForbidden from Subscribe, got %v", err)
+	}
+	if err := c.SubscribeMultiple(map[string]byte{"a": 0, "#": 0}, nil).Error(); err != ErrTopicForbidden {
+		t.Fatalf("expected ErrTopicForbidden from SubscribeMultiple, got %v", err)
+	}
+
+	// Permitted operations are sent as normal (and must be the first packet the broker sees)
+	go c.Publish("public", 0, false, "x")
+	p, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	if pub, ok := p.(*packets.PublishPacket); !ok || pub.TopicName != "public" {
+		t.Fatalf("unexpected packet sent: %s", p)
+	}
+}
+
+func Test_ConnectError(t *testing.T) {
+	for _, detailed := range []bool{false, true} {
+		conn, broker := net.Pipe()
+		ops := NewClientOptions().AddBroker("tcp://127.0.0.1:1883").SetProtocolVersion(4).
+			SetDetailedConnectErrors(detailed).
+			SetCustomOpenConnectionFn(func(uri *url.URL, options ClientOptions) (net.Conn, error) {
+				return conn, nil
+			})
+		c := NewClient(ops)
+
+		token := c.Connect()
+		if _, err := packets.ReadPacket(broker); err != nil {
+			t.Fatalf("error reading connect: %v", err)
+		}
+		ca := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+		ca.ReturnCode = packets.ErrRefusedNotAuthorised
+		if err := ca.Write(broker); err != nil {
+			t.Fatalf("error writing connack: %v", err)
+		}
+		if !token.WaitTimeout(5 * time.Second) {
+			t.Fatalf("connect did not complete")
+		}
+		broker.Close()
+
+		var ce *ConnectError
+		if !detailed {
+			if token.Error() != packets.ConnErrors[packets.ErrRefusedNotAuthorised] || errors.As(token.Error(), &ce) {
+				t.Fatalf("expected packets.ConnErrors error, got %#v", token.Error())
+			}
+			continue
+		}
+		if !errors.As(token.Error(), &ce) {
+			t.Fatalf("expected a ConnectError, got %v", token.Error())
+		}
+		if ce.ReturnCode != packets.ErrRefusedNotAuthorised || ce.ProtocolVersion() != 4 {
+			t.Fatalf("unexpected ConnectError: %+v, protocol version %d", ce, ce.ProtocolVersion())
+		}
+		if !errors.Is(token.Error(), packets.ConnErrors[packets.ErrRefusedNotAuthorised]) ||
+			token.Error().Error() != packets.ConnErrors[packets.ErrRefusedNotAuthorised].Error() {
+			t.Fatalf("ConnectError does not match packets.ConnErrors: %v", token.Error())
+		}
+	}
+}
+
+func Test_optionalInterfaces(t *testing.T) {
+	c := NewClient(NewClientOptions())
+	if _, ok := c.(Drainer); !ok {
+		t.Errorf("client does not implement Drainer")
+	}
+	if _, ok := c.(ExtendedPublisher); !ok {
+		t.Errorf("client does not implement ExtendedPublisher")
+	}
+	if _, ok := c.(ExtendedSubscriber); !ok {
+		t.Errorf("client does not implement ExtendedSubscriber")
+	}
+	if _, ok := c.(Diagnostics); !ok {
+		t.Errorf("client does not implement Diagnostics")
+	}
+}
+
+func Test_Fire(t *testing.T) {
+	if err := NewClient(NewClientOptions()).(ExtendedPublisher).Fire("a", "x"); err != ErrNotConnected {
+		t.Fatalf("expected ErrNotConnected, got %v", err)
+	}
+
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.Fire("telemetry", []byte("42")) }()
+	cp, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	pub, ok := cp.(*packets.PublishPacket)
+	if !ok || pub.Qos != 0 || pub.Retain || pub.TopicName != "telemetry" || string(pub.Payload) != "42" {
+		t.Fatalf("unexpected packet: %s", cp.String())
+	}
+	if err := <-errCh; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Fire("telemetry", 42); err == nil {
+		t.Fatalf("expected an error for an unsupported payload type")
+	}
+}
+
+func Test_ShouldRetry(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{&ConnectError{ReturnCode: packets.ErrRefusedServerUnavailable}, true},
+		{&ConnectError{ReturnCode: packets.ErrRefusedBadProtocolVersion}, false},
+		{&ConnectError{ReturnCode: packets.ErrRefusedIDRejected}, false},
+		{&ConnectError{ReturnCode: packets.ErrRefusedBadUsernameOrPassword}, false},
+		{&ConnectError{ReturnCode: packets.ErrRefusedNotAuthorised}, false},
+		{packets.ConnErrors[packets.ErrRefusedServerUnavailable], true},
+		{packets.ConnErrors[packets.ErrRefusedBadUsernameOrPassword], false},
+		{errors.New("network Error : dial tcp: connection refused"), true},
+		{&InvalidClientIDError{ClientID: "x", Err: errors.New("too short")}, false},
+		{ErrConnectPacketTooLarge, false},
+	}
+	for _, tt := range tests {
+		if got := ShouldRetry(tt.err); got != tt.want {
+			t.Errorf("ShouldRetry(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func Test_ProtocolVersionFallback(t *testing.T) {
+	conns := make(chan net.Conn, 2)
+	brokers := make(chan net.Conn, 2)
+	for i := 0; i < 2; i++ {
+		conn, broker := net.Pipe()
+		defer broker.Close()
+		conns <- conn
+		brokers <- broker
+	}
+	ops := NewClientOptions().AddBroker("tcp://127.0.0.1:1883").SetKeepAlive(0).SetAutoReconnect(false).
+		SetProtocolVersion(4).SetProtocolVersionFallback(true).
+		SetCustomOpenConnectionFn(func(uri *url.URL, options ClientOptions) (net.Conn, error) {
+			return <-conns, nil
+		})
+	c := NewClient(ops)
+	token := c.Connect()
+
+	for _, tc := range []struct {
+		version byte
+		rc      byte
+	}{{4, packets.ErrRefusedBadProtocolVersion}, {3, packets.Accepted}} {
+		broker := <-brokers
+		cp, err := packets.ReadPacket(broker)
+		if err != nil {
+			t.Fatalf("error reading connect: %v", err)
+		}
+		if v := cp.(*packets.ConnectPacket).ProtocolVersion; v != tc.version {
+			t.Fatalf("expected protocol version %d, got %d", tc.version, v)
+		}
+		ca := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+		ca.ReturnCode = tc.rc
+		if err := ca.Write(broker); err != nil {
+			t.Fatalf("error writing connack: %v", err)
+		}
+	}
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	defer c.(*client).forceDisconnect()
+	r := c.OptionsReader()
+	if v := r.ProtocolVersion(); v != 3 {
+		t.Fatalf("expected protocol version 3 to be locked in, got %d", v)
+	}
+}
+
+func Test_ResumePublishSetsDup(t *testing.T) {
+	conn, broker2 := net.Pipe()
+	defer broker2.Close()
+	ops := NewClientOptions().AddBroker("tcp://127.0.0.1:1883").SetKeepAlive(0).SetCleanSession(false).
+		SetAutoReconnect(true).SetMaxReconnectInterval(10 * time.Millisecond).
+		SetCustomOpenConnectionFn(func(uri *url.URL, options ClientOptions) (net.Conn, error) {
+			return conn, nil
+		})
+	c, broker := newPipeClient(ops)
+	defer c.forceDisconnect()
+
+	c.Publish("a", 1, false, "x")
+	cp, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	first := cp.(*packets.PublishPacket)
+	if first.Dup {
+		t.Fatalf("DUP should not be set on the first transmission")
+	}
+	broker.Close() // lose the connection before the PUBACK
+
+	if _, err := packets.ReadPacket(broker2); err != nil {
+		t.Fatalf("error reading connect: %v", err)
+	}
+	// A publish stored while reconnecting has not been sent so DUP must not be set
+	c.Publish("b", 1, false, "y")
+	ca := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	ca.SessionPresent = true
+	if err := ca.Write(broker2); err != nil {
+		t.Fatalf("error writing connack: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		cp, err = packets.ReadPacket(broker2)
+		if err != nil {
+			t.Fatalf("error reading resumed publish: %v", err)
+		}
+		pub, ok := cp.(*packets.PublishPacket)
+		switch {
+		case !ok:
+			t.Fatalf("unexpected packet %s", cp.String())
+		case pub.TopicName == "a" && (!pub.Dup || pub.MessageID != first.MessageID):
+			t.Fatalf("expected retransmission with DUP set, got %s", cp.String())
+		case pub.TopicName == "b" && pub.Dup:
+			t.Fatalf("DUP set on publish that was never sent: %s", cp.String())
+		}
+	}
+}
+
+func Test_ReconnectWithQueuedPublishes(t *testing.T) {
+	conn, broker2 := net.Pipe()
+	defer broker2.Close()
+	ops := NewClientOptions().AddBroker("tcp://127.0.0.1:1883").SetKeepAlive(0).SetCleanSession(false).
+		SetAutoReconnect(true).SetMaxReconnectInterval(10 * time.Millisecond).
+		SetInternalBufferSize(BufferSizeConfig{PublishQueue: 10}).
+		SetCustomOpenConnectionFn(func(uri *url.URL, options ClientOptions) (net.Conn, error) {
+			return conn, nil
+		})
+	c, broker := newPipeClient(ops)
+	defer c.forceDisconnect()
+
+	for i := 0; i < 5; i++ {
+		c.Publish("a", 1, false, "x")
+	}
+	if _, err := packets.ReadPacket(broker); err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	broker.Close() // lose the connection with publishes still queued
+
+	if _, err := packets.ReadPacket(broker2); err != nil {
+		t.Fatalf("error reading connect: %v", err)
+	}
+	ca := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	ca.SessionPresent = true
+	if err := ca.Write(broker2); err != nil {
+		t.Fatalf("error writing connack: %v", err)
+	}
+
+	// Each publish is resent once, from the store
+	sent := make(map[uint16]int)
+	broker2.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		cp, err := packets.ReadPacket(broker2)
+		if err != nil {
+			break
+		}
+		pub, ok := cp.(*packets.PublishPacket)
+		if !ok {
+			t.Fatalf("unexpected packet %s", cp.String())
+		}
+		if sent[pub.MessageID]++; sent[pub.MessageID] > 1 {
+			t.Fatalf("publish %d sent more than once", pub.MessageID)
+		}
+	}
+	if len(sent) != 5 {
+		t.Fatalf("expected 5 publishes to be resent, got %v", sent)
+	}
+}
+
+// Test_SubscribeInOnConnect checks that a Subscribe made from the OnConnect handler is not discarded
+// along with any packets left queued from a previous connection
+func Test_SubscribeInOnConnect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paho-onconnect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 10; i++ {
+		conn, broker := net.Pipe()
+		subscribed := make(chan Token, 1)
+		ops := NewClientOptions().AddBroker("tcp://127.0.0.1:1883").SetKeepAlive(0).SetCleanSession(true).
+			SetStore(NewFileStore(filepath.Join(dir, strconv.Itoa(i)))).
+			SetOnConnectHandler(func(c Client) { subscribed <- c.Subscribe("a", 1, nil) }).
+			SetCustomOpenConnectionFn(func(uri *url.URL, options ClientOptions) (net.Conn, error) {
+				return conn, nil
+			})
+		c := NewClient(ops).(*client)
+		// Hold up startCommsWorkers after the ConnectedEvent so that OnConnect (called on its own
+		// goroutine) is waiting to queue the SUBSCRIBE while the connection is being set up
+		c.events.subscribe(func(e Event) {
+			if _, ok := e.(ConnectedEvent); ok {
+				time.Sleep(20 * time.Millisecond)
+			}
+		})
+
+		go func() {
+			if _, err := packets.ReadPacket(broker); err != nil {
+				return
+			}
+			packets.NewControlPacket(packets.Connack).Write(broker)
+		}()
+		if ct := c.Connect(); !ct.WaitTimeout(5*time.Second) || ct.Error() != nil {
+			t.Fatalf("connect failed: %v", ct.Error())
+		}
+		broker.SetReadDeadline(time.Now().Add(5 * time.Second))
+		ackSubscribe(t, broker, 1)
+		if st := <-subscribed; !st.WaitTimeout(5*time.Second) || st.Error() != nil {
+			t.Fatalf("subscribe from OnConnect failed (iteration %d): %v", i, st.Error())
+		}
+		c.forceDisconnect()
+		broker.Close()
+	}
+}
+
+func Test_Drain(t *testing.T) {
+	received := make(chan string, 1)
+	ops := NewClientOptions().SetKeepAlive(0).
+		SetDefaultPublishHandler(func(c Client, m Message) { received <- m.Topic() })
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	c.Publish("out", 1, false, "x")
+	cp, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+
+	drained := make(chan error, 1)
+	go func() { drained <- c.Drain(5 * time.Second) }()
+	for atomic.LoadUint32(&c.draining) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.Publish("out", 0, false, "y").Error(); err != ErrDraining {
+		t.Fatalf("expected ErrDraining, got %v", err)
+	}
+
+	// Messages are still received whilst draining
+	in := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	in.TopicName = "in"
+	if err := in.Write(broker); err != nil {
+		t.Fatalf("error writing publish: %v", err)
+	}
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message not delivered while draining")
+	}
+	select {
+	case err := <-drained:
+		t.Fatalf("drain completed before the publish was acknowledged: %v", err)
+	default:
+	}
+
+	ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+	ack.MessageID = cp.Details().MessageID
+	if err := ack.Write(broker); err != nil {
+		t.Fatalf("error writing puback: %v", err)
+	}
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatalf("unexpected drain error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("drain did not complete")
+	}
+}
+
+func Test_DrainTimeout(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	c.Publish("out", 1, false, "x")
+	if _, err := packets.ReadPacket(broker); err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	if err := c.Drain(50 * time.Millisecond); err != ErrDrainTimeout {
+		t.Fatalf("expected ErrDrainTimeout, got %v", err)
+	}
+}
+
+// Test_DrainConnectionLost checks that Drain returns if the connection is lost while a publish is
+// outstanding (with CleanSession false it stays outstanding until the client reconnects)
+func Test_DrainConnectionLost(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0).SetAutoReconnect(false).SetCleanSession(false))
+
+	c.Publish("out", 1, false, "x")
+	if _, err := packets.ReadPacket(broker); err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	drained := make(chan error, 1)
+	go func() { drained <- c.Drain(0) }()
+	for atomic.LoadUint32(&c.draining) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	broker.Close()
+	select {
+	case err := <-drained:
+		if err != ErrNotConnected {
+			t.Fatalf("expected ErrNotConnected, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("drain did not return when the connection was lost")
+	}
+}
+
+// Test_DrainDeadlineExpired checks that Drain notices a publish abandoned by PublishWithDeadline
+// (no acknowledgement arrives so the number of message ids in use does not fall)
+func Test_DrainDeadlineExpired(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	c.PublishWithDeadline(context.Background(), time.Now().Add(100*time.Millisecond), "out", 1, false, "x")
+	if _, err := packets.ReadPacket(broker); err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	if err := c.Drain(5 * time.Second); err != nil {
+		t.Fatalf("unexpected drain error: %v", err)
+	}
+}
+
+func Test_PublishWithDeadline(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	token := c.PublishWithDeadline(context.Background(), time.Now().Add(50*time.Millisecond), "a", 1, false, "x")
+	cp, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("token not completed at deadline")
+	}
+	if token.Error() != ErrPublishDeadlineExceeded {
+		t.Fatalf("expected ErrPublishDeadlineExceeded, got %v", token.Error())
+	}
+	if !c.IsConnectionOpen() {
+		t.Fatalf("client should remain connected")
+	}
+
+	// The late PUBACK is discarded and releases the message id
+	ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+	ack.MessageID = cp.Details().MessageID
+	if err := ack.Write(broker); err != nil {
+		t.Fatalf("error writing puback: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		c.messageIds.RLock()
+		n := len(c.messageIds.index)
+		c.messageIds.RUnlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("message id not released by late PUBACK")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if token.Error() != ErrPublishDeadlineExceeded {
+		t.Fatalf("late PUBACK changed the token error to %v", token.Error())
+	}
+}
+
+func Test_PublishWithDeadline_acknowledged(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	token := c.PublishWithDeadline(ctx, time.Now().Add(5*time.Second), "a", 1, false, "x")
+	cp, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+	ack.MessageID = cp.Details().MessageID
+	if err := ack.Write(broker); err != nil {
+		t.Fatalf("error writing puback: %v", err)
+	}
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("expected successful publish, got %v", token.Error())
+	}
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	if token.Error() != nil {
+		t.Fatalf("cancelling the context after completion set error %v", token.Error())
+	}
+}
+
+func Test_ClientIDValidator(t *testing.T) {
+	policy := errors.New("client id must start with dev-")
+	opened := false
+	ops := NewClientOptions().AddBroker("tcp://127.0.0.1:1883").SetClientID("abc").SetConnectRetry(true).
+		SetClientIDValidator(func(id string) error {
+			if !strings.HasPrefix(id, "dev-") {
+				return policy
+			}
+			return nil
+		}).
+		SetCustomOpenConnectionFn(func(uri *url.URL, options ClientOptions) (net.Conn, error) {
+			opened = true
+			return nil, errors.New("should not be called")
+		})
+	c := NewClient(ops)
+
+	token := c.Connect()
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("connect did not complete")
+	}
+	err := token.Error()
+	var ie *InvalidClientIDError
+	if !errors.As(err, &ie) || ie.ClientID != "abc" {
+		t.Fatalf("expected InvalidClientIDError, got %v", err)
+	}
+	if !errors.Is(err, ErrInvalidClientID) || !errors.Is(err, policy) {
+		t.Fatalf("expected error to match ErrInvalidClientID and the validator error: %v", err)
+	}
+	if opened {
+		t.Fatalf("connection should not be opened with an invalid client id")
+	}
+}
+
+func Test_OnPreConnect(t *testing.T) {
+	conn, broker := net.Pipe()
+	defer broker.Close()
+	expired := errors.New("token could not be refreshed")
+	calls := 0
+	lost := make(chan error, 2)
+	ops := NewClientOptions().AddBroker("tcp://127.0.0.1:1883").SetKeepAlive(0).
+		SetAutoReconnect(true).SetMaxReconnectInterval(10 * time.Millisecond).
+		SetOnPreConnect(func(o *ClientOptions) error {
+			calls++
+			if calls > 1 {
+				return expired
+			}
+			o.SetUsername("token-1")
+			return nil
+		}).
+		SetConnectionLostHandler(func(_ Client, err error) {
+			lost <- err
+		}).
+		SetCustomOpenConnectionFn(func(uri *url.URL, options ClientOptions) (net.Conn, error) {
+			return conn, nil
+		})
+	c := NewClient(ops)
+	token := c.Connect()
+
+	cp, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading connect: %v", err)
+	}
+	if u := cp.(*packets.ConnectPacket).Username; u != "token-1" {
+		t.Fatalf("expected username set by the pre-connect handler, got %q", u)
+	}
+	if err := packets.NewControlPacket(packets.Connack).Write(broker); err != nil {
+		t.Fatalf("error writing connack: %v", err)
+	}
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+
+	// Losing the connection leads to a reconnect which the handler aborts; the loss of the
+	// connection is reported first
+	broker.Close()
+	timeout := time.After(5 * time.Second)
+	for err := error(nil); err != expired; {
+		select {
+		case err = <-lost:
+		case <-timeout:
+			t.Fatalf("pre-connect error not reported")
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("expected the handler to be called twice, got %d", calls)
+	}
+	if c.IsConnected() {
+		t.Fatalf("client should not be reconnecting after the handler failed")
+	}
+}
+
+func Test_PublishQueueAlarm(t *testing.T) {
+	alarms := make(chan bool, 10)
+	o := NewClientOptions().SetKeepAlive(0).SetPublishQueueAlarm(5, 2, func(aboveHigh bool) {
+		alarms <- aboveHigh
+	})
+	o.BufferSizes.PublishQueue = 10
+	c, broker := newPipeClient(o)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	// The broker is not reading so the queue fills
+	const count = 9
+	for i := 0; i < count; i++ {
+		c.Publish("a", 0, false, "x")
+	}
+	select {
+	case above := <-alarms:
+		if !above {
+			t.Fatalf("expected alarm to be raised")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("alarm not raised")
+	}
+
+	for i := 0; i < count; i++ {
+		if _, err := packets.ReadPacket(broker); err != nil {
+			t.Fatalf("error reading publish: %v", err)
+		}
+	}
+	select {
+	case above := <-alarms:
+		if above {
+			t.Fatalf("expected alarm to be cleared")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("alarm not cleared")
+	}
+	if len(alarms) != 0 {
+		t.Fatalf("unexpected additional alarms")
+	}
+}
+
+func Test_MsgReceiveQueueLimit(t *testing.T) {
+	release := make(chan struct{})
+	var handled int32
+	o := NewClientOptions().SetKeepAlive(0).SetOrderMatters(false).
+		SetInternalBufferSize(BufferSizeConfig{MsgReceiveQueue: 2}).
+		SetDefaultPublishHandler(func(Client, Message) {
+			<-release
+			atomic.AddInt32(&handled, 1)
+		})
+	c, broker := newPipeClient(o)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	// net.Pipe is unbuffered so writes block once the client stops reading
+	const count = 20
+	var written int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < count; i++ {
+			pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+			pub.TopicName = "a"
+			pub.Payload = []byte("x")
+			if err := pub.Write(broker); err != nil {
+				return
+			}
+			atomic.AddInt32(&written, 1)
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if w := atomic.LoadInt32(&written); w >= count {
+		t.Fatalf("client kept reading while handlers were blocked (%d messages written)", w)
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("reading did not resume (%d messages written)", atomic.LoadInt32(&written))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&handled) != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d messages handled, got %d", count, atomic.LoadInt32(&handled))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func Test_FanoutPublish(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0).SetTopicACL(func(topic string, _ ACLOperation) bool {
+		return topic != "forbidden"
+	}))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	topics := []string{"raw", "forbidden", "aggregated"}
+	done := make(chan []Token)
+	go func() { done <- c.FanoutPublish(topics, 0, false, "21.5") }()
+
+	received := map[string]string{}
+	for i := 0; i < 2; i++ {
+		cp, err := packets.ReadPacket(broker)
+		if err != nil {
+			t.Fatalf("error reading publish: %v", err)
+		}
+		pub := cp.(*packets.PublishPacket)
+		received[pub.TopicName] = string(pub.Payload)
+	}
+	tokens := <-done
+	if len(tokens) != len(topics) {
+		t.Fatalf("expected %d tokens, got %d", len(topics), len(tokens))
+	}
+	for i, topic := range topics {
+		tokens[i].Wait()
+		if topic == "forbidden" {
+			if tokens[i].Error() != ErrTopicForbidden {
+				t.Errorf("expected ErrTopicForbidden for %s, got %v", topic, tokens[i].Error())
+			}
+			continue
+		}
+		if tokens[i].Error() != nil || received[topic] != "21.5" {
+			t.Errorf("publish to %s failed (%v), received %q", topic, tokens[i].Error(), received[topic])
+		}
+	}
+}
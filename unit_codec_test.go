@@ -0,0 +1,69 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+type codecReading struct {
+	Sensor string
+	Value  float64
+}
+
+func Test_PublishEncoded(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	go PublishEncoded(c, "test/codec", 0, false, codecReading{Sensor: "a", Value: 1.5}, "json")
+	p, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	if payload := string(p.(*packets.PublishPacket).Payload); payload != `{"Sensor":"a","Value":1.5}` {
+		t.Fatalf("unexpected payload %s", payload)
+	}
+
+	if err := PublishEncoded(c, "test/codec", 0, false, 1, "unknown").Error(); err != ErrUnknownCodec {
+		t.Fatalf("expected ErrUnknownCodec, got %v", err)
+	}
+}
+
+func Test_SubscribeDecoded(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	received := make(chan interface{}, 1)
+	SubscribeDecoded(c, "test/codec", 0, "gob", codecReading{}, func(c Client, m Message, v interface{}, err error) {
+		if err != nil {
+			received <- err
+			return
+		}
+		received <- v
+	})
+	ackSubscribe(t, broker)
+
+	payload, err := GobCodec{}.Marshal(codecReading{Sensor: "b", Value: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	pub.TopicName = "test/codec"
+	pub.Payload = payload
+	if err := pub.Write(broker); err != nil {
+		t.Fatalf("error writing publish: %v", err)
+	}
+
+	select {
+	case v := <-received:
+		r, ok := v.(*codecReading)
+		if !ok || r.Sensor != "b" || r.Value != 2 {
+			t.Fatalf("unexpected value decoded: %#v", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("message not received")
+	}
+}
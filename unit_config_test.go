@@ -0,0 +1,94 @@
+package mqtt
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_NewClientFromConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqttconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert := selfSignedCert(t)
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfgFile := filepath.Join(dir, "client.json")
+	cfg := `{
+		"brokers": ["ssl://127.0.0.1:8883"],
+		"clientId": "cfg-client",
+		"username": "user",
+		"password": "pass",
+		"cleanSession": false,
+		"keepAlive": "45s",
+		"connectTimeout": 5,
+		"autoReconnect": false,
+		"will": {"topic": "status", "payload": "offline", "qos": 1, "retained": true},
+		"tls": {"caFile": "` + filepath.ToSlash(caFile) + `", "serverName": "broker"}
+	}`
+	if err := ioutil.WriteFile(cfgFile, []byte(cfg), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewClientFromConfig(cfgFile)
+	if err != nil {
+		t.Fatalf("NewClientFromConfig failed: %v", err)
+	}
+	r := c.OptionsReader()
+	if len(r.Servers()) != 1 || r.Servers()[0].Host != "127.0.0.1:8883" {
+		t.Errorf("unexpected servers %v", r.Servers())
+	}
+	if r.ClientID() != "cfg-client" || r.Username() != "user" || r.Password() != "pass" {
+		t.Errorf("credentials not applied")
+	}
+	if r.CleanSession() || r.AutoReconnect() {
+		t.Errorf("boolean settings not applied")
+	}
+	if !r.Order() {
+		t.Errorf("omitted settings should keep their defaults")
+	}
+	if r.KeepAlive() != 45*time.Second || r.ConnectTimeout() != 5*time.Second {
+		t.Errorf("durations not applied, keepalive %v connect timeout %v", r.KeepAlive(), r.ConnectTimeout())
+	}
+	if !r.WillEnabled() || r.WillTopic() != "status" || r.WillQos() != 1 || !r.WillRetained() {
+		t.Errorf("will not applied")
+	}
+	if tc := r.TLSConfig(); tc == nil || tc.RootCAs == nil || tc.ServerName != "broker" {
+		t.Errorf("tls not applied")
+	}
+}
+
+func Test_NewClientFromConfig_Invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqttconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, cfg := range map[string]string{
+		"nobrokers.json": `{"clientId": "x"}`,
+		"badjson.json":   `brokers: [tcp://localhost:1883]`,
+		"badtime.json":   `{"brokers": ["tcp://localhost:1883"], "keepAlive": "soon"}`,
+		"nocafile.json":  `{"brokers": ["tcp://localhost:1883"], "tls": {"caFile": "missing.pem"}}`,
+	} {
+		f := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(f, []byte(cfg), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewClientFromConfig(f); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := NewClientFromConfig(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,60 @@
+package mqtt
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_EstimateConnectPacketSize(t *testing.T) {
+	o := NewClientOptions().SetClientID("abc")
+	// Fixed header (2) + variable header (10) + client id (2+3)
+	if size := EstimateConnectPacketSize(o); size != 17 {
+		t.Fatalf("expected 17 bytes, got %d", size)
+	}
+	o.SetUsername("user").SetPassword("pass").SetWill("w", "bye", 0, false)
+	if size := EstimateConnectPacketSize(o); size != 17+6+6+3+5 {
+		t.Fatalf("expected %d bytes, got %d", 17+6+6+3+5, size)
+	}
+	o.SetProtocolVersion(3)
+	if size := EstimateConnectPacketSize(o); size != 17+6+6+3+5+2 {
+		t.Fatalf("expected %d bytes for MQTT 3.1, got %d", 17+6+6+3+5+2, size)
+	}
+}
+
+func Test_MaxConnectPacketSize(t *testing.T) {
+	opened := false
+	ops := NewClientOptions().AddBroker("tcp://127.0.0.1:1883").SetClientID("abc").
+		SetPassword("a long password").SetUsername("user").SetMaxConnectPacketSize(20).
+		SetCustomOpenConnectionFn(func(uri *url.URL, options ClientOptions) (net.Conn, error) {
+			opened = true
+			return nil, errors.New("should not be called")
+		})
+	token := NewClient(ops).Connect()
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("connect did not complete")
+	}
+	if token.Error() != ErrConnectPacketTooLarge {
+		t.Fatalf("expected ErrConnectPacketTooLarge, got %v", token.Error())
+	}
+	if opened {
+		t.Fatalf("connection should not be opened")
+	}
+}
+
+// Test_MaxConnectPacketSize_connectRetry checks that Connect does not keep retrying a CONNECT that
+// can never be sent
+func Test_MaxConnectPacketSize_connectRetry(t *testing.T) {
+	ops := NewClientOptions().AddBroker("tcp://127.0.0.1:1883").SetClientID("abc").
+		SetPassword("a long password").SetUsername("user").SetMaxConnectPacketSize(20).
+		SetConnectRetry(true).SetConnectRetryInterval(10 * time.Millisecond)
+	token := NewClient(ops).Connect()
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("connect is still being retried")
+	}
+	if token.Error() != ErrConnectPacketTooLarge {
+		t.Fatalf("expected ErrConnectPacketTooLarge, got %v", token.Error())
+	}
+}
@@ -0,0 +1,55 @@
+package mqtt
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// publishRecorder is a Client that records the payloads published
+type publishRecorder struct {
+	Client
+	payloads []interface{}
+}
+
+func (r *publishRecorder) Publish(topic string, qos byte, retained bool, payload interface{}) Token {
+	r.payloads = append(r.payloads, payload)
+	t := newToken(packets.Publish).(*PublishToken)
+	t.flowComplete()
+	return t
+}
+
+func Test_DeadbandPublisher(t *testing.T) {
+	rec := &publishRecorder{}
+	parse := func(b []byte) (float64, error) { return strconv.ParseFloat(string(b), 64) }
+	d := NewDeadbandPublisher(rec, 0.5, parse)
+
+	for _, p := range []string{"20.0", "20.2", "20.4", "20.5", "19.9", "not a number", "19.0"} {
+		if token := d.Publish("temp", 0, false, p); !token.Wait() || token.Error() != nil {
+			t.Fatalf("publish of %s failed: %v", p, token.Error())
+		}
+	}
+	// Another topic has its own last value
+	d.Publish("humidity", 0, false, []byte("20.1"))
+
+	expected := []interface{}{"20.0", "20.5", "19.9", "not a number", "19.0", []byte("20.1")}
+	if len(rec.payloads) != len(expected) {
+		t.Fatalf("expected %v to be published, got %v", expected, rec.payloads)
+	}
+	for i := range expected {
+		if string(toBytes(expected[i])) != string(toBytes(rec.payloads[i])) {
+			t.Fatalf("expected %v to be published, got %v", expected, rec.payloads)
+		}
+	}
+	if n := d.SuppressedCount(); n != 2 {
+		t.Fatalf("expected 2 suppressed, got %d", n)
+	}
+}
+
+func toBytes(p interface{}) []byte {
+	if s, ok := p.(string); ok {
+		return []byte(s)
+	}
+	return p.([]byte)
+}
@@ -0,0 +1,74 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_dedupWindow(t *testing.T) {
+	d := newDedupWindow(2, time.Hour)
+
+	if d.isDuplicate("a", []byte("1")) {
+		t.Fatalf("first message reported as duplicate")
+	}
+	if !d.isDuplicate("a", []byte("1")) {
+		t.Fatalf("repeated message not reported as duplicate")
+	}
+	if d.isDuplicate("b", []byte("1")) {
+		t.Fatalf("same payload on a different topic reported as duplicate")
+	}
+	if d.isDuplicate("a/", []byte("1")) || d.isDuplicate("a", []byte("/1")) {
+		t.Fatalf("different topic/payload split reported as duplicate")
+	}
+	// The window only holds 2 entries so "a" "1" has been evicted
+	if d.isDuplicate("a", []byte("1")) {
+		t.Fatalf("evicted message reported as duplicate")
+	}
+	if d.duplicatesDropped() != 1 {
+		t.Fatalf("expected 1 duplicate, got %d", d.duplicatesDropped())
+	}
+}
+
+func Test_dedupWindow_ttl(t *testing.T) {
+	d := newDedupWindow(10, 50*time.Millisecond)
+
+	d.isDuplicate("a", []byte("1"))
+	time.Sleep(100 * time.Millisecond)
+	if d.isDuplicate("a", []byte("1")) {
+		t.Fatalf("message outside of ttl reported as duplicate")
+	}
+}
+
+func Test_DeduplicationWindow(t *testing.T) {
+	received := make(chan Message, 3)
+	ops := NewClientOptions().SetKeepAlive(0).SetDeduplicationWindow(10, time.Minute).
+		SetDefaultPublishHandler(func(c Client, m Message) { received <- m })
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	for _, payload := range []string{"dup", "dup", "other"} {
+		pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		pub.TopicName = "test/dedup"
+		pub.Payload = []byte(payload)
+		if err := pub.Write(broker); err != nil {
+			t.Fatalf("error writing publish: %v", err)
+		}
+	}
+
+	for _, exp := range []string{"dup", "other"} {
+		select {
+		case m := <-received:
+			if string(m.Payload()) != exp {
+				t.Fatalf("expected %q, got %q", exp, m.Payload())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %q not received", exp)
+		}
+	}
+	if c.DuplicatesDropped() != 1 {
+		t.Fatalf("expected 1 duplicate dropped, got %d", c.DuplicatesDropped())
+	}
+}
@@ -0,0 +1,49 @@
+package mqtt
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_ConnectionEventLog(t *testing.T) {
+	c := NewClient(NewClientOptions().SetConnectionEventLog(3)).(*client)
+	if events := c.RecentConnectionEvents(); len(events) != 0 {
+		t.Fatalf("expected empty log, got %v", events)
+	}
+
+	ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+	ack.MessageID = 5
+	c.events.publish(ConnectedEvent{})
+	c.events.publish(PacketSentEvent{Packet: packets.NewControlPacket(packets.Pingreq)})
+	c.events.publish(PacketReceivedEvent{Packet: ack})
+	c.events.publish(DisconnectedEvent{Err: errors.New("pingresp not received")})
+
+	events := c.RecentConnectionEvents()
+	expected := []struct{ eventType, detail string }{
+		{EventTypeKeepAlive, "PINGREQ"},
+		{EventTypePacketReceived, "PUBACK id=5"},
+		{EventTypeDisconnected, "pingresp not received"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %v", len(expected), events)
+	}
+	for i, e := range expected {
+		if events[i].EventType != e.eventType || !strings.Contains(events[i].Detail, e.detail) {
+			t.Errorf("event %d: expected %s %q, got %s %q", i, e.eventType, e.detail, events[i].EventType, events[i].Detail)
+		}
+		if events[i].Timestamp.IsZero() || time.Since(events[i].Timestamp) > time.Minute {
+			t.Errorf("event %d has unexpected timestamp %v", i, events[i].Timestamp)
+		}
+	}
+}
+
+func Test_ConnectionEventLog_disabled(t *testing.T) {
+	c := NewClient(NewClientOptions()).(Diagnostics)
+	if events := c.RecentConnectionEvents(); events != nil {
+		t.Fatalf("expected nil when disabled, got %v", events)
+	}
+}
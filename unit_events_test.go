@@ -0,0 +1,189 @@
+package mqtt
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// eventRecorder collects events published on a client's event bus
+type eventRecorder struct {
+	sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) handler(e Event) {
+	r.Lock()
+	defer r.Unlock()
+	r.events = append(r.events, e)
+}
+
+// waitFor waits until an event for which match returns true has been recorded
+func (r *eventRecorder) waitFor(t *testing.T, desc string, match func(Event) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r.Lock()
+		for _, e := range r.events {
+			if match(e) {
+				r.Unlock()
+				return
+			}
+		}
+		r.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("event not published: %s", desc)
+}
+
+func Test_eventBus(t *testing.T) {
+	var b eventBus
+	var got []Event
+	b.subscribe(func(e Event) { got = append(got, e) })
+	b.subscribe(func(e Event) { got = append(got, e) })
+
+	b.publish(InflightChangedEvent{Inflight: 3})
+	if len(got) != 2 {
+		t.Fatalf("expected event to be passed to both handlers, got %d calls", len(got))
+	}
+	if ev, ok := got[0].(InflightChangedEvent); !ok || ev.Inflight != 3 {
+		t.Fatalf("unexpected event %#v", got[0])
+	}
+}
+
+func Test_EventHandler(t *testing.T) {
+	rec := &eventRecorder{}
+	lost := make(chan error, 1)
+	ops := NewClientOptions().SetKeepAlive(0).SetEventHandler(rec.handler).SetAutoReconnect(false).
+		SetConnectionLostHandler(func(c Client, err error) { lost <- err })
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+
+	rec.waitFor(t, "connected", func(e Event) bool {
+		_, ok := e.(ConnectedEvent)
+		return ok
+	})
+
+	c.Subscribe("test/events", 1, nil)
+	rec.waitFor(t, "inflight changed", func(e Event) bool {
+		ev, ok := e.(InflightChangedEvent)
+		return ok && ev.Inflight == 1
+	})
+
+	ackSubscribe(t, broker, 1)
+	rec.waitFor(t, "subscribe sent", func(e Event) bool {
+		ev, ok := e.(PacketSentEvent)
+		_, isSub := ev.Packet.(*packets.SubscribePacket)
+		return ok && isSub
+	})
+	rec.waitFor(t, "suback received", func(e Event) bool {
+		ev, ok := e.(PacketReceivedEvent)
+		_, isSuback := ev.Packet.(*packets.SubackPacket)
+		return ok && isSuback
+	})
+	rec.waitFor(t, "inflight changed", func(e Event) bool {
+		ev, ok := e.(InflightChangedEvent)
+		return ok && ev.Inflight == 0
+	})
+
+	broker.Close()
+	rec.waitFor(t, "disconnected", func(e Event) bool {
+		ev, ok := e.(DisconnectedEvent)
+		return ok && ev.Err != nil
+	})
+	select {
+	case err := <-lost:
+		if err == nil {
+			t.Fatalf("connection lost handler called with nil error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection lost handler not called")
+	}
+}
+
+func Test_callbackEvents_disconnect(t *testing.T) {
+	called := make(chan struct{}, 1)
+	ops := NewClientOptions().SetConnectionLostHandler(func(c Client, err error) { called <- struct{}{} })
+	c := NewClient(ops).(*client)
+
+	// A requested disconnect must not call the connection lost handler
+	c.events.publish(DisconnectedEvent{})
+	c.events.publish(DisconnectedEvent{Err: errors.New("lost")})
+	select {
+	case <-called:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("connection lost handler not called")
+	}
+	select {
+	case <-called:
+		t.Fatalf("connection lost handler called for requested disconnect")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func Test_asyncEventHandler(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var got []int
+	a := newAsyncEventHandler(func(e Event) {
+		<-release
+		mu.Lock()
+		got = append(got, e.(InflightChangedEvent).Inflight)
+		mu.Unlock()
+	}, 2)
+
+	// The handler is blocked so handle must not block; events beyond the queue size are dropped
+	done := make(chan struct{})
+	go func() {
+		for i := 1; i <= 10; i++ {
+			a.handle(InflightChangedEvent{Inflight: i})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("handle blocked on a slow handler")
+	}
+	if atomic.LoadUint64(&a.dropped) == 0 {
+		t.Fatalf("expected events to be dropped")
+	}
+	close(release)
+
+	// Once the queue has room events are accepted again
+	deadline := time.Now().Add(5 * time.Second)
+	for len(a.queue) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue not drained")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	a.handle(InflightChangedEvent{Inflight: 11})
+	for {
+		mu.Lock()
+		n := len(got)
+		last := 0
+		if n > 0 {
+			last = got[n-1]
+		}
+		mu.Unlock()
+		if last == 11 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("events not delivered, got %v", got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i := 1; i < len(got); i++ {
+		if got[i] <= got[i-1] {
+			t.Fatalf("events delivered out of order: %v", got)
+		}
+	}
+}
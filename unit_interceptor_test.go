@@ -0,0 +1,127 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+type ctxKey string
+
+func Test_ChainPublishInterceptors(t *testing.T) {
+	var calls []string
+	record := func(name string) PublishInterceptor {
+		return func(ctx context.Context, req *PublishRequest, next PublishHandler) error {
+			calls = append(calls, name)
+			req.Topic += "/" + name
+			return next(context.WithValue(ctx, ctxKey(name), true), req)
+		}
+	}
+	chain := ChainPublishInterceptors(record("a"), record("b"))
+	err := chain(context.Background(), &PublishRequest{Topic: "t"}, func(ctx context.Context, req *PublishRequest) error {
+		if ctx.Value(ctxKey("a")) == nil || ctx.Value(ctxKey("b")) == nil {
+			t.Errorf("context values were not propagated")
+		}
+		if req.Topic != "t/a/b" {
+			t.Errorf("unexpected topic %s", req.Topic)
+		}
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(calls, []string{"a", "b"}) {
+		t.Fatalf("unexpected result: %v, calls %v", err, calls)
+	}
+}
+
+func Test_PublishInterceptor(t *testing.T) {
+	errBlocked := errors.New("blocked")
+	ops := NewClientOptions().SetKeepAlive(0).SetPublishInterceptor(ChainPublishInterceptors(
+		func(ctx context.Context, req *PublishRequest, next PublishHandler) error {
+			if req.Topic == "blocked" {
+				return errBlocked
+			}
+			return next(ctx, req)
+		},
+		func(ctx context.Context, req *PublishRequest, next PublishHandler) error {
+			req.Topic = "prefix/" + req.Topic
+			return next(ctx, req)
+		},
+	))
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	if token := c.Publish("blocked", 0, false, "x"); token.Error() != errBlocked {
+		t.Fatalf("expected the interceptor error, got %v", token.Error())
+	}
+
+	go c.Publish("a", 0, false, "x")
+	cp, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	if topic := cp.(*packets.PublishPacket).TopicName; topic != "prefix/a" {
+		t.Fatalf("expected topic prefix/a, got %s", topic)
+	}
+}
+
+// upperMessage wraps a Message, replacing its payload
+type upperMessage struct {
+	Message
+	payload []byte
+}
+
+func (m *upperMessage) Payload() []byte { return m.payload }
+
+func Test_ReceiveInterceptor(t *testing.T) {
+	received := make(chan string, 2)
+	router := newRouter()
+	router.addRouteWithContext("#", func(ctx context.Context, c Client, m Message) {
+		if ctx.Value(ctxKey("intercepted")) == nil {
+			t.Errorf("context from interceptor not passed to handler")
+		}
+		received <- string(m.Payload())
+	})
+
+	cl := &client{oboundP: make(chan *PacketAndToken, 100)}
+	cl.options.ReceiveInterceptor = ChainReceiveInterceptors(
+		func(ctx context.Context, msg Message, next ReceiveHandler) error {
+			if msg.Topic() == "drop" {
+				return nil
+			}
+			return next(context.WithValue(ctx, ctxKey("intercepted"), true), msg)
+		},
+		func(ctx context.Context, msg Message, next ReceiveHandler) error {
+			return next(ctx, &upperMessage{Message: msg, payload: append([]byte("intercepted "), msg.Payload()...)})
+		},
+	)
+
+	msgs := make(chan *packets.PublishPacket)
+	stopped := make(chan bool)
+	go func() {
+		router.matchAndDispatch(context.Background(), msgs, 1, cl)
+		stopped <- true
+	}()
+	for _, topic := range []string{"drop", "keep"} {
+		pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		pub.TopicName = topic
+		pub.Payload = []byte(topic)
+		msgs <- pub
+	}
+	close(msgs)
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("matchAndDispatch should have exited")
+	}
+	if len(received) != 1 {
+		t.Fatalf("expected one message to be delivered, got %d", len(received))
+	}
+	if p := <-received; p != "intercepted keep" {
+		t.Fatalf("unexpected payload %q", p)
+	}
+}
@@ -0,0 +1,54 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_LVC(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	l := NewLVC(c)
+	l.WatchMultiple([]string{"sensors/+"})
+	ackSubscribe(t, broker)
+
+	send := func(topic, payload string, retained bool) {
+		pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		pub.TopicName = topic
+		pub.Retain = retained
+		pub.Payload = []byte(payload)
+		if err := pub.Write(broker); err != nil {
+			t.Fatalf("error writing publish: %v", err)
+		}
+	}
+	waitFor := func(topic, payload string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			m, ok := l.Get(topic)
+			if (payload == "" && !ok) || (ok && string(m.Payload()) == payload) {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %q for %s, got %v %v", payload, topic, m, ok)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	send("sensors/a", "1", true)
+	send("sensors/b", "2", false)
+	send("sensors/a", "3", false)
+	waitFor("sensors/a", "3")
+	waitFor("sensors/b", "2")
+
+	// An empty retained message clears the value
+	send("sensors/a", "", true)
+	waitFor("sensors/a", "")
+	if _, ok := l.Get("sensors/c"); ok {
+		t.Fatalf("unexpected value for topic not received")
+	}
+}
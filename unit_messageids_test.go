@@ -18,6 +18,9 @@ import (
 	"fmt"
 	"log"
 	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
 )
 
 func Test_getID(t *testing.T) {
@@ -72,3 +75,28 @@ func Test_noFreeID(t *testing.T) {
 		t.Errorf("shouldn't be any mids left")
 	}
 }
+
+func Test_ifHeld(t *testing.T) {
+	mids := &messageIds{index: make(map[uint16]tokenCompletor)}
+	token := newToken(packets.Publish).(*PublishToken)
+	id := mids.getID(token)
+
+	released := make(chan bool)
+	called := mids.ifHeld(id, token, func() {
+		go func() { released <- mids.release(id, token) }()
+		select {
+		case <-released:
+			t.Fatalf("release was not held up by ifHeld")
+		case <-time.After(20 * time.Millisecond):
+		}
+	})
+	if !called {
+		t.Fatalf("expected fn to be called while the id is held")
+	}
+	if !<-released {
+		t.Fatalf("expected release to succeed")
+	}
+	if mids.ifHeld(id, token, func() { t.Fatalf("fn called after release") }) {
+		t.Fatalf("expected ifHeld to return false after release")
+	}
+}
@@ -0,0 +1,47 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_PacketMetrics(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	for i := 0; i < 2; i++ {
+		token := c.Publish("a", 0, false, "x")
+		if _, err := packets.ReadPacket(broker); err != nil {
+			t.Fatalf("error reading publish: %v", err)
+		}
+		token.Wait()
+	}
+	if err := packets.NewControlPacket(packets.Pingresp).Write(broker); err != nil {
+		t.Fatalf("error writing pingresp: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.PacketMetrics()["PINGRESP"].Received != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("PINGRESP not counted: %v", c.PacketMetrics())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	m := c.PacketMetrics()
+	if m["PUBLISH"] != (PacketTypeMetric{Sent: 2}) {
+		t.Fatalf("expected 2 PUBLISH sent, got %+v", m["PUBLISH"])
+	}
+	if len(m) != len(packets.PacketNames) {
+		t.Fatalf("expected an entry for every packet type, got %v", m)
+	}
+
+	c.ResetPacketMetrics()
+	for name, v := range c.PacketMetrics() {
+		if v != (PacketTypeMetric{}) {
+			t.Fatalf("expected %s to be reset, got %+v", name, v)
+		}
+	}
+}
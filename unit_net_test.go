@@ -0,0 +1,101 @@
+package mqtt
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_startIncoming_readStall(t *testing.T) {
+	conn, broker := net.Pipe()
+	defer conn.Close()
+	defer broker.Close()
+
+	ibound := startIncoming(conn, 50*time.Millisecond, 0)
+
+	// An idle connection must not be treated as stalled
+	select {
+	case ib := <-ibound:
+		t.Fatalf("unexpected inbound on idle connection: %v", ib.err)
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	// Send the first byte of a PINGRESP then stop
+	if _, err := broker.Write([]byte{0xD0}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ib := <-ibound:
+		if ib.err != ErrReadStall {
+			t.Fatalf("expected ErrReadStall, got %v", ib.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stall was not detected")
+	}
+}
+
+func Test_startIncoming_slowPacket(t *testing.T) {
+	conn, broker := net.Pipe()
+	defer conn.Close()
+	defer broker.Close()
+
+	ibound := startIncoming(conn, 100*time.Millisecond, 0)
+
+	// Each byte arrives within the stall timeout so the packet should be read successfully even though
+	// the packet as a whole takes longer than the timeout
+	go func() {
+		for _, b := range []byte{0x90, 0x03, 0x00, 0x01, 0x00} { // SUBACK id 1 granted QoS 0
+			time.Sleep(40 * time.Millisecond)
+			if _, err := broker.Write([]byte{b}); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case ib := <-ibound:
+		if ib.err != nil {
+			t.Fatalf("unexpected error: %v", ib.err)
+		}
+		if ib.cp.Details().MessageID != 1 {
+			t.Fatalf("unexpected packet received: %s", ib.cp.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("packet was not received")
+	}
+}
+
+func Test_startIncoming_packetReadTimeout(t *testing.T) {
+	conn, broker := net.Pipe()
+	defer conn.Close()
+	defer broker.Close()
+
+	ibound := startIncoming(conn, 0, 100*time.Millisecond)
+
+	// An idle connection must not time out
+	select {
+	case ib := <-ibound:
+		t.Fatalf("unexpected inbound on idle connection: %v", ib.err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Trickle a SUBACK in; every byte is prompt but the packet as a whole takes too long
+	go func() {
+		for _, b := range []byte{0x90, 0x03, 0x00, 0x01, 0x00} {
+			if _, err := broker.Write([]byte{b}); err != nil {
+				return
+			}
+			time.Sleep(40 * time.Millisecond)
+		}
+	}()
+
+	select {
+	case ib := <-ibound:
+		if ib.err != ErrPacketReadTimeout {
+			t.Fatalf("expected ErrPacketReadTimeout, got %v (%v)", ib.err, ib.cp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout was not detected")
+	}
+}
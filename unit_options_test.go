@@ -124,3 +124,40 @@ func Test_OnConnectionLost(t *testing.T) {
 		t.Fatalf("client options.onconnlost was nil")
 	}
 }
+
+func Test_SetInternalBufferSize(t *testing.T) {
+	o := NewClientOptions().SetInternalBufferSize(BufferSizeConfig{PublishQueue: 10, SubscribeQueue: 5, MsgReceiveQueue: 20})
+	c := NewClient(o).(*client)
+
+	if cap(c.obound) != 10 {
+		t.Fatalf("publish queue capacity is %d, expected 10", cap(c.obound))
+	}
+	if cap(c.oboundP) != 5 {
+		t.Fatalf("subscribe queue capacity is %d, expected 5", cap(c.oboundP))
+	}
+	if r := c.OptionsReader(); r.BufferSizes().MsgReceiveQueue != 20 {
+		t.Fatalf("receive queue size is %d, expected 20", r.BufferSizes().MsgReceiveQueue)
+	}
+}
+
+func Test_handlerConcurrency(t *testing.T) {
+	tests := []struct {
+		name string
+		o    *ClientOptions
+		want int
+	}{
+		{"default", NewClientOptions(), 1},
+		{"unordered", NewClientOptions().SetOrderMatters(false), 0},
+		{"pool", NewClientOptions().SetHandlerConcurrency(4), 4},
+		{"pool unordered", NewClientOptions().SetOrderMatters(false).SetHandlerConcurrency(4), 4},
+		{"sequential unordered", NewClientOptions().SetHandlerConcurrency(1).SetOrderMatters(false), 1},
+		{"goroutine per message ordered", NewClientOptions().SetHandlerConcurrency(0), 0},
+		{"zero value ordered", &ClientOptions{Order: true}, 1},
+		{"zero value", &ClientOptions{}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.o.handlerConcurrency(); got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
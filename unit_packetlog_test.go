@@ -0,0 +1,78 @@
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Println(v ...interface{}) {
+	l.mu.Lock()
+	l.lines = append(l.lines, strings.TrimSpace(fmt.Sprintln(v...)))
+	l.mu.Unlock()
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.Println(fmt.Sprintf(format, v...))
+}
+
+func Test_PacketLogLevel(t *testing.T) {
+	l := &recordingLogger{}
+	PACKET = l
+	defer func() { PACKET = NOOPLogger{} }()
+
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.ClientIdentifier = "id"
+	connect.UsernameFlag, connect.Username = true, "user"
+	connect.PasswordFlag, connect.Password = true, []byte("secret")
+	refused := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	refused.ReturnCode = packets.ErrRefusedNotAuthorised
+	suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+	suback.ReturnCodes = []byte{1}
+	publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	publish.TopicName, publish.Qos, publish.MessageID, publish.Payload = "a/b", 1, 7, []byte("payload")
+	ping := packets.NewControlPacket(packets.Pingreq)
+
+	all := []packets.ControlPacket{connect, refused, suback, publish, ping}
+	tests := []struct {
+		level    PacketLogLevel
+		expected int
+	}{
+		{LogNone, 0},
+		{LogErrors, 1},
+		{LogConnections, 2},
+		{LogSubscriptions, 3},
+		{LogPublish, 4},
+		{LogAll, 5},
+	}
+	for _, tt := range tests {
+		l.lines = nil
+		c := NewClient(NewClientOptions().SetPacketLogLevel(tt.level)).(*client)
+		for _, cp := range all {
+			c.events.publish(PacketSentEvent{Packet: cp})
+		}
+		if len(l.lines) != tt.expected {
+			t.Errorf("level %d: expected %d lines, got %v", tt.level, tt.expected, l.lines)
+		}
+	}
+
+	out := strings.Join(l.lines, "\n")
+	for _, s := range []string{`CONNECT clientid="id"`, `username="user"`, "returncode=5", `PUBLISH id=7 topic="a/b" qos=1`, "PINGREQ"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected %q in log:\n%s", s, out)
+		}
+	}
+	for _, s := range []string{"secret", "payload"} {
+		if strings.Contains(out, s) {
+			t.Errorf("%q should not be logged:\n%s", s, out)
+		}
+	}
+}
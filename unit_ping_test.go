@@ -17,6 +17,7 @@ package mqtt
 import (
 	"bytes"
 	"testing"
+	"time"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
 )
@@ -63,3 +64,37 @@ func Test_DecodeMessage_pingresp(t *testing.T) {
 		t.Errorf("DecodeMessage ping response wrong rem len: %d", presp.(*packets.PingrespPacket).RemainingLength)
 	}
 }
+
+// With KeepAlive disabled no PINGREQ should be sent however long the connection is idle
+func Test_KeepAliveDisabled(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	received := make(chan packets.ControlPacket, 1)
+	go func() {
+		if p, err := packets.ReadPacket(broker); err == nil {
+			received <- p
+		}
+	}()
+	select {
+	case p := <-received:
+		t.Fatalf("unexpected packet sent: %s", p)
+	case <-time.After(1500 * time.Millisecond):
+	}
+}
+
+// A KeepAlive of 1 second previously resulted in a zero ticker interval (and a panic)
+func Test_KeepAliveOneSecond(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(time.Second))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	p, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading packet: %v", err)
+	}
+	if _, ok := p.(*packets.PingreqPacket); !ok {
+		t.Fatalf("expected PINGREQ, got %s", p)
+	}
+}
@@ -0,0 +1,103 @@
+package mqtt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// selfSignedCert generates a self-signed certificate for 127.0.0.1
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test broker"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// handshake performs a TLS handshake against a server presenting cert using the client config
+func handshake(t *testing.T, cert tls.Certificate, conf *tls.Config) error {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		c.(*tls.Conn).Handshake()
+		c.Close()
+	}()
+	c, err := tls.Dial("tcp", l.Addr().String(), conf)
+	if err != nil {
+		return err
+	}
+	return c.Close()
+}
+
+func Test_CertificatePins(t *testing.T) {
+	cert := selfSignedCert(t)
+	other := selfSignedCert(t)
+
+	o := NewClientOptions().SetTLSConfig(&tls.Config{InsecureSkipVerify: true}).
+		SetCertificatePins([][]byte{GenerateCertificatePin(cert.Leaf)})
+	conf := NewClient(o).(*client).options.TLSConfig
+
+	if o.TLSConfig.VerifyPeerCertificate != nil {
+		t.Fatalf("the TLSConfig passed in should not be modified")
+	}
+	if err := handshake(t, cert, conf); err != nil {
+		t.Fatalf("handshake with pinned certificate failed: %v", err)
+	}
+	if err := handshake(t, other, conf); err == nil {
+		t.Fatalf("handshake with a certificate that is not pinned succeeded")
+	}
+}
+
+func Test_AddTLSCACert(t *testing.T) {
+	cert := selfSignedCert(t)
+	other := selfSignedCert(t)
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+
+	base := &tls.Config{ServerName: "127.0.0.1"}
+	for _, o := range []*ClientOptions{
+		NewClientOptions().SetTLSConfig(base).AddTLSCACert(caPEM),
+		NewClientOptions().SetTLSConfig(base).UseTLSWithSystemCerts().AddTLSCACert(caPEM),
+	} {
+		conf := NewClient(o).(*client).options.TLSConfig
+		if base.RootCAs != nil {
+			t.Fatalf("the TLSConfig passed in should not be modified")
+		}
+		if err := handshake(t, cert, conf); err != nil {
+			t.Fatalf("handshake with added CA certificate failed: %v", err)
+		}
+		if err := handshake(t, other, conf); err == nil {
+			t.Fatalf("handshake with an untrusted certificate succeeded")
+		}
+	}
+}
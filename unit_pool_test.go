@@ -0,0 +1,72 @@
+package mqtt
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_NewPublishPool(t *testing.T) {
+	p := NewPublishPool(3, NewClientOptions().SetClientID("pool"))
+	cs := p.Clients()
+	if len(cs) != 3 {
+		t.Fatalf("expected 3 clients, got %d", len(cs))
+	}
+	for i, c := range cs {
+		r := c.OptionsReader()
+		if id := r.ClientID(); id != fmt.Sprintf("pool-%d", i) {
+			t.Errorf("unexpected client id %q", id)
+		}
+	}
+	if cs[0].(*client).persist == cs[1].(*client).persist {
+		t.Errorf("clients should not share a store")
+	}
+	if err := p.Publish("a", 0, false, "x").Error(); err != ErrNotConnected {
+		t.Errorf("expected ErrNotConnected, got %v", err)
+	}
+	if err := p.Subscribe("a", 0, nil).Error(); err != ErrNotSupported {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+func Test_PublishPool_LeastLoaded(t *testing.T) {
+	var clients []*client
+	var brokers []net.Conn
+	for i := 0; i < 2; i++ {
+		c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+		defer broker.Close()
+		defer c.forceDisconnect()
+		clients = append(clients, c)
+		brokers = append(brokers, broker)
+	}
+	p := &PublishPool{clients: clients}
+
+	// Neither broker acknowledges so each QoS 1 publish adds to the load of the client used
+	received := make(chan int, 4)
+	for i, b := range brokers {
+		go func(i int, b net.Conn) {
+			for {
+				if _, err := packets.ReadPacket(b); err != nil {
+					return
+				}
+				received <- i
+			}
+		}(i, b)
+	}
+	for i := 0; i < 4; i++ {
+		p.Publish("a", 1, false, "x")
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("publish %d not received", i)
+		}
+	}
+	for i, c := range clients {
+		if n := c.pendingPublishes(); n != 2 {
+			t.Errorf("expected client %d to have 2 pending publishes, got %d", i, n)
+		}
+	}
+}
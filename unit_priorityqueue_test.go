@@ -0,0 +1,73 @@
+package mqtt
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_PriorityPublish(t *testing.T) {
+	o := NewClientOptions().SetKeepAlive(0)
+	o.BufferSizes.PublishQueue = 1
+	c, broker := newPipeClient(o)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	// The broker is not reading so the publishes back up
+	const low = 10
+	for i := 0; i < low; i++ {
+		c.PriorityPublish(0, "a", 0, false, fmt.Sprintf("low%d", i))
+	}
+	time.Sleep(50 * time.Millisecond)
+	high := c.PriorityPublish(10, "a", 0, false, "high")
+
+	var order []string
+	for len(order) < low+1 {
+		broker.SetReadDeadline(time.Now().Add(5 * time.Second))
+		cp, err := packets.ReadPacket(broker)
+		if err != nil {
+			t.Fatalf("error reading publish (after %v): %v", order, err)
+		}
+		order = append(order, string(cp.(*packets.PublishPacket).Payload))
+	}
+	if !high.WaitTimeout(5*time.Second) || high.Error() != nil {
+		t.Fatalf("high priority publish failed: %v", high.Error())
+	}
+
+	next := 0
+	highAt := -1
+	for i, p := range order {
+		if p == "high" {
+			highAt = i
+			continue
+		}
+		if p != fmt.Sprintf("low%d", next) {
+			t.Fatalf("publishes of the same priority out of order: %v", order)
+		}
+		next++
+	}
+	if highAt < 0 || highAt == len(order)-1 {
+		t.Fatalf("high priority publish not sent ahead of waiting publishes: %v", order)
+	}
+}
+
+func Test_PriorityPublish_Immediate(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	// While there is no backlog publishes are sent in the order made, regardless of priority
+	for i := 0; i < 3; i++ {
+		token := c.PriorityPublish(i, "a", 0, false, fmt.Sprint(i))
+		cp, err := packets.ReadPacket(broker)
+		if err != nil {
+			t.Fatalf("error reading publish: %v", err)
+		}
+		if p := string(cp.(*packets.PublishPacket).Payload); p != fmt.Sprint(i) {
+			t.Fatalf("expected payload %d, got %s", i, p)
+		}
+		token.Wait()
+	}
+}
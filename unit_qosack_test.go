@@ -0,0 +1,89 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_QoSAckCallback(t *testing.T) {
+	type ack struct {
+		stage QoSStage
+		id    uint16
+	}
+	acks := make(chan ack, 4)
+	ops := NewClientOptions().SetKeepAlive(0).SetQoSAckCallback(func(stage QoSStage, id uint16) {
+		acks <- ack{stage, id}
+	})
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	// QoS 2 publish from the client: PUBREC and PUBCOMP are received
+	token := c.Publish("test/qos2", 2, false, "x")
+	p, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	id := p.Details().MessageID
+	rec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+	rec.MessageID = id
+	if err := rec.Write(broker); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := packets.ReadPacket(broker); err != nil { // PUBREL
+		t.Fatalf("error reading pubrel: %v", err)
+	}
+	comp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+	comp.MessageID = id
+	if err := comp.Write(broker); err != nil {
+		t.Fatal(err)
+	}
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("publish did not complete")
+	}
+
+	for _, exp := range []ack{{StagePUBREC, id}, {StagePUBCOMP, id}} {
+		select {
+		case got := <-acks:
+			if got != exp {
+				t.Fatalf("expected %s %d, got %s %d", exp.stage, exp.id, got.stage, got.id)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("callback not called for %s", exp.stage)
+		}
+	}
+}
+
+func Test_QoSAckCallback_slow(t *testing.T) {
+	release := make(chan struct{})
+	ops := NewClientOptions().SetKeepAlive(0).SetQoSAckCallback(func(stage QoSStage, id uint16) {
+		<-release
+	})
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+	defer c.forceDisconnect()
+	defer close(release)
+
+	// A callback that does not return must not hold up the processing of acknowledgements
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		token := c.Publish("test/qos1", 1, false, "x")
+		p, err := packets.ReadPacket(broker)
+		if err != nil {
+			t.Fatalf("error reading publish: %v", err)
+		}
+		ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+		ack.MessageID = p.Details().MessageID
+		if err := ack.Write(broker); err != nil {
+			t.Fatal(err)
+		}
+		if !token.WaitTimeout(5 * time.Second) {
+			t.Fatalf("publish did not complete")
+		}
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("acknowledgements delayed by the callback, took %v", d)
+	}
+}
@@ -0,0 +1,39 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_connectLimiter(t *testing.T) {
+	l := newConnectLimiter(10, 2)
+
+	// The burst is available immediately
+	for i := 0; i < 2; i++ {
+		if d := l.reserve(); d != 0 {
+			t.Fatalf("attempt %d: expected no wait, got %s", i, d)
+		}
+	}
+	// Then each attempt is spaced by 1/rps
+	if d := l.reserve(); d < 90*time.Millisecond || d > 100*time.Millisecond {
+		t.Fatalf("expected a wait of about 100ms, got %s", d)
+	}
+	if d := l.reserve(); d < 190*time.Millisecond || d > 200*time.Millisecond {
+		t.Fatalf("expected a wait of about 200ms, got %s", d)
+	}
+}
+
+func Test_SetConnectRateLimit(t *testing.T) {
+	o := NewClientOptions().SetConnectRateLimit(5, 1)
+	if o.connectLimiter == nil {
+		t.Fatalf("limiter not set")
+	}
+	c1 := NewClient(o).(*client)
+	c2 := NewClient(o).(*client)
+	if c1.options.connectLimiter != c2.options.connectLimiter {
+		t.Fatalf("clients created from the same options should share a limiter")
+	}
+	if o.SetConnectRateLimit(0, 1).connectLimiter != nil {
+		t.Fatalf("limiter not removed")
+	}
+}
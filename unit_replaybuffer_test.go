@@ -0,0 +1,63 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_ReplayBuffer(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	rb := NewReplayBuffer(c, 10)
+	rb.Watch("a/#", 2)
+
+	// subscribe sends a SUBSCRIBE through rb and acknowledges it
+	subscribe := func(topic string, cb MessageHandler) {
+		token := rb.Subscribe(topic, 0, cb)
+		ackSubscribe(t, broker, 0)
+		if !token.WaitTimeout(5 * time.Second) {
+			t.Fatalf("subscribe did not complete")
+		}
+	}
+	next := func(ch chan string) string {
+		select {
+		case p := <-ch:
+			return p
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message not received")
+		}
+		return ""
+	}
+
+	first := make(chan string, 10)
+	subscribe("a/#", func(c Client, m Message) { first <- m.Topic() + ":" + string(m.Payload()) })
+	for _, p := range []struct{ topic, payload string }{{"a/1", "1"}, {"a/1", "2"}, {"a/1", "3"}, {"a/2", "4"}} {
+		pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		pub.TopicName = p.topic
+		pub.Payload = []byte(p.payload)
+		if err := pub.Write(broker); err != nil {
+			t.Fatalf("error writing publish: %v", err)
+		}
+		if got, exp := next(first), p.topic+":"+p.payload; got != exp {
+			t.Fatalf("expected %s, got %s", exp, got)
+		}
+	}
+
+	// A new subscriber receives the last two messages on each matching topic
+	second := make(chan string, 10)
+	subscribe("a/+", func(c Client, m Message) { second <- m.Topic() + ":" + string(m.Payload()) })
+	for _, exp := range []string{"a/1:2", "a/1:3", "a/2:4"} {
+		if got := next(second); got != exp {
+			t.Fatalf("expected replay of %s, got %s", exp, got)
+		}
+	}
+	select {
+	case p := <-second:
+		t.Fatalf("unexpected message %s", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
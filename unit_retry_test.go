@@ -0,0 +1,78 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_ExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff(100*time.Millisecond, time.Second)
+	for i, exp := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second} {
+		if d := b(i + 1); d != exp {
+			t.Fatalf("attempt %d: expected %v, got %v", i+1, exp, d)
+		}
+	}
+	if !DefaultRetryOn(ErrNoMessageIDsAvailable) || DefaultRetryOn(ErrNotConnected) {
+		t.Fatalf("unexpected DefaultRetryOn result")
+	}
+}
+
+func Test_PublishRetryPolicy(t *testing.T) {
+	errBusy := errors.New("busy")
+	var attempts int32
+	policy := &RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     func(int) time.Duration { return time.Millisecond },
+		RetryOn:     func(err error) bool { return err == errBusy },
+	}
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0).SetPublishRetryPolicy(policy).
+		SetPublishInterceptor(func(ctx context.Context, req *PublishRequest, next PublishHandler) error {
+			if atomic.AddInt32(&attempts, 1) < 3 {
+				return errBusy
+			}
+			return next(ctx, req)
+		}))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	token := c.Publish("test/retry", 0, false, "payload")
+	cp, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	if pub, ok := cp.(*packets.PublishPacket); !ok || pub.TopicName != "test/retry" {
+		t.Fatalf("unexpected packet %v", cp)
+	}
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("expected publish to succeed, got %v", token.Error())
+	}
+	if n := atomic.LoadInt32(&attempts); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+
+	// Attempts stop at MaxAttempts, returning the last error
+	atomic.StoreInt32(&attempts, -10)
+	token = c.Publish("test/retry", 0, false, "payload")
+	if !token.WaitTimeout(5*time.Second) || token.Error() != errBusy {
+		t.Fatalf("expected errBusy, got %v", token.Error())
+	}
+	if n := atomic.LoadInt32(&attempts); n != -7 {
+		t.Fatalf("expected 3 attempts, got %d", n+10)
+	}
+
+	// Errors that are not retried are returned straight away
+	c.options.PublishRetryPolicy.RetryOn = func(error) bool { return false }
+	atomic.StoreInt32(&attempts, 0)
+	token = c.Publish("test/retry", 0, false, "payload")
+	if !token.WaitTimeout(5*time.Second) || token.Error() != errBusy {
+		t.Fatalf("expected errBusy, got %v", token.Error())
+	}
+	if n := atomic.LoadInt32(&attempts); n != 1 {
+		t.Fatalf("expected 1 attempt, got %d", n)
+	}
+}
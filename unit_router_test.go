@@ -15,6 +15,9 @@
 package mqtt
 
 import (
+	"context"
+	"fmt"
+	"sync"
 	"testing"
 	"time"
 
@@ -296,7 +299,7 @@ func Test_MatchAndDispatch(t *testing.T) {
 
 	stopped := make(chan bool)
 	go func() {
-		router.matchAndDispatch(msgs, true, &client{oboundP: make(chan *PacketAndToken, 100)})
+		router.matchAndDispatch(context.Background(), msgs, 1, &client{oboundP: make(chan *PacketAndToken, 100)})
 		stopped <- true
 	}()
 	msgs <- pub
@@ -332,7 +335,7 @@ func Test_SharedSubscription_MatchAndDispatch(t *testing.T) {
 
 	stopped := make(chan bool)
 	go func() {
-		router.matchAndDispatch(msgs, true, &client{oboundP: make(chan *PacketAndToken, 100)})
+		router.matchAndDispatch(context.Background(), msgs, 1, &client{oboundP: make(chan *PacketAndToken, 100)})
 		stopped <- true
 	}()
 
@@ -350,3 +353,144 @@ func Test_SharedSubscription_MatchAndDispatch(t *testing.T) {
 	}
 
 }
+
+func Test_MatchAndDispatch_Pool(t *testing.T) {
+	started := make(chan bool)
+	release := make(chan bool)
+	cb := func(c Client, m Message) {
+		started <- true
+		<-release
+	}
+
+	msgs := make(chan *packets.PublishPacket)
+	router := newRouter()
+	router.addRoute("a", cb)
+
+	stopped := make(chan bool)
+	go func() {
+		router.matchAndDispatch(context.Background(), msgs, 3, &client{oboundP: make(chan *PacketAndToken, 100)})
+		stopped <- true
+	}()
+	go func() {
+		for i := 0; i < 3; i++ {
+			pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+			pub.TopicName = "a"
+			msgs <- pub
+		}
+		close(msgs)
+	}()
+
+	// All three handlers must be running at the same time
+	for i := 0; i < 3; i++ {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatalf("handler %d was not called concurrently", i)
+		}
+	}
+	close(release)
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Errorf("matchAndDispatch should have exited")
+	}
+}
+
+func Test_MatchAndDispatch_PerTopic(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string][]byte{}
+	cb := func(c Client, m Message) {
+		mu.Lock()
+		received[m.Topic()] = append(received[m.Topic()], m.Payload()[0])
+		mu.Unlock()
+	}
+
+	msgs := make(chan *packets.PublishPacket)
+	router := newRouter()
+	router.addRoute("#", cb)
+
+	stopped := make(chan bool)
+	go func() {
+		router.matchAndDispatch(context.Background(), msgs, PerTopicConcurrency, &client{oboundP: make(chan *PacketAndToken, 100)})
+		stopped <- true
+	}()
+	topics := []string{"a", "b", "c", "d"}
+	for i := 0; i < 100; i++ {
+		for _, topic := range topics {
+			pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+			pub.TopicName = topic
+			pub.Payload = []byte{byte(i)}
+			msgs <- pub
+		}
+	}
+	close(msgs)
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("matchAndDispatch should have exited")
+	}
+
+	// matchAndDispatch waits for the handlers so everything has been received
+	mu.Lock()
+	defer mu.Unlock()
+	for _, topic := range topics {
+		got := received[topic]
+		if len(got) != 100 {
+			t.Fatalf("expected 100 messages on %s, got %d", topic, len(got))
+		}
+		for i, b := range got {
+			if int(b) != i {
+				t.Fatalf("messages on %s out of order: %v", topic, got)
+			}
+		}
+	}
+}
+
+// Test_ConcurrentRouteChanges dispatches messages to 100 routes whilst routes are added and removed
+// (run with -race)
+func Test_ConcurrentRouteChanges(t *testing.T) {
+	cb := func(c Client, m Message) {}
+
+	router := newRouter()
+	for i := 0; i < 100; i++ {
+		router.addRoute(fmt.Sprintf("t/%d", i), cb)
+	}
+
+	msgs := make(chan *packets.PublishPacket)
+	stopped := make(chan bool)
+	go func() {
+		router.matchAndDispatch(context.Background(), msgs, 0, &client{oboundP: make(chan *PacketAndToken, 100)})
+		stopped <- true
+	}()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				topic := fmt.Sprintf("extra/%d/%d", g, i)
+				router.addRoute(topic, cb)
+				router.deleteRoute(topic)
+			}
+		}(g)
+	}
+	for i := 0; i < 1000; i++ {
+		pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		pub.TopicName = fmt.Sprintf("t/%d", i%100)
+		msgs <- pub
+	}
+	wg.Wait()
+	close(msgs)
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("matchAndDispatch should have exited")
+	}
+	if router.routes.Len() != 100 {
+		t.Fatalf("expected 100 routes, got %d", router.routes.Len())
+	}
+}
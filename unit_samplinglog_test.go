@@ -0,0 +1,55 @@
+package mqtt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_SamplingLogger(t *testing.T) {
+	c := NewClient(NewClientOptions()).(*client)
+	logger := &recordingLogger{}
+	s := NewSamplingLogger(c, 3, logger)
+
+	for i := 0; i < 7; i++ {
+		pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		pub.TopicName = fmt.Sprintf("sample/%d", i)
+		pub.Qos = 1
+		pub.MessageID = uint16(i + 1)
+		pub.Payload = []byte("payload")
+		c.events.publish(PacketReceivedEvent{Packet: pub})
+	}
+	// Other packets are not counted
+	c.events.publish(PacketReceivedEvent{Packet: packets.NewControlPacket(packets.Pingresp)})
+
+	if s.Received() != 7 {
+		t.Fatalf("expected 7 messages received, got %d", s.Received())
+	}
+	// Messages are logged from a separate goroutine
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		logger.mu.Lock()
+		n := len(logger.lines)
+		logger.mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if n > 2 || time.Now().After(deadline) {
+			t.Fatalf("expected 2 messages logged, got %q", logger.lines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(logger.lines[0], `topic="sample/2" qos=1 id=3`) || !strings.Contains(logger.lines[0], "len=7") {
+		t.Fatalf("unexpected log entry %q", logger.lines[0])
+	}
+
+	s.Stop()
+	s.Stop()
+	c.events.publish(PacketReceivedEvent{Packet: packets.NewControlPacket(packets.Publish)})
+	if s.Received() != 7 {
+		t.Fatalf("message counted after Stop")
+	}
+}
@@ -0,0 +1,49 @@
+//go:build go1.21
+// +build go1.21
+
+package mqtt
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_SlogHandler(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	logger := slog.New(NewSlogHandler(c, "logs", slog.LevelInfo)).With("svc", "test").WithGroup("req")
+	go func() {
+		logger.Debug("discarded")
+		logger.Warn("hello", "id", 7)
+	}()
+
+	cp, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	pub := cp.(*packets.PublishPacket)
+	if pub.Qos != 0 || pub.Retain {
+		t.Fatalf("expected a non-retained QoS 0 publish, got %s", pub.String())
+	}
+	if !strings.HasPrefix(pub.TopicName, "logs/warn/") || !strings.HasSuffix(pub.TopicName, ".Test_SlogHandler.func1") {
+		t.Fatalf("unexpected topic %s", pub.TopicName)
+	}
+	var rec struct {
+		Level string
+		Msg   string
+		Svc   string
+		Req   struct{ ID int }
+	}
+	if err := json.Unmarshal(pub.Payload, &rec); err != nil {
+		t.Fatalf("payload is not JSON: %v, %s", err, pub.Payload)
+	}
+	if rec.Level != "WARN" || rec.Msg != "hello" || rec.Svc != "test" || rec.Req.ID != 7 {
+		t.Fatalf("unexpected payload %s", pub.Payload)
+	}
+}
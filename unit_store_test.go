@@ -15,7 +15,9 @@
 package mqtt
 
 import (
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/eclipse/paho.mqtt.golang/packets"
 )
@@ -566,3 +568,57 @@ func Test_persistInbound_pingresp(t *testing.T) {
 		t.Fatalf("persistInbound in bad state")
 	}
 }
+
+func Test_MemoryStore_expiry(t *testing.T) {
+	store := NewMemoryStore()
+	store.Open()
+	defer store.Close()
+
+	keep := outboundKeyFromMID(1)
+	drop := outboundKeyFromMID(2)
+	for _, k := range []string{keep, drop} {
+		pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		pub.MessageID = mIDFromKey(k)
+		store.Put(k, pub)
+	}
+
+	var mu sync.Mutex
+	offered := make(map[string]int)
+	store.SetOnMessageExpired(func(key string, m packets.ControlPacket) bool {
+		mu.Lock()
+		offered[key]++
+		mu.Unlock()
+		return key != keep
+	})
+	store.SetMessageExpiryInterval(10 * time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := offered[keep]
+		mu.Unlock()
+		if n >= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expiry handler not called repeatedly for kept message")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	store.SetMessageExpiryInterval(0)
+
+	if store.Get(keep) == nil {
+		t.Fatalf("message kept by handler was removed")
+	}
+	if len(store.All()) != 1 {
+		t.Fatalf("expected expired message to be removed, have %v", store.All())
+	}
+
+	// A message stored after the cutoff is not expired
+	pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	store.Put(drop, pub)
+	store.expire(time.Now().Add(-time.Hour))
+	if store.Get(drop) == nil {
+		t.Fatalf("message removed before it expired")
+	}
+}
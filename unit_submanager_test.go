@@ -0,0 +1,101 @@
+package mqtt
+
+import (
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_FileSubscriptionManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqttsubs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := NewFileSubscriptionManager(filepath.Join(dir, "subs.json"))
+	subs, err := m.Load()
+	if err != nil || len(subs) != 0 {
+		t.Fatalf("expected no subscriptions from a missing file, got %v, %v", subs, err)
+	}
+	if err := m.Save(map[string]byte{"a/#": 1, "$share/g/b": 2}); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	subs, err = NewFileSubscriptionManager(filepath.Join(dir, "subs.json")).Load()
+	if err != nil || len(subs) != 2 || subs["a/#"] != 1 || subs["$share/g/b"] != 2 {
+		t.Fatalf("unexpected subscriptions loaded: %v, %v", subs, err)
+	}
+}
+
+func Test_SubscriptionManager_Connect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mqttsubs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m := NewFileSubscriptionManager(filepath.Join(dir, "subs.json"))
+	if err := m.Save(map[string]byte{"a": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, broker := net.Pipe()
+	defer broker.Close()
+	ops := NewClientOptions().SetKeepAlive(0).AddBroker("tcp://127.0.0.1:1883").SetAutoReconnect(false).
+		SetSubscriptionManager(m).
+		SetCustomOpenConnectionFn(func(uri *url.URL, options ClientOptions) (net.Conn, error) {
+			return conn, nil
+		})
+	c := NewClient(ops)
+	defer c.(*client).forceDisconnect()
+
+	token := c.Connect()
+	if _, err := packets.ReadPacket(broker); err != nil {
+		t.Fatalf("error reading connect: %v", err)
+	}
+	if err := packets.NewControlPacket(packets.Connack).Write(broker); err != nil {
+		t.Fatalf("error writing connack: %v", err)
+	}
+
+	// No session is present so the persisted subscription is restored
+	sub := ackSubscribe(t, broker, 1)
+	if len(sub.Topics) != 1 || sub.Topics[0] != "a" || sub.Qoss[0] != 1 {
+		t.Fatalf("expected subscription to a to be restored, got %s", sub.String())
+	}
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+
+	// New subscriptions are persisted once acknowledged
+	st := c.Subscribe("b", 2, nil)
+	ackSubscribe(t, broker, 2)
+	if !st.WaitTimeout(5 * time.Second) {
+		t.Fatalf("subscribe did not complete")
+	}
+	subs, err := m.Load()
+	if err != nil || len(subs) != 2 || subs["a"] != 1 || subs["b"] != 2 {
+		t.Fatalf("unexpected persisted subscriptions: %v, %v", subs, err)
+	}
+
+	c.Unsubscribe("a")
+	if _, err := packets.ReadPacket(broker); err != nil {
+		t.Fatalf("error reading unsubscribe: %v", err)
+	}
+	// The registry is saved after the UNSUBSCRIBE is queued so may lag the packet slightly
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		subs, _ := m.Load()
+		if len(subs) == 1 && subs["b"] == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected persisted subscriptions after unsubscribe: %v", subs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
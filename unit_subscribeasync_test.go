@@ -0,0 +1,82 @@
+package mqtt
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_SubscribeAsync(t *testing.T) {
+	conn, broker := net.Pipe()
+	defer broker.Close()
+	ops := NewClientOptions().AddBroker("tcp://127.0.0.1:1883").SetKeepAlive(0).SetAutoReconnect(false).
+		SetCustomOpenConnectionFn(func(uri *url.URL, options ClientOptions) (net.Conn, error) {
+			return conn, nil
+		})
+	c := NewClient(ops)
+	c.(ExtendedSubscriber).SubscribeAsync("a/#", 1, func(Client, Message) {}) // before Connect
+
+	token := c.Connect()
+	if _, err := packets.ReadPacket(broker); err != nil {
+		t.Fatalf("error reading connect: %v", err)
+	}
+	if err := packets.NewControlPacket(packets.Connack).Write(broker); err != nil {
+		t.Fatalf("error writing connack: %v", err)
+	}
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	defer c.(*client).forceDisconnect()
+
+	// The first attempt is rejected and the second accepted
+	for _, rc := range []byte{0x80, 1} {
+		broker.SetReadDeadline(time.Now().Add(5 * time.Second))
+		sub := ackSubscribe(t, broker, rc)
+		if len(sub.Topics) != 1 || sub.Topics[0] != "a/#" {
+			t.Fatalf("unexpected subscribe %v", sub.Topics)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := c.(*client).subs.snapshot()["a/#"]; ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("subscription not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// No further attempts are made once the subscription succeeds
+	broker.SetReadDeadline(time.Now().Add(2 * subscribeAsyncMinBackoff))
+	if cp, err := packets.ReadPacket(broker); err == nil {
+		t.Fatalf("unexpected packet after subscription acknowledged: %v", cp)
+	}
+}
+
+// Test_SubscribeAsync_stopped checks that SubscribeAsync stops retrying when Disconnect is called
+// before the client ever connected (no DisconnectedEvent is published in that case)
+func Test_SubscribeAsync_stopped(t *testing.T) {
+	c := NewClient(NewClientOptions()).(*client)
+	subscribers := func() int {
+		c.events.RLock()
+		defer c.events.RUnlock()
+		return len(c.events.handlers)
+	}
+	before := subscribers()
+	c.SubscribeAsync("a/#", 1, nil)
+	if subscribers() != before+1 {
+		t.Fatalf("expected SubscribeAsync to subscribe to events")
+	}
+
+	c.Disconnect(0)
+	for deadline := time.Now().Add(5 * time.Second); subscribers() != before; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("SubscribeAsync did not stop after Disconnect")
+		}
+	}
+}
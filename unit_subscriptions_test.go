@@ -0,0 +1,227 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_subscriptionRegistry(t *testing.T) {
+	ops := NewClientOptions().SetKeepAlive(0)
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	token := c.SubscribeMultiple(map[string]byte{"a": 1, "$share/g/b": 2, "c": 0}, nil)
+	// Reject "c"
+	ackSubscribeWith(t, broker, func(sub *packets.SubscribePacket) []byte {
+		var codes []byte
+		for _, topic := range sub.Topics {
+			switch topic {
+			case "a":
+				codes = append(codes, 1)
+			case "$share/g/b":
+				codes = append(codes, 1)
+			default:
+				codes = append(codes, 0x80)
+			}
+		}
+		return codes
+	})
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("subscribe token did not complete")
+	}
+
+	if token.Error() != nil {
+		t.Fatalf("partial rejection should not set the token error: %v", token.Error())
+	}
+	res := token.(*SubscribeToken).Results()
+	if res["a"].GrantedQoS != 1 || res["a"].Error != nil || res["c"].GrantedQoS != 0x80 || res["c"].Error != ErrSubscriptionRejected {
+		t.Fatalf("unexpected results: %v", res)
+	}
+
+	subs := c.subs.snapshot()
+	if len(subs) != 2 || subs["a"] != 1 || subs["$share/g/b"] != 1 {
+		t.Fatalf("unexpected registry contents: %v", subs)
+	}
+
+	c.Unsubscribe("a")
+	if _, err := packets.ReadPacket(broker); err != nil {
+		t.Fatalf("error reading unsubscribe: %v", err)
+	}
+	if subs := c.subs.snapshot(); len(subs) != 1 || subs["$share/g/b"] != 1 {
+		t.Fatalf("unexpected registry contents after unsubscribe: %v", subs)
+	}
+}
+
+func Test_SubscribeAllRejected(t *testing.T) {
+	ops := NewClientOptions().SetKeepAlive(0)
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	token := c.SubscribeMultiple(map[string]byte{"a": 1, "b": 2}, nil)
+	ackSubscribe(t, broker, 0x80, 0x80)
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("subscribe token did not complete")
+	}
+	if token.Error() != ErrSubscriptionRejected {
+		t.Fatalf("expected ErrSubscriptionRejected, got %v", token.Error())
+	}
+	if res := token.(*SubscribeToken).Results(); len(res) != 2 || res["a"].Error == nil || res["b"].Error == nil {
+		t.Fatalf("unexpected results: %v", res)
+	}
+	if subs := c.subs.snapshot(); len(subs) != 0 {
+		t.Fatalf("rejected filters should not be registered: %v", subs)
+	}
+}
+
+func Test_resubscribe(t *testing.T) {
+	ops := NewClientOptions().SetKeepAlive(0).SetAutoResubscribe(true)
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	c.subs.add("a/#", 1)
+	c.subs.add("b", 2)
+	go c.resubscribe()
+
+	sp := ackSubscribe(t, broker)
+	got := map[string]byte{}
+	for i, topic := range sp.Topics {
+		got[topic] = sp.Qoss[i]
+	}
+	if len(got) != 2 || got["a/#"] != 1 || got["b"] != 2 {
+		t.Fatalf("unexpected resubscribe: %v", got)
+	}
+}
+
+func Test_ResubscribeHook(t *testing.T) {
+	ops := NewClientOptions().SetKeepAlive(0).SetAutoResubscribe(true).
+		SetResubscribeHook(func(filter string, qos byte) byte {
+			if filter == "a" {
+				return 0
+			}
+			return qos
+		})
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	c.subs.add("a", 2)
+	c.subs.add("b", 2)
+	go c.resubscribe()
+
+	sp := ackSubscribe(t, broker)
+	for i, topic := range sp.Topics {
+		if exp := map[string]byte{"a": 0, "b": 2}[topic]; sp.Qoss[i] != exp {
+			t.Fatalf("expected %s to be resubscribed at QoS %d, got %d", topic, exp, sp.Qoss[i])
+		}
+	}
+}
+
+func Test_SkipResubscribeIfSessionPresent_default(t *testing.T) {
+	o := NewClientOptions()
+	if o.AutoResubscribe || !o.SkipResubscribeIfSessionPresent {
+		t.Fatalf("unexpected defaults, AutoResubscribe: %v, SkipResubscribeIfSessionPresent: %v",
+			o.AutoResubscribe, o.SkipResubscribeIfSessionPresent)
+	}
+}
+
+func Test_SubscribeFilters(t *testing.T) {
+	ops := NewClientOptions().SetKeepAlive(0)
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	received := make(chan string, 2)
+	token := c.SubscribeFilters([]SubscriptionFilter{
+		{Filter: "a/+", QoS: 1, Handler: func(c Client, m Message) { received <- "a:" + m.Topic() }},
+		{Filter: "b/#", QoS: 0, Handler: func(c Client, m Message) { received <- "b:" + m.Topic() }},
+	})
+	sp := ackSubscribe(t, broker, 1, 0)
+	if len(sp.Topics) != 2 || sp.Topics[0] != "a/+" || sp.Qoss[0] != 1 || sp.Topics[1] != "b/#" || sp.Qoss[1] != 0 {
+		t.Fatalf("unexpected subscribe: %v %v", sp.Topics, sp.Qoss)
+	}
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("subscribe failed: %v", token.Error())
+	}
+
+	for _, topic := range []string{"a/1", "b/2/3"} {
+		pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		pub.TopicName = topic
+		if err := pub.Write(broker); err != nil {
+			t.Fatalf("error writing publish: %v", err)
+		}
+	}
+	for _, exp := range []string{"a:a/1", "b:b/2/3"} {
+		select {
+		case got := <-received:
+			if got != exp {
+				t.Fatalf("expected %q, got %q", exp, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q not received", exp)
+		}
+	}
+
+	if err := c.SubscribeFilters(nil).Error(); err == nil {
+		t.Fatalf("expected an error subscribing to no filters")
+	}
+}
+
+func Test_SubscriptionTree(t *testing.T) {
+	c := NewClient(NewClientOptions()).(*client)
+	if tree := c.SubscriptionTree(); tree != "" {
+		t.Fatalf("expected an empty tree, got %q", tree)
+	}
+	c.subs.add("sensors/+/temperature", 1)
+	c.subs.add("sensors/+/humidity", 0)
+	c.subs.add("sensors/#", 2)
+	c.subs.add("alerts", 1)
+	c.subs.add("/root", 0)
+
+	exp := `""
+└── root (QoS 0)
+alerts (QoS 1)
+sensors
+├── # (QoS 2)
+└── +
+    ├── humidity (QoS 0)
+    └── temperature (QoS 1)
+`
+	if tree := c.SubscriptionTree(); tree != exp {
+		t.Fatalf("unexpected tree:\n%s\nexpected:\n%s", tree, exp)
+	}
+}
+
+func Test_UnsubscribeShared(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	token := c.Subscribe("$share/g/a/+", 1, func(c Client, m Message) {})
+	ackSubscribe(t, broker, 1)
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("subscribe did not complete")
+	}
+	if c.msgRouter.routes.Len() != 1 {
+		t.Fatalf("expected one route, got %d", c.msgRouter.routes.Len())
+	}
+
+	c.Unsubscribe("$share/g/a/+")
+	cp, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading unsubscribe: %v", err)
+	}
+	if topics := cp.(*packets.UnsubscribePacket).Topics; len(topics) != 1 || topics[0] != "$share/g/a/+" {
+		t.Fatalf("unexpected unsubscribe topics %v", topics)
+	}
+	if n := c.msgRouter.routes.Len(); n != 0 {
+		t.Fatalf("shared subscription route not removed (%d routes)", n)
+	}
+	if subs := c.subs.snapshot(); len(subs) != 0 {
+		t.Fatalf("shared subscription not removed from registry: %v", subs)
+	}
+}
@@ -0,0 +1,94 @@
+package mqtt
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// lockedBuffer is a bytes.Buffer that is safe for concurrent use
+type lockedBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.Lock()
+	defer b.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func Test_Tap(t *testing.T) {
+	conn, broker := net.Pipe()
+	defer broker.Close()
+	sink := &lockedBuffer{}
+	tap := NewTap(conn, sink)
+
+	cm := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	cm.ProtocolName = "MQTT"
+	cm.ProtocolVersion = 4
+	cm.ClientIdentifier = "tap"
+	written := make(chan error)
+	go func() { written <- cm.Write(tap) }()
+	if _, err := packets.ReadPacket(broker); err != nil {
+		t.Fatalf("error reading connect: %v", err)
+	}
+	if err := <-written; err != nil { // the packet is passed to the sink once the write completes
+		t.Fatalf("error writing connect: %v", err)
+	}
+
+	// The CONNACK is written in two parts to check that it is reassembled
+	var ca bytes.Buffer
+	packets.NewControlPacket(packets.Connack).Write(&ca)
+	go func() {
+		broker.Write(ca.Bytes()[:1])
+		broker.Write(ca.Bytes()[1:])
+	}()
+	if _, err := packets.ReadPacket(tap); err != nil {
+		t.Fatalf("error reading connack: %v", err)
+	}
+	tap.Close()
+
+	var records []byte
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if records = sink.Bytes(); len(records) > 0 && bytes.HasSuffix(records, ca.Bytes()) {
+			break
+		}
+	}
+	r := bytes.NewReader(records)
+	for _, exp := range []struct {
+		direction PacketDirection
+		packet    byte
+	}{{PacketSent, packets.Connect}, {PacketReceived, packets.Connack}} {
+		direction, ts, packet, err := ReadTapRecord(r)
+		if err != nil {
+			t.Fatalf("error reading record: %v", err)
+		}
+		if direction != exp.direction || time.Since(ts) > time.Minute {
+			t.Fatalf("unexpected record header: %v %v", direction, ts)
+		}
+		cp, err := packets.ReadPacket(bytes.NewReader(packet))
+		if err != nil {
+			t.Fatalf("record does not contain a packet: %v", err)
+		}
+		if packet[0]>>4 != exp.packet {
+			t.Fatalf("expected %s, got %s", packets.PacketNames[exp.packet], cp.String())
+		}
+	}
+	if r.Len() != 0 {
+		t.Fatalf("unexpected data after records")
+	}
+	if tap.(*Tap).Dropped() != 0 {
+		t.Fatalf("no packets should have been dropped")
+	}
+}
@@ -0,0 +1,61 @@
+package mqtt
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// foreignToken is a Token implemented without baseToken
+type foreignToken struct {
+	complete chan struct{}
+}
+
+func (f *foreignToken) Wait() bool {
+	<-f.complete
+	return true
+}
+
+func (f *foreignToken) WaitTimeout(d time.Duration) bool {
+	select {
+	case <-f.complete:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func (f *foreignToken) Error() error {
+	return nil
+}
+
+func Test_waitTokenContext(t *testing.T) {
+	for _, foreign := range []bool{false, true} {
+		newTok := func() (Token, func()) {
+			if foreign {
+				f := &foreignToken{complete: make(chan struct{})}
+				return f, func() { close(f.complete) }
+			}
+			pt := newToken(packets.Publish).(*PublishToken)
+			return pt, pt.flowComplete
+		}
+
+		tok, complete := newTok()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		if waitTokenContext(ctx, tok) {
+			t.Fatalf("foreign=%t: expected false when ctx is done first", foreign)
+		}
+		cancel()
+
+		tok, complete = newTok()
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			complete()
+		}()
+		if !waitTokenContext(context.Background(), tok) {
+			t.Fatalf("foreign=%t: expected true when the token completes", foreign)
+		}
+	}
+}
@@ -45,3 +45,25 @@ func Test_ValidateTopicAndQos_H(t *testing.T) {
 		t.Fatalf("invalid error for bad multilevel topic filter")
 	}
 }
+
+func Test_ValidateTopic(t *testing.T) {
+	tests := []struct {
+		topic string
+		err   error
+	}{
+		{"a/b", nil},
+		{"/", nil},
+		{"a//b", nil},
+		{"", ErrInvalidTopicEmptyString},
+		{"a/+/b", ErrInvalidTopicWildcard},
+		{"a/#", ErrInvalidTopicWildcard},
+		{"a/\x00", ErrInvalidTopicEncoding},
+		{"a/\xff", ErrInvalidTopicEncoding},
+		{string(make([]byte, 65536)), ErrInvalidTopicLength},
+	}
+	for _, tt := range tests {
+		if err := ValidateTopic(tt.topic); err != tt.err {
+			t.Errorf("ValidateTopic(%.10q) = %v, want %v", tt.topic, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_PerTopicOrdering(t *testing.T) {
+	ops := NewClientOptions().SetKeepAlive(0).SetPerTopicOrdering(true)
+	c, broker := newPipeClient(ops)
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	received := make(chan *packets.PublishPacket, 10)
+	go func() {
+		for {
+			cp, err := packets.ReadPacket(broker)
+			if err != nil {
+				return
+			}
+			if p, ok := cp.(*packets.PublishPacket); ok {
+				received <- p
+			}
+		}
+	}()
+	next := func() *packets.PublishPacket {
+		select {
+		case p := <-received:
+			return p
+		case <-time.After(5 * time.Second):
+			t.Fatalf("publish not received")
+		}
+		return nil
+	}
+
+	t1 := c.Publish("a", 1, false, "a1")
+	t2 := c.Publish("a", 1, false, "a2")
+	c.Publish("b", 1, false, "b1")
+
+	// a1 and b1 are sent in parallel, a2 waits for a1 to be acknowledged
+	got := map[string]*packets.PublishPacket{}
+	for i := 0; i < 2; i++ {
+		p := next()
+		got[string(p.Payload)] = p
+	}
+	if got["a1"] == nil || got["b1"] == nil {
+		t.Fatalf("expected a1 and b1, got %v", got)
+	}
+	select {
+	case p := <-received:
+		t.Fatalf("%s sent before the previous publish to the topic completed", p.Payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+	ack.MessageID = got["a1"].MessageID
+	if err := ack.Write(broker); err != nil {
+		t.Fatalf("error writing puback: %v", err)
+	}
+	if !t1.WaitTimeout(5 * time.Second) {
+		t.Fatalf("first publish did not complete")
+	}
+	if p := next(); string(p.Payload) != "a2" {
+		t.Fatalf("expected a2, got %s", p.Payload)
+	}
+	if t2.WaitTimeout(50 * time.Millisecond) {
+		t.Fatalf("second publish completed without an acknowledgement")
+	}
+}
+
+func Test_PerTopicOrdering_idleQueueRemoved(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0).SetPerTopicOrdering(true))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	token := c.Publish("a", 1, false, "a1")
+	cp, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+	ack.MessageID = cp.Details().MessageID
+	if err := ack.Write(broker); err != nil {
+		t.Fatalf("error writing puback: %v", err)
+	}
+	if !token.WaitTimeout(5 * time.Second) {
+		t.Fatalf("publish did not complete")
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		c.topicQueues.mu.Lock()
+		n := len(c.topicQueues.queues)
+		c.topicQueues.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("queue not removed once empty")
+		}
+	}
+}
+
+func Test_PerTopicOrdering_disconnect(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0).SetPerTopicOrdering(true))
+	defer broker.Close()
+
+	t1 := c.Publish("a", 1, false, "a1")
+	if _, err := packets.ReadPacket(broker); err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	t2 := c.Publish("a", 1, false, "a2") // waits for a1 to be acknowledged
+	t3 := c.Publish("a", 0, false, "a3")
+	if n := c.pendingPublishes(); n != 3 {
+		t.Fatalf("expected 3 pending publishes, got %d", n)
+	}
+
+	c.forceDisconnect()
+	for _, token := range []Token{t1, t2, t3} {
+		if !token.WaitTimeout(5*time.Second) || token.Error() == nil {
+			t.Fatalf("expected publish to fail on disconnect, got %v", token.Error())
+		}
+	}
+	c.topicQueues.mu.Lock()
+	defer c.topicQueues.mu.Unlock()
+	if len(c.topicQueues.queues) != 0 {
+		t.Fatalf("expected queues to be removed on disconnect")
+	}
+}
@@ -0,0 +1,51 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_TopicTemplate(t *testing.T) {
+	tmpl, err := NewTopicTemplate("devices/{{.DeviceID}}/telemetry")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	topic, err := tmpl.Render(struct{ DeviceID string }{"d1"})
+	if err != nil || topic != "devices/d1/telemetry" {
+		t.Fatalf("expected devices/d1/telemetry, got %q (%v)", topic, err)
+	}
+	if _, err := tmpl.Render(map[string]string{"DeviceID": "+"}); err != ErrInvalidTopicWildcard {
+		t.Fatalf("expected ErrInvalidTopicWildcard, got %v", err)
+	}
+	if _, err := tmpl.Render(map[string]string{}); err == nil {
+		t.Fatalf("expected error for missing key")
+	}
+	if _, err := NewTopicTemplate("devices/{{.DeviceID"); err == nil {
+		t.Fatalf("expected parse error")
+	}
+}
+
+func Test_PublishTemplate(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+
+	tmpl := MustTopicTemplate("devices/{{.}}/telemetry")
+	if err := c.PublishTemplate(tmpl, "a/#", 0, false, "x").Error(); err != ErrInvalidTopicWildcard {
+		t.Fatalf("expected ErrInvalidTopicWildcard, got %v", err)
+	}
+
+	token := c.PublishTemplate(tmpl, "d2", 0, false, "x")
+	cp, err := packets.ReadPacket(broker)
+	if err != nil {
+		t.Fatalf("error reading publish: %v", err)
+	}
+	if topic := cp.(*packets.PublishPacket).TopicName; topic != "devices/d2/telemetry" {
+		t.Fatalf("expected devices/d2/telemetry, got %q", topic)
+	}
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("publish failed: %v", token.Error())
+	}
+}
@@ -0,0 +1,96 @@
+package mqtt
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+func Test_SimulateWillTrigger(t *testing.T) {
+	conns := make(chan net.Conn, 2)
+	brokers := make(chan net.Conn, 2)
+	for i := 0; i < 2; i++ {
+		conn, broker := net.Pipe()
+		defer broker.Close()
+		conns <- conn
+		brokers <- broker
+	}
+	// accept reads the CONNECT from the next connection and accepts it
+	accept := func() net.Conn {
+		broker := <-brokers
+		cp, err := packets.ReadPacket(broker)
+		if err != nil {
+			t.Fatalf("error reading connect: %v", err)
+		}
+		if cm := cp.(*packets.ConnectPacket); !cm.WillFlag || cm.WillTopic != "status/c" {
+			t.Fatalf("unexpected will in connect: %s", cm.String())
+		}
+		if err := packets.NewControlPacket(packets.Connack).Write(broker); err != nil {
+			t.Fatalf("error writing connack: %v", err)
+		}
+		return broker
+	}
+
+	ops := NewClientOptions().AddBroker("tcp://127.0.0.1:1883").SetKeepAlive(0).SetAutoReconnect(false).
+		SetWill("status/c", "offline", 1, false).
+		SetCustomOpenConnectionFn(func(uri *url.URL, options ClientOptions) (net.Conn, error) {
+			return <-conns, nil
+		})
+	c := NewClient(ops)
+	token := c.Connect()
+	first := accept()
+	if !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("connect failed: %v", token.Error())
+	}
+	defer c.(*client).forceDisconnect()
+
+	observer, obroker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer obroker.Close()
+	defer observer.forceDisconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() { result <- SimulateWillTrigger(ctx, c, observer) }()
+
+	ackSubscribe(t, obroker, 1)
+
+	// The connection is dropped without a DISCONNECT so the broker publishes the will
+	if cp, err := packets.ReadPacket(first); err == nil {
+		t.Fatalf("expected the connection to be closed, got %s", cp.String())
+	}
+	will := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	will.TopicName = "status/c"
+	will.Payload = []byte("offline")
+	if err := will.Write(obroker); err != nil {
+		t.Fatalf("error writing will: %v", err)
+	}
+	go func() { // discard the UNSUBSCRIBE
+		for {
+			if _, err := packets.ReadPacket(obroker); err != nil {
+				return
+			}
+		}
+	}()
+
+	accept()
+	if err := <-result; err != nil {
+		t.Fatalf("SimulateWillTrigger failed: %v", err)
+	}
+	if !c.IsConnectionOpen() {
+		t.Fatalf("client should have been reconnected")
+	}
+}
+
+func Test_SimulateWillTrigger_noWill(t *testing.T) {
+	c, broker := newPipeClient(NewClientOptions().SetKeepAlive(0))
+	defer broker.Close()
+	defer c.forceDisconnect()
+	if err := SimulateWillTrigger(context.Background(), c, c); err == nil {
+		t.Fatalf("expected an error when no will is set")
+	}
+}
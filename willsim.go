@@ -0,0 +1,82 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+//ErrWillNotReceived is returned by SimulateWillTrigger if the observer does not receive the
+//will message before the context is done
+var ErrWillNotReceived = errors.New("will message not received")
+
+//ErrWillSimulation is the error passed to the ConnectionLostHandler (and in the DisconnectedEvent)
+//when SimulateWillTrigger drops the connection
+var ErrWillSimulation = errors.New("connection dropped to trigger will message")
+
+// SimulateWillTrigger is intended for integration tests of will message handling. It drops
+// the connection used by c without sending a DISCONNECT (so the broker should publish the will)
+// and checks that observer, a separate connected client, receives a message on the will topic.
+// Once the will is received c is reconnected (by the client itself if AutoReconnect is set,
+// otherwise by calling Connect) and SimulateWillTrigger waits for the connection to come up.
+// ctx limits the total time taken; ErrWillNotReceived is returned if the will does not arrive.
+// The connection is lost as far as c is concerned, so its OnConnectionLost handler is called with
+// ErrWillSimulation; handlers that should ignore simulated losses can check for that error.
+func SimulateWillTrigger(ctx context.Context, c Client, observer Client) error {
+	cl, ok := c.(*client)
+	if !ok {
+		return errors.New("client was not created by NewClient")
+	}
+	if !cl.options.WillEnabled {
+		return errors.New("no will message set")
+	}
+	if !cl.IsConnectionOpen() {
+		return ErrNotConnected
+	}
+
+	willTopic := cl.options.WillTopic
+	wills := make(chan Message, 1)
+	st := observer.Subscribe(willTopic, cl.options.WillQos, func(_ Client, m Message) {
+		select {
+		case wills <- m:
+		default:
+		}
+	})
+	if !waitTokenContext(ctx, st) {
+		return fmt.Errorf("unable to subscribe to will topic: %w", ctx.Err())
+	}
+	if err := st.Error(); err != nil {
+		return fmt.Errorf("unable to subscribe to will topic: %w", err)
+	}
+	defer observer.Unsubscribe(willTopic)
+
+	DEBUG.Println(CLI, "dropping connection to trigger will message")
+	cl.internalConnLost(ErrWillSimulation)
+
+	select {
+	case <-wills:
+	case <-ctx.Done():
+		return ErrWillNotReceived
+	}
+
+	if !cl.options.AutoReconnect {
+		ct := c.Connect()
+		if !waitTokenContext(ctx, ct) {
+			return ctx.Err()
+		}
+		if err := ct.Error(); err != nil {
+			return err
+		}
+	}
+	t := time.NewTicker(10 * time.Millisecond)
+	defer t.Stop()
+	for !cl.IsConnectionOpen() {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}